@@ -5,6 +5,7 @@ package bbolt
 
 import (
 	"fmt"
+	"os"
 	"syscall"
 	"time"
 	"unsafe"
@@ -13,12 +14,12 @@ import (
 )
 
 // flock acquires an advisory lock on a file descriptor.
-func flock(db *DB, exclusive bool, timeout time.Duration) error {
+func flock(f *os.File, exclusive bool, timeout time.Duration) error {
 	var t time.Time
 	if timeout != 0 {
 		t = time.Now()
 	}
-	fd := db.file.Fd()
+	fd := f.Fd()
 	var lockType int16
 	if exclusive {
 		lockType = syscall.F_WRLCK
@@ -55,37 +56,37 @@ func funlock(db *DB) error {
 	return syscall.FcntlFlock(uintptr(db.file.Fd()), syscall.F_SETLK, &lock)
 }
 
-// mmap memory maps a DB's data file.
-func mmap(db *DB, sz int) error {
+// mmap memory maps a DB's data file into the given mapping.
+func mmap(db *DB, g *mmapGen, sz int) error {
 	// Map the data file to memory.
 	b, err := unix.Mmap(int(db.file.Fd()), 0, sz, syscall.PROT_READ, syscall.MAP_SHARED|db.MmapFlags)
 	if err != nil {
 		return err
 	}
 
-	// Advise the kernel that the mmap is accessed randomly.
-	if err := unix.Madvise(b, syscall.MADV_RANDOM); err != nil {
+	// Advise the kernel how the mmap is accessed, randomly by default.
+	if err := madvise(b, db.mmapAdvice); err != nil {
 		return fmt.Errorf("madvise: %s", err)
 	}
 
 	// Save the original byte slice and convert to a byte array pointer.
-	db.dataref = b
-	db.data = (*[maxMapSize]byte)(unsafe.Pointer(&b[0]))
-	db.datasz = sz
+	g.dataref = b
+	g.data = (*[maxMapSize]byte)(unsafe.Pointer(&b[0]))
+	g.datasz = sz
 	return nil
 }
 
-// munmap unmaps a DB's data file from memory.
-func munmap(db *DB) error {
+// munmap unmaps a mapping of a DB's data file from memory.
+func munmap(g *mmapGen) error {
 	// Ignore the unmap if we have no mapped data.
-	if db.dataref == nil {
+	if g.dataref == nil {
 		return nil
 	}
 
 	// Unmap using the original byte slice.
-	err := unix.Munmap(db.dataref)
-	db.dataref = nil
-	db.data = nil
-	db.datasz = 0
+	err := unix.Munmap(g.dataref)
+	g.dataref = nil
+	g.data = nil
+	g.datasz = 0
 	return err
 }
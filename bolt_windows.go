@@ -18,7 +18,7 @@ func fdatasync(db *DB) error {
 }
 
 // flock acquires an advisory lock on a file descriptor.
-func flock(db *DB, exclusive bool, timeout time.Duration) error {
+func flock(f *os.File, exclusive bool, timeout time.Duration) error {
 	var t time.Time
 	if timeout != 0 {
 		t = time.Now()
@@ -31,7 +31,7 @@ func flock(db *DB, exclusive bool, timeout time.Duration) error {
 		// Fix for https://github.com/etcd-io/bbolt/issues/121. Use byte-range
 		// -1..0 as the lock on the database file.
 		var m1 uint32 = (1 << 32) - 1 // -1 in a uint32
-		err := windows.LockFileEx(windows.Handle(db.file.Fd()), flags, 0, 1, 0, &windows.Overlapped{
+		err := windows.LockFileEx(windows.Handle(f.Fd()), flags, 0, 1, 0, &windows.Overlapped{
 			Offset:     m1,
 			OffsetHigh: m1,
 		})
@@ -61,9 +61,9 @@ func funlock(db *DB) error {
 	})
 }
 
-// mmap memory maps a DB's data file.
+// mmap memory maps a DB's data file into the given mapping.
 // Based on: https://github.com/edsrzf/mmap-go
-func mmap(db *DB, sz int) error {
+func mmap(db *DB, g *mmapGen, sz int) error {
 	var sizelo, sizehi uint32
 
 	if !db.readOnly {
@@ -95,25 +95,25 @@ func mmap(db *DB, sz int) error {
 	}
 
 	// Convert to a byte array.
-	db.data = (*[maxMapSize]byte)(unsafe.Pointer(addr))
-	db.datasz = sz
+	g.data = (*[maxMapSize]byte)(unsafe.Pointer(addr))
+	g.datasz = sz
 
 	return nil
 }
 
 // munmap unmaps a pointer from a file.
 // Based on: https://github.com/edsrzf/mmap-go
-func munmap(db *DB) error {
-	if db.data == nil {
+func munmap(g *mmapGen) error {
+	if g.data == nil {
 		return nil
 	}
 
-	addr := (uintptr)(unsafe.Pointer(&db.data[0]))
+	addr := (uintptr)(unsafe.Pointer(&g.data[0]))
 	var err1 error
 	if err := syscall.UnmapViewOfFile(addr); err != nil {
 		err1 = os.NewSyscallError("UnmapViewOfFile", err)
 	}
-	db.data = nil
-	db.datasz = 0
+	g.data = nil
+	g.datasz = 0
 	return err1
 }
@@ -3,6 +3,7 @@ package bbolt
 import (
 	"bytes"
 	"fmt"
+	"runtime/debug"
 	"unsafe"
 
 	"go.etcd.io/bbolt/errors"
@@ -431,6 +432,9 @@ func (b *Bucket) recursivelyInspect(name []byte) BucketStructure {
 // The returned value is only valid for the life of the transaction.
 // The returned memory is owned by bbolt and must never be modified; writing to this memory might corrupt the database.
 func (b *Bucket) Get(key []byte) []byte {
+	if db := b.tx.db; db != nil && db.guarded {
+		defer db.recoverFault(debug.SetPanicOnFault(true))
+	}
 	k, v, flags := b.Cursor().seek(key)
 
 	// Return nil if this is a bucket.
@@ -493,6 +497,43 @@ func (b *Bucket) Put(key []byte, value []byte) (err error) {
 	return nil
 }
 
+// lastNode returns the leaf node holding the last key/value of the bucket,
+// which the key/values coming after it can be appended to by appendSorted.
+// It returns nil if the bucket isn't writable, or its last leaf is empty.
+func (b *Bucket) lastNode() *node {
+	if b.tx.db == nil || !b.Writable() {
+		return nil
+	}
+	c := b.Cursor()
+	p, n := b.pageNode(b.RootPage())
+	ref := elemRef{page: p, node: n}
+	ref.index = ref.count() - 1
+	c.stack = append(c.stack[:0], ref)
+	c.last()
+	if len(c.stack) > 1 && c.stack[len(c.stack)-1].count() == 0 {
+		return nil
+	}
+	return c.node()
+}
+
+// appendSorted puts a key/value like Put, into the node returned by
+// lastNode and without looking the key up, if the key comes after all the
+// keys of the node, as when key/values are put in order. It returns false
+// if the key/value must be put with Put instead.
+func (b *Bucket) appendSorted(n *node, key []byte, value []byte) bool {
+	if len(key) == 0 || len(key) > MaxKeySize || int64(len(value)) > MaxValueSize {
+		return false
+	} else if last := len(n.inodes) - 1; last >= 0 && bytes.Compare(n.inodes[last].Key(), key) >= 0 {
+		return false
+	}
+
+	n.inodes = append(n.inodes, common.Inode{})
+	inode := &n.inodes[len(n.inodes)-1]
+	inode.SetKey(cloneBytes(key))
+	inode.SetValue(value)
+	return true
+}
+
 // Delete removes a key from the bucket.
 // If the key does not exist then nothing is done and a nil error is returned.
 // Returns an error if the bucket was created from a read-only transaction.
@@ -911,17 +952,6 @@ func (b *Bucket) free() {
 	b.SetRootPage(0)
 }
 
-// dereference removes all references to the old mmap.
-func (b *Bucket) dereference() {
-	if b.rootNode != nil {
-		b.rootNode.root().dereference()
-	}
-
-	for _, child := range b.buckets {
-		child.dereference()
-	}
-}
-
 // pageNode returns the in-memory node, if it exists.
 // Otherwise, returns the underlying page.
 func (b *Bucket) pageNode(id common.Pgid) (*common.Page, *node) {
@@ -1,21 +1,39 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 
 	bolt "go.etcd.io/bbolt"
+	berrors "go.etcd.io/bbolt/errors"
 	"go.etcd.io/bbolt/internal/guts_cli"
 )
 
 type checkOptions struct {
-	fromPageID uint64
+	fromPageID  uint64
+	format      string
+	parallelism int
 }
 
 func (o *checkOptions) AddFlags(fs *pflag.FlagSet) {
 	fs.Uint64VarP(&o.fromPageID, "from-page", "", o.fromPageID, "check db integrity starting from the given page ID")
+	fs.StringVarP(&o.format, "format", "", "text", "output format, one of: text, json")
+	fs.IntVarP(&o.parallelism, "parallelism", "", 1, "number of workers checking the buckets concurrently")
+}
+
+func (o *checkOptions) Validate() error {
+	if o.format != "text" && o.format != "json" {
+		return fmt.Errorf("unsupported output format %q, must be one of: text, json", o.format)
+	}
+	if o.parallelism < 1 {
+		return fmt.Errorf("invalid parallelism %d, must be at least 1", o.parallelism)
+	}
+	return nil
 }
 
 func newCheckCommand() *cobra.Command {
@@ -25,6 +43,13 @@ func newCheckCommand() *cobra.Command {
 		Short: "verify integrity of bbolt database data",
 		Args:  cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := o.Validate(); err != nil {
+				return err
+			}
+			if o.format == "json" {
+				// Keep the output a valid JSON document.
+				cmd.SilenceUsage = true
+			}
 			return checkFunc(cmd, args[0], o)
 		},
 	}
@@ -52,10 +77,30 @@ func checkFunc(cmd *cobra.Command, dbPath string, cfg checkOptions) error {
 	if cfg.fromPageID != 0 {
 		opts = append(opts, bolt.WithPageId(cfg.fromPageID))
 	}
+	if cfg.parallelism > 1 {
+		opts = append(opts, bolt.WithParallelism(cfg.parallelism))
+	}
+
+	// Stop on Ctrl-C, and show the progress on a terminal.
+	ctx, cancel := interruptContext()
+	defer cancel()
+	bar := newProgressBar(cmd.ErrOrStderr(), "checking")
+	defer bar.Done()
+	opts = append(opts, bolt.WithContext(ctx), bolt.WithProgress(bar.Func()))
+
 	// Perform consistency check.
 	return db.View(func(tx *bolt.Tx) error {
+		if cfg.format == "json" {
+			return printCheckReport(ctx, cmd, bar, tx.Check(opts...))
+		}
+
 		var count int
 		for err := range tx.Check(opts...) {
+			if ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+				bar.Done()
+				return fmt.Errorf("check interrupted after %d errors: %w", count, err)
+			}
+			bar.Clear()
 			fmt.Fprintln(cmd.OutOrStdout(), err)
 			count++
 		}
@@ -71,3 +116,79 @@ func checkFunc(cmd *cobra.Command, dbPath string, cfg checkOptions) error {
 		return nil
 	})
 }
+
+// checkReport is the output of the check command with --format json.
+type checkReport struct {
+	OK     bool               `json:"ok"`
+	Errors []checkReportError `json:"errors"`
+}
+
+// checkReportError is an inconsistency found by the check command. The
+// fields other than the message are only set for an *errors.CheckError.
+type checkReportError struct {
+	Kind     string   `json:"kind,omitempty"`
+	Severity string   `json:"severity,omitempty"`
+	Pgid     uint64   `json:"pgid,omitempty"`
+	Stack    []uint64 `json:"stack,omitempty"`
+	Bucket   []string `json:"bucket,omitempty"`
+	Message  string   `json:"message"`
+}
+
+// printCheckReport prints the errors found by Tx.Check as a JSON document.
+func printCheckReport(ctx context.Context, cmd *cobra.Command, bar *progressBar, errs <-chan error) error {
+	r := checkReport{Errors: []checkReportError{}}
+	for err := range errs {
+		if ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+			// Don't print an incomplete report.
+			return fmt.Errorf("check interrupted: %w", err)
+		}
+		r.Errors = append(r.Errors, newCheckReportError(err))
+	}
+	r.OK = len(r.Errors) == 0
+	bar.Done()
+
+	out, err := json.MarshalIndent(r, "", "    ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(cmd.OutOrStdout(), string(out))
+	if !r.OK {
+		return guts_cli.ErrCorrupt
+	}
+	return nil
+}
+
+// checkErrorKinds are the stable names of the kinds of inconsistencies in
+// the JSON report.
+var checkErrorKinds = map[error]string{
+	berrors.ErrDoubleFree:         "DoubleFree",
+	berrors.ErrReachableFreed:     "ReachableFreed",
+	berrors.ErrUnreachableUnfreed: "UnreachableUnfreed",
+	berrors.ErrKeyOrder:           "KeyOrder",
+	berrors.ErrPageTypeMismatch:   "PageTypeMismatch",
+	berrors.ErrOutOfBounds:        "OutOfBounds",
+	berrors.ErrMultipleReferences: "MultipleReferences",
+}
+
+// newCheckReportError returns the report of an error found by Tx.Check.
+func newCheckReportError(err error) checkReportError {
+	e := checkReportError{Message: err.Error()}
+	var cErr *berrors.CheckError
+	if errors.As(err, &cErr) {
+		e.Kind = checkErrorKinds[cErr.Kind]
+		e.Severity = cErr.Severity.String()
+		e.Pgid = cErr.Pgid
+		e.Stack = cErr.Stack
+		e.Bucket = bucketPathStrings(cErr.Bucket)
+	}
+	return e
+}
+
+// bucketPathStrings returns the printable names of the buckets of a path.
+func bucketPathStrings(path [][]byte) []string {
+	var names []string
+	for _, name := range path {
+		names = append(names, CmdKvStringer().KeyToString(name))
+	}
+	return names
+}
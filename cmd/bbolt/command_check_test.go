@@ -2,14 +2,18 @@ package main_test
 
 import (
 	"bytes"
+	"encoding/json"
+	"fmt"
 	"io"
 	"testing"
 
 	"github.com/stretchr/testify/require"
 
+	bolt "go.etcd.io/bbolt"
 	main "go.etcd.io/bbolt/cmd/bbolt"
 	"go.etcd.io/bbolt/internal/btesting"
 	"go.etcd.io/bbolt/internal/guts_cli"
+	"go.etcd.io/bbolt/internal/surgeon"
 )
 
 func TestCheckCommand_Run(t *testing.T) {
@@ -37,6 +41,12 @@ func TestCheckCommand_Run(t *testing.T) {
 			expErr:    guts_cli.ErrCorrupt,
 			expOutput: "page ID (1) out of range [2, 4)",
 		},
+		{
+			name:      "check whole db in parallel",
+			args:      []string{"check", "path", "--parallelism", "4"},
+			expErr:    nil,
+			expOutput: "OK\n",
+		},
 	}
 
 	for _, tc := range testCases {
@@ -64,3 +74,71 @@ func TestCheckCommand_Run(t *testing.T) {
 		})
 	}
 }
+
+func TestCheckCommand_JSON(t *testing.T) {
+	t.Log("Creating sample DB")
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{PageSize: 4096})
+	err := db.Fill([]byte("data"), 1, 100,
+		func(tx int, k int) []byte { return []byte(fmt.Sprintf("%04d", k)) },
+		func(tx int, k int) []byte { return make([]byte, 100) },
+	)
+	require.NoError(t, err)
+	var rootPageId uint64
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		rootPageId = uint64(tx.Bucket([]byte("data")).RootPage())
+		return nil
+	}))
+	db.Close()
+
+	type report struct {
+		OK     bool `json:"ok"`
+		Errors []struct {
+			Kind     string   `json:"kind"`
+			Severity string   `json:"severity"`
+			Pgid     uint64   `json:"pgid"`
+			Stack    []uint64 `json:"stack"`
+			Bucket   []string `json:"bucket"`
+			Message  string   `json:"message"`
+		} `json:"errors"`
+	}
+	runCheck := func() (report, error) {
+		rootCmd := main.NewRootCommand()
+		outputBuf := bytes.NewBufferString("")
+		rootCmd.SetOut(outputBuf)
+		rootCmd.SetArgs([]string{"check", db.Path(), "--format", "json"})
+		cmdErr := rootCmd.Execute()
+
+		var r report
+		require.NoError(t, json.Unmarshal(outputBuf.Bytes(), &r), "unexpected stdout:\n\n%s", outputBuf.String())
+		return r, cmdErr
+	}
+
+	t.Log("Running check cmd on a valid db")
+	r, err := runCheck()
+	require.NoError(t, err)
+	require.True(t, r.OK)
+	require.Empty(t, r.Errors)
+
+	t.Log("Dropping all the leaf pages but the first one from the bucket")
+	page, _, err := guts_cli.ReadPage(db.Path(), rootPageId)
+	require.NoError(t, err)
+	dropped := len(page.BranchPageElements()) - 1
+	_, err = surgeon.ClearPageElements(db.Path(), page.Id(), 1, -1, false)
+	require.NoError(t, err)
+
+	t.Log("Running check cmd on the corrupted db")
+	r, err = runCheck()
+	require.ErrorIs(t, err, guts_cli.ErrCorrupt)
+	require.False(t, r.OK)
+	require.Len(t, r.Errors, dropped)
+	for _, e := range r.Errors {
+		require.Equal(t, "UnreachableUnfreed", e.Kind)
+		require.Equal(t, "warning", e.Severity)
+		require.Equal(t, fmt.Sprintf("page %d: unreachable unfreed", e.Pgid), e.Message)
+	}
+
+	t.Log("Running check cmd with invalid format")
+	rootCmd := main.NewRootCommand()
+	rootCmd.SetArgs([]string{"check", db.Path(), "--format", "xml"})
+	require.Error(t, rootCmd.Execute())
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+type fragmentationOptions struct {
+	format string
+}
+
+func (o *fragmentationOptions) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVarP(&o.format, "format", "", "text", "output format, one of: text, json")
+}
+
+func (o *fragmentationOptions) Validate() error {
+	if o.format != "text" && o.format != "json" {
+		return fmt.Errorf("unsupported output format %q, must be one of: text, json", o.format)
+	}
+	return nil
+}
+
+func newFragmentationCommand() *cobra.Command {
+	var o fragmentationOptions
+	fragmentationCmd := &cobra.Command{
+		Use:   "fragmentation <bbolt-file>",
+		Short: "report the free space fragmentation of the database",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := o.Validate(); err != nil {
+				return err
+			}
+			return fragmentationFunc(cmd, args[0], o)
+		},
+	}
+
+	o.AddFlags(fragmentationCmd.Flags())
+	return fragmentationCmd
+}
+
+func fragmentationFunc(cmd *cobra.Command, dbPath string, cfg fragmentationOptions) error {
+	if _, err := checkSourceDBPath(dbPath); err != nil {
+		return err
+	}
+
+	db, err := bolt.Open(dbPath, 0600, &bolt.Options{
+		ReadOnly:        true,
+		PreLoadFreelist: true,
+	})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.View(func(tx *bolt.Tx) error {
+		r, err := tx.FreeSpaceReport()
+		if err != nil {
+			return err
+		}
+
+		if cfg.format == "json" {
+			out, err := json.MarshalIndent(r, "", "    ")
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), string(out))
+			return nil
+		}
+		printFreeSpaceReport(cmd.OutOrStdout(), r)
+		return nil
+	})
+}
+
+func printFreeSpaceReport(w io.Writer, r bolt.FreeSpaceReport) {
+	size := int64(r.PageN) * int64(r.PageSize)
+	fmt.Fprintln(w, "File statistics")
+	fmt.Fprintf(w, "\tPage size: %d\n", r.PageSize)
+	fmt.Fprintf(w, "\tNumber of pages: %d (%d bytes)\n", r.PageN, size)
+
+	fmt.Fprintln(w, "Free space statistics")
+	fmt.Fprintf(w, "\tNumber of free pages: %d\n", r.FreePageN)
+	fmt.Fprintf(w, "\tNumber of pending pages: %d\n", r.PendingPageN)
+	fmt.Fprintf(w, "\tNumber of free pages at the end of the file: %d\n", r.TailFreePageN)
+
+	fmt.Fprintln(w, "Free span histogram")
+	for _, bin := range r.FreeSpans {
+		fmt.Fprintf(w, "\t%d-%d pages: %d spans (%d pages)\n", bin.MinSize, bin.MaxSize, bin.SpanN, bin.PageN)
+	}
+
+	fmt.Fprintln(w, "Bucket leaf locality")
+	for _, b := range r.Buckets {
+		fmt.Fprintf(w, "\t%s: %d leaf pages, average distance %.2f pages\n", strings.Join(b.Path, "/"), b.LeafPageN, b.AvgLeafDistance)
+	}
+
+	if len(r.Readers) > 0 {
+		fmt.Fprintln(w, "Pending pages by reader")
+		for _, rd := range r.Readers {
+			fmt.Fprintf(w, "\ttxid %d: %d pages\n", rd.Txid, rd.PendingPageN)
+		}
+	}
+
+	fmt.Fprintln(w, "Compaction estimate")
+	var gain float64
+	if r.EstimatedCompactedSize > 0 {
+		gain = float64(size) / float64(r.EstimatedCompactedSize)
+	}
+	fmt.Fprintf(w, "\tEstimated size after compaction: %d bytes (gain=%.2fx)\n", r.EstimatedCompactedSize, gain)
+}
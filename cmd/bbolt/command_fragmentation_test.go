@@ -0,0 +1,55 @@
+package main_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "go.etcd.io/bbolt"
+	main "go.etcd.io/bbolt/cmd/bbolt"
+	"go.etcd.io/bbolt/internal/btesting"
+)
+
+func TestFragmentationCommand_Run(t *testing.T) {
+	t.Log("Creating sample DB")
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{PageSize: 4096})
+	err := db.Fill([]byte("data"), 2, 200,
+		func(tx int, k int) []byte { return []byte(fmt.Sprintf("%04d%04d", tx, k)) },
+		func(tx int, k int) []byte { return make([]byte, 100) },
+	)
+	require.NoError(t, err)
+	db.Close()
+	defer requireDBNoChange(t, dbData(t, db.Path()), db.Path())
+
+	t.Log("Running fragmentation cmd with text output")
+	rootCmd := main.NewRootCommand()
+	outputBuf := bytes.NewBufferString("")
+	rootCmd.SetOut(outputBuf)
+	rootCmd.SetArgs([]string{"fragmentation", db.Path()})
+	require.NoError(t, rootCmd.Execute())
+	require.Contains(t, outputBuf.String(), "Free span histogram")
+	require.Contains(t, outputBuf.String(), "data: ")
+	require.Contains(t, outputBuf.String(), "Pending pages by reader")
+
+	t.Log("Running fragmentation cmd with json output")
+	rootCmd = main.NewRootCommand()
+	outputBuf = bytes.NewBufferString("")
+	rootCmd.SetOut(outputBuf)
+	rootCmd.SetArgs([]string{"fragmentation", db.Path(), "--format", "json"})
+	require.NoError(t, rootCmd.Execute())
+
+	var r bolt.FreeSpaceReport
+	require.NoError(t, json.Unmarshal(outputBuf.Bytes(), &r))
+	require.Equal(t, 4096, r.PageSize)
+	require.Len(t, r.Buckets, 1)
+	require.Equal(t, []string{"data"}, r.Buckets[0].Path)
+	require.Len(t, r.Readers, 1)
+
+	t.Log("Running fragmentation cmd with invalid format")
+	rootCmd = main.NewRootCommand()
+	rootCmd.SetArgs([]string{"fragmentation", db.Path(), "--format", "xml"})
+	require.Error(t, rootCmd.Execute())
+}
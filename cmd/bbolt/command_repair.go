@@ -0,0 +1,457 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	bolt "go.etcd.io/bbolt"
+	berrors "go.etcd.io/bbolt/errors"
+	"go.etcd.io/bbolt/internal/common"
+	"go.etcd.io/bbolt/internal/guts_cli"
+	"go.etcd.io/bbolt/internal/surgeon"
+)
+
+// maxPruneRounds limits the number of times the subtrees with critical
+// inconsistencies are pruned and the file checked again.
+const maxPruneRounds = 64
+
+type repairOptions struct {
+	surgeryBaseOptions
+	reportPath string
+	strategy   string
+}
+
+func (o *repairOptions) AddFlags(fs *pflag.FlagSet) {
+	o.surgeryBaseOptions.AddFlags(fs)
+	fs.StringVar(&o.reportPath, "report", "", "path to the JSON report of the repair, defaults to the output path with a .report.json suffix")
+	fs.StringVar(&o.strategy, "strategy", "auto", "repair strategy for the critical inconsistencies (auto, revert or prune)")
+}
+
+func (o *repairOptions) Validate() error {
+	if err := o.surgeryBaseOptions.Validate(); err != nil {
+		return err
+	}
+	switch o.strategy {
+	case "auto", "revert", "prune":
+		return nil
+	default:
+		return fmt.Errorf("unknown repair strategy %q, use auto, revert or prune", o.strategy)
+	}
+}
+
+func newRepairCommand() *cobra.Command {
+	var o repairOptions
+	repairCmd := &cobra.Command{
+		Use:   "repair <bbolt-file>",
+		Short: "repair a corrupted bbolt database into a new file",
+		Long: `Repair checks the database and writes a repaired copy to the output path.
+
+The inconsistencies found decide how the database is repaired:
+  - the freelist is rebuilt if only the free pages are inconsistent;
+  - the meta page is reverted if the critical inconsistencies are all in
+    pages written by the last transaction, and the previous transaction
+    is consistent;
+  - otherwise the subtrees with critical inconsistencies are pruned, and
+    the readable key/values are compacted into the output.
+
+With --strategy revert or --strategy prune, the critical inconsistencies
+are only repaired that way. Everything dropped is listed in the JSON report.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := o.Validate(); err != nil {
+				return err
+			}
+			if o.reportPath == "" {
+				o.reportPath = o.outputDBFilePath + ".report.json"
+			}
+			return repairFunc(cmd, args[0], o)
+		},
+	}
+	o.AddFlags(repairCmd.Flags())
+	return repairCmd
+}
+
+// repairReport is the report of the repair command.
+type repairReport struct {
+	Source    string             `json:"source"`
+	Output    string             `json:"output"`
+	OK        bool               `json:"ok"`
+	Findings  []checkReportError `json:"findings"`
+	Actions   []string           `json:"actions"`
+	Dropped   []repairDropped    `json:"dropped"`
+	Remaining []checkReportError `json:"remaining,omitempty"`
+}
+
+// repairDropped is data dropped by the repair command, a subtree of a bucket
+// or a whole transaction.
+type repairDropped struct {
+	Reason string   `json:"reason"`
+	Bucket []string `json:"bucket,omitempty"`
+	Pgid   uint64   `json:"pgid,omitempty"`
+	Keys   int      `json:"keys,omitempty"`
+	Txid   uint64   `json:"txid,omitempty"`
+}
+
+func (r *repairReport) addAction(format string, args ...interface{}) {
+	r.Actions = append(r.Actions, fmt.Sprintf(format, args...))
+}
+
+func repairFunc(cmd *cobra.Command, srcDBPath string, cfg repairOptions) error {
+	fi, err := checkSourceDBPath(srcDBPath)
+	if err != nil {
+		return err
+	}
+
+	findings, err := checkFile(srcDBPath)
+	if err != nil {
+		return fmt.Errorf("[repair] check failed: %w", err)
+	}
+	r := &repairReport{
+		Source:   srcDBPath,
+		Output:   cfg.outputDBFilePath,
+		Findings: []checkReportError{},
+		Actions:  []string{},
+		Dropped:  []repairDropped{},
+	}
+	for _, e := range findings {
+		r.Findings = append(r.Findings, newCheckReportError(e))
+	}
+
+	if _, err := os.Stat(cfg.outputDBFilePath); err == nil {
+		return fmt.Errorf("[repair] output file %q already exists", cfg.outputDBFilePath)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	if err := repair(r, srcDBPath, cfg.outputDBFilePath, findings, fi.Mode(), cfg.strategy); err != nil {
+		// Don't leave a partially repaired file behind.
+		_ = os.Remove(cfg.outputDBFilePath)
+		return err
+	}
+
+	// Verify the result.
+	remaining, err := checkFile(cfg.outputDBFilePath)
+	if err != nil {
+		return fmt.Errorf("[repair] check of the output failed: %w", err)
+	}
+	for _, e := range remaining {
+		r.Remaining = append(r.Remaining, newCheckReportError(e))
+	}
+	r.OK = len(remaining) == 0
+
+	out, err := json.MarshalIndent(r, "", "    ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(cfg.reportPath, append(out, '\n'), 0600); err != nil {
+		return fmt.Errorf("[repair] write report failed: %w", err)
+	}
+
+	w := cmd.OutOrStdout()
+	fmt.Fprintf(w, "%d inconsistencies found.\n", len(r.Findings))
+	for _, action := range r.Actions {
+		fmt.Fprintf(w, "- %s\n", action)
+	}
+	if !r.OK {
+		fmt.Fprintf(w, "%d inconsistencies left in the output, see the report at %s\n", len(r.Remaining), cfg.reportPath)
+		return guts_cli.ErrCorrupt
+	}
+	fmt.Fprintf(w, "The database was repaired, %d subtrees or transactions were dropped, see the report at %s\n", len(r.Dropped), cfg.reportPath)
+	return nil
+}
+
+// repair writes the repaired copy of the source file to the output path,
+// choosing the strategy from the inconsistencies found unless it's given.
+func repair(r *repairReport, srcPath, dstPath string, findings []error, mode os.FileMode, strategy string) error {
+	if len(findings) == 0 {
+		if err := common.CopyFile(srcPath, dstPath); err != nil {
+			return fmt.Errorf("[repair] copy file failed: %w", err)
+		}
+		r.addAction("copied the file, nothing to repair")
+		return nil
+	}
+
+	critical := criticalFindings(findings)
+	if len(critical) == 0 {
+		if err := common.CopyFile(srcPath, dstPath); err != nil {
+			return fmt.Errorf("[repair] copy file failed: %w", err)
+		}
+		return rebuildFreelist(r, dstPath, mode)
+	}
+
+	switch strategy {
+	case "revert":
+		reverted, err := revertMeta(r, srcPath, dstPath, mode)
+		if err == nil && !reverted {
+			err = errors.New("[repair] the previous transaction has critical inconsistencies too")
+		}
+		return err
+	case "prune":
+		return pruneAndCompact(r, srcPath, dstPath, mode)
+	}
+
+	// Reverting the last transaction drops less data than pruning if the
+	// inconsistencies are in the pages it wrote, and fixes nothing otherwise.
+	if writtenByLastTx(srcPath, critical) {
+		if reverted, err := revertMeta(r, srcPath, dstPath, mode); err != nil || reverted {
+			return err
+		}
+	}
+	return pruneAndCompact(r, srcPath, dstPath, mode)
+}
+
+// writtenByLastTx returns true if all the critical inconsistencies are in
+// pages written by the last transaction, i.e. the pages which aren't part of
+// the tree of the previous transaction.
+func writtenByLastTx(path string, critical []error) bool {
+	_, active, err := guts_cli.GetRootPage(path)
+	if err != nil {
+		return false
+	}
+	_, buf, err := guts_cli.ReadPage(path, uint64(1-active))
+	if err != nil {
+		return false
+	}
+	prev := common.LoadPageMeta(buf)
+	if prev.Validate() != nil {
+		return false
+	}
+
+	reachable := surgeon.ReachablePages(path, prev.RootBucket().RootPage())
+	for _, f := range critical {
+		var cErr *berrors.CheckError
+		if !errors.As(f, &cErr) || reachable[common.Pgid(cErr.Pgid)] {
+			return false
+		}
+	}
+	return true
+}
+
+// rebuildFreelist rebuilds the freelist by scanning the reachable pages.
+func rebuildFreelist(r *repairReport, path string, mode os.FileMode) error {
+	if err := surgeon.ClearFreelist(path); err != nil {
+		return fmt.Errorf("[repair] abandon freelist failed: %w", err)
+	}
+	// bboltDB automatically reconstruct & sync freelist in write mode.
+	db, err := bolt.Open(path, mode, &bolt.Options{NoFreelistSync: false})
+	if err != nil {
+		return fmt.Errorf("[repair] open db file failed: %w", err)
+	}
+	if err := db.Close(); err != nil {
+		return fmt.Errorf("[repair] close db file failed: %w", err)
+	}
+	r.addAction("rebuilt the freelist")
+	return nil
+}
+
+// revertMeta reverts the meta page to drop the last transaction, and keeps
+// the result if it has no critical inconsistencies.
+func revertMeta(r *repairReport, srcPath, dstPath string, mode os.FileMode) (bool, error) {
+	tmpPath := dstPath + ".revert"
+	defer os.Remove(tmpPath)
+	if err := common.CopyFile(srcPath, tmpPath); err != nil {
+		return false, fmt.Errorf("[repair] copy file failed: %w", err)
+	}
+
+	meta, _, err := guts_cli.GetActiveMetaPage(tmpPath)
+	if err != nil {
+		return false, fmt.Errorf("[repair] read meta page failed: %w", err)
+	}
+	txid := meta.Txid()
+	if err := surgeon.RevertMetaPage(tmpPath); err != nil {
+		return false, fmt.Errorf("[repair] revert meta page failed: %w", err)
+	}
+
+	findings, err := checkFile(tmpPath)
+	if err != nil || len(criticalFindings(findings)) > 0 {
+		// The previous transaction is corrupted as well.
+		return false, nil
+	}
+	if err := os.Rename(tmpPath, dstPath); err != nil {
+		return false, fmt.Errorf("[repair] rename failed: %w", err)
+	}
+	r.addAction("reverted the meta page, dropping transaction %d", txid)
+	r.Dropped = append(r.Dropped, repairDropped{Reason: "reverted transaction", Txid: uint64(txid)})
+
+	if len(findings) > 0 {
+		return true, rebuildFreelist(r, dstPath, mode)
+	}
+	return true, nil
+}
+
+// pruneAndCompact prunes the subtrees with critical inconsistencies from a
+// copy of the source file, then compacts it into the output path.
+func pruneAndCompact(r *repairReport, srcPath, dstPath string, mode os.FileMode) error {
+	workPath := dstPath + ".prune"
+	defer os.Remove(workPath)
+	if err := common.CopyFile(srcPath, workPath); err != nil {
+		return fmt.Errorf("[repair] copy file failed: %w", err)
+	}
+
+	for round := 0; ; round++ {
+		findings, err := checkFile(workPath)
+		if err != nil {
+			return fmt.Errorf("[repair] check failed: %w", err)
+		}
+		critical := criticalFindings(findings)
+		if len(critical) == 0 {
+			break
+		}
+		if round == maxPruneRounds {
+			return fmt.Errorf("[repair] %d critical inconsistencies left after pruning %d times, first: %v", len(critical), round, critical[0])
+		}
+
+		// The pages of a pruned subtree may be reported more than once, or
+		// be parents of the pages of other inconsistencies, which are
+		// pruned on the next round if they're still found.
+		touched := make(map[common.Pgid]bool)
+		var pruned int
+		for _, f := range critical {
+			ok, err := prune(r, workPath, f, touched)
+			if err != nil {
+				return err
+			}
+			if ok {
+				pruned++
+			}
+		}
+		if pruned == 0 {
+			return fmt.Errorf("[repair] can't prune the subtree of the inconsistency: %v", critical[0])
+		}
+	}
+
+	src, err := bolt.Open(workPath, 0400, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		return fmt.Errorf("[repair] open pruned db file failed: %w", err)
+	}
+	defer src.Close()
+	dst, err := bolt.Open(dstPath, mode, nil)
+	if err != nil {
+		return fmt.Errorf("[repair] open output db file failed: %w", err)
+	}
+	defer dst.Close()
+	if err := bolt.Compact(dst, src, 65536); err != nil {
+		return fmt.Errorf("[repair] compact failed: %w", err)
+	}
+	r.addAction("compacted the readable key/values into the output")
+	return nil
+}
+
+// prune drops the subtree of a critical inconsistency: the reference to its
+// page in the parent branch page, or the bucket if it's the root page of the
+// bucket. It returns false if the inconsistency can't be pruned yet.
+func prune(r *repairReport, path string, f error, touched map[common.Pgid]bool) (bool, error) {
+	var cErr *berrors.CheckError
+	if !errors.As(f, &cErr) || len(cErr.Stack) == 0 {
+		return false, nil
+	}
+	target, stack := pruneTarget(cErr)
+	for _, id := range cErr.Stack {
+		if touched[common.Pgid(id)] {
+			return false, nil
+		}
+	}
+
+	// Drop the parent instead of leaving an empty branch page.
+	for len(stack) > 0 {
+		p, _, err := guts_cli.ReadPage(path, uint64(stack[len(stack)-1]))
+		if err != nil {
+			return false, fmt.Errorf("[repair] read page failed: %w", err)
+		}
+		if p.Count() > 1 {
+			break
+		}
+		target, stack = stack[len(stack)-1], stack[:len(stack)-1]
+	}
+
+	keys := surgeon.CountKeys(path, target)
+	if len(stack) == 0 {
+		// The root page of the bucket is corrupted, drop the bucket.
+		if len(cErr.Bucket) == 0 {
+			return false, nil
+		}
+		leaf, index, err := surgeon.FindBucketEntry(path, cErr.Bucket)
+		if err != nil {
+			return false, fmt.Errorf("[repair] find bucket %v failed: %w", bucketPathStrings(cErr.Bucket), err)
+		}
+		if touched[leaf] {
+			return false, nil
+		}
+		if _, err := surgeon.ClearPageElements(path, leaf, index, index+1, false); err != nil {
+			return false, fmt.Errorf("[repair] drop bucket %v failed: %w", bucketPathStrings(cErr.Bucket), err)
+		}
+		touched[leaf] = true
+	} else {
+		parent := stack[len(stack)-1]
+		if touched[parent] {
+			return false, nil
+		}
+		if err := surgeon.PruneBranchElement(path, parent, target); err != nil {
+			return false, fmt.Errorf("[repair] prune page %d failed: %w", target, err)
+		}
+		touched[parent] = true
+	}
+	touched[target] = true
+
+	r.addAction("pruned page %d of bucket %v (%s)", target, bucketPathStrings(cErr.Bucket), cErr.Kind)
+	r.Dropped = append(r.Dropped, repairDropped{
+		Reason: cErr.Kind.Error(),
+		Bucket: bucketPathStrings(cErr.Bucket),
+		Pgid:   uint64(target),
+		Keys:   keys,
+	})
+	return true, nil
+}
+
+// pruneTarget returns the page to prune for an inconsistency, and the pages
+// from the root of the bucket to its parent.
+func pruneTarget(e *berrors.CheckError) (common.Pgid, []common.Pgid) {
+	stack := make([]common.Pgid, len(e.Stack))
+	for i, id := range e.Stack {
+		stack[i] = common.Pgid(id)
+	}
+	last := stack[len(stack)-1]
+	if errors.Is(e.Kind, berrors.ErrKeyOrder) && common.Pgid(e.Pgid) != last {
+		// A key of a branch page, the stack ends with the branch page.
+		return common.Pgid(e.Pgid), stack
+	}
+	return last, stack[:len(stack)-1]
+}
+
+// criticalFindings returns the inconsistencies which can't be fixed by
+// rebuilding the freelist, including the pages which can't be read.
+func criticalFindings(findings []error) []error {
+	var critical []error
+	for _, f := range findings {
+		var cErr *berrors.CheckError
+		if !errors.As(f, &cErr) || cErr.Severity == berrors.SeverityCritical {
+			critical = append(critical, f)
+		}
+	}
+	return critical
+}
+
+// checkFile returns the inconsistencies found by Tx.Check in the file.
+func checkFile(path string) ([]error, error) {
+	db, err := bolt.Open(path, 0400, &bolt.Options{
+		ReadOnly:     true,
+		GuardedReads: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var findings []error
+	err = db.View(func(tx *bolt.Tx) error {
+		for e := range tx.Check() {
+			findings = append(findings, e)
+		}
+		return nil
+	})
+	return findings, err
+}
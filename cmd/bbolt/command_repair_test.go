@@ -0,0 +1,282 @@
+package main_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "go.etcd.io/bbolt"
+	main "go.etcd.io/bbolt/cmd/bbolt"
+	"go.etcd.io/bbolt/internal/btesting"
+	"go.etcd.io/bbolt/internal/common"
+	"go.etcd.io/bbolt/internal/guts_cli"
+	"go.etcd.io/bbolt/internal/surgeon"
+)
+
+// repairReport is the part of the report of the repair command checked by
+// the tests.
+type repairReport struct {
+	OK       bool `json:"ok"`
+	Findings []struct {
+		Kind     string `json:"kind"`
+		Severity string `json:"severity"`
+	} `json:"findings"`
+	Actions []string `json:"actions"`
+	Dropped []struct {
+		Reason string   `json:"reason"`
+		Bucket []string `json:"bucket"`
+		Pgid   uint64   `json:"pgid"`
+		Keys   int      `json:"keys"`
+		Txid   uint64   `json:"txid"`
+	} `json:"dropped"`
+}
+
+func TestRepairCommand_Run(t *testing.T) {
+	testCases := []struct {
+		name    string
+		corrupt func(t *testing.T, path string)
+		actions []string
+		check   func(t *testing.T, db *bolt.DB, r repairReport)
+	}{
+		{
+			name:    "consistent db",
+			corrupt: func(t *testing.T, path string) {},
+			actions: []string{"copied the file, nothing to repair"},
+			check: func(t *testing.T, db *bolt.DB, r repairReport) {
+				require.Empty(t, r.Findings)
+				require.Equal(t, 1000, keyCount(t, db, "data"))
+				require.Equal(t, 1, keyCount(t, db, "other"))
+			},
+		},
+		{
+			name: "unreachable pages",
+			corrupt: func(t *testing.T, path string) {
+				// Drop the first child of the bucket, without freeing its pages.
+				_, err := surgeon.ClearPageElements(path, bucketRootPage(t, path, "data"), 0, 1, false)
+				require.NoError(t, err)
+			},
+			actions: []string{"rebuilt the freelist"},
+			check: func(t *testing.T, db *bolt.DB, r repairReport) {
+				require.NotEmpty(t, r.Findings)
+				for _, f := range r.Findings {
+					require.Equal(t, "warning", f.Severity)
+				}
+				require.Empty(t, r.Dropped)
+				require.Equal(t, 1, keyCount(t, db, "other"))
+			},
+		},
+		{
+			name: "corrupted last transaction",
+			corrupt: func(t *testing.T, path string) {
+				// The root page was written by the last transaction.
+				root, _, err := guts_cli.GetRootPage(path)
+				require.NoError(t, err)
+				corruptLeafKey(t, path, root, 1)
+			},
+			actions: []string{"reverted the meta page, dropping transaction"},
+			check: func(t *testing.T, db *bolt.DB, r repairReport) {
+				require.Len(t, r.Dropped, 1)
+				require.Equal(t, "reverted transaction", r.Dropped[0].Reason)
+				require.NotZero(t, r.Dropped[0].Txid)
+				require.Equal(t, 1000, keyCount(t, db, "data"))
+				require.Equal(t, -1, keyCount(t, db, "other"))
+			},
+		},
+		{
+			name: "corrupted leaf page",
+			corrupt: func(t *testing.T, path string) {
+				// The leaf page is referenced by both meta pages.
+				p, _, err := guts_cli.ReadPage(path, uint64(bucketRootPage(t, path, "data")))
+				require.NoError(t, err)
+				corruptLeafKey(t, path, p.BranchPageElement(3).Pgid(), 1)
+			},
+			actions: []string{"pruned page", "compacted the readable key/values into the output"},
+			check: func(t *testing.T, db *bolt.DB, r repairReport) {
+				require.Len(t, r.Dropped, 1)
+				require.Equal(t, []string{"data"}, r.Dropped[0].Bucket)
+				require.NotZero(t, r.Dropped[0].Keys)
+				require.Equal(t, 1000-r.Dropped[0].Keys, keyCount(t, db, "data"))
+				require.Equal(t, 1, keyCount(t, db, "other"))
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db := btesting.MustCreateDBWithOption(t, &bolt.Options{PageSize: 4096})
+			require.NoError(t, db.Fill([]byte("data"), 1, 1000,
+				func(tx int, k int) []byte { return []byte(fmt.Sprintf("%04d", k)) },
+				func(tx int, k int) []byte { return make([]byte, 100) },
+			))
+			require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+				b, err := tx.CreateBucket([]byte("other"))
+				if err != nil {
+					return err
+				}
+				return b.Put([]byte("key"), []byte("value"))
+			}))
+			srcPath := db.Path()
+			require.NoError(t, db.Close())
+			tc.corrupt(t, srcPath)
+
+			output := filepath.Join(t.TempDir(), "db")
+			rootCmd := main.NewRootCommand()
+			outputBuf := bytes.NewBufferString("")
+			rootCmd.SetOut(outputBuf)
+			rootCmd.SetArgs([]string{"repair", srcPath, "--output", output})
+			require.NoError(t, rootCmd.Execute(), outputBuf.String())
+
+			data, err := os.ReadFile(output + ".report.json")
+			require.NoError(t, err)
+			var r repairReport
+			require.NoError(t, json.Unmarshal(data, &r))
+			require.True(t, r.OK)
+			require.Len(t, r.Actions, len(tc.actions))
+			for i, action := range tc.actions {
+				require.Contains(t, r.Actions[i], action)
+			}
+
+			repaired, err := bolt.Open(output, 0600, nil)
+			require.NoError(t, err)
+			defer repaired.Close()
+			require.NoError(t, repaired.View(func(tx *bolt.Tx) error {
+				for err := range tx.Check() {
+					return err
+				}
+				return nil
+			}))
+			tc.check(t, repaired, r)
+		})
+	}
+}
+
+// bucketRootPage returns the root page of a top level bucket of the file.
+func bucketRootPage(t *testing.T, path string, name string) common.Pgid {
+	leaf, index, err := surgeon.FindBucketEntry(path, [][]byte{[]byte(name)})
+	require.NoError(t, err)
+	p, _, err := guts_cli.ReadPage(path, uint64(leaf))
+	require.NoError(t, err)
+	return p.LeafPageElement(uint16(index)).Bucket().RootPage()
+}
+
+// corruptLeafKey makes the key at the given index of a leaf page sort before
+// the previous key.
+func corruptLeafKey(t *testing.T, path string, pgId common.Pgid, index uint16) {
+	p, buf, err := guts_cli.ReadPage(path, uint64(pgId))
+	require.NoError(t, err)
+	require.True(t, p.IsLeafPage())
+	p.LeafPageElement(index).Key()[0] = 0
+	require.NoError(t, guts_cli.WritePage(path, buf))
+}
+
+// keyCount returns the number of keys of a top level bucket, or -1 if it
+// doesn't exist.
+func keyCount(t *testing.T, db *bolt.DB, name string) int {
+	count := -1
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(name)); b != nil {
+			count = b.Stats().KeyN
+		}
+		return nil
+	}))
+	return count
+}
+
+func TestRepairCommand_Strategy(t *testing.T) {
+	testCases := []struct {
+		name     string
+		strategy string
+		// shared corrupts a leaf page referenced by both meta pages instead
+		// of the one written by the last transaction.
+		shared  bool
+		actions []string
+		keys    int
+	}{
+		{
+			name:    "auto reverts the last transaction",
+			actions: []string{"reverted the meta page, dropping transaction"},
+			keys:    1000,
+		},
+		{
+			name:     "prune",
+			strategy: "prune",
+			actions:  []string{"pruned page", "compacted the readable key/values into the output"},
+		},
+		{
+			name:     "revert",
+			strategy: "revert",
+			actions:  []string{"reverted the meta page, dropping transaction"},
+			keys:     1000,
+		},
+		{
+			name:     "revert of a corrupted previous transaction",
+			strategy: "revert",
+			shared:   true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db := btesting.MustCreateDBWithOption(t, &bolt.Options{PageSize: 4096})
+			require.NoError(t, db.Fill([]byte("data"), 1, 1000,
+				func(tx int, k int) []byte { return []byte(fmt.Sprintf("%04d", k)) },
+				func(tx int, k int) []byte { return make([]byte, 100) },
+			))
+			// The last transaction rewrites the leaf page of the key.
+			require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+				return tx.Bucket([]byte("data")).Put([]byte("0500"), []byte("value"))
+			}))
+			srcPath := db.Path()
+			require.NoError(t, db.Close())
+
+			key := []byte("0500")
+			if tc.shared {
+				key = []byte("0000")
+			}
+			paths, err := surgeon.NewXRay(srcPath).FindPathsToKey(key)
+			require.NoError(t, err)
+			require.Len(t, paths, 1)
+			corruptLeafKey(t, srcPath, paths[0][len(paths[0])-1], 1)
+
+			output := filepath.Join(t.TempDir(), "db")
+			rootCmd := main.NewRootCommand()
+			rootCmd.SetOut(bytes.NewBufferString(""))
+			args := []string{"repair", srcPath, "--output", output}
+			if tc.strategy != "" {
+				args = append(args, "--strategy", tc.strategy)
+			}
+			rootCmd.SetArgs(args)
+			err = rootCmd.Execute()
+			if tc.actions == nil {
+				require.Error(t, err)
+				// The partial output is removed.
+				require.NoFileExists(t, output)
+				return
+			}
+			require.NoError(t, err)
+
+			data, err := os.ReadFile(output + ".report.json")
+			require.NoError(t, err)
+			var r repairReport
+			require.NoError(t, json.Unmarshal(data, &r))
+			require.True(t, r.OK)
+			require.Len(t, r.Actions, len(tc.actions))
+			for i, action := range tc.actions {
+				require.Contains(t, r.Actions[i], action)
+			}
+
+			repaired, err := bolt.Open(output, 0600, nil)
+			require.NoError(t, err)
+			defer repaired.Close()
+			if tc.keys == 0 {
+				tc.keys = 1000 - r.Dropped[0].Keys
+			}
+			require.Equal(t, tc.keys, keyCount(t, repaired, "data"))
+		})
+	}
+}
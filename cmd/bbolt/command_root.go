@@ -21,6 +21,8 @@ func NewRootCommand() *cobra.Command {
 		newSurgeryCommand(),
 		newInspectCommand(),
 		newCheckCommand(),
+		newFragmentationCommand(),
+		newRepairCommand(),
 	)
 
 	return rootCmd
@@ -8,6 +8,7 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 
+	bolt "go.etcd.io/bbolt"
 	"go.etcd.io/bbolt/internal/common"
 	"go.etcd.io/bbolt/internal/guts_cli"
 	"go.etcd.io/bbolt/internal/surgeon"
@@ -29,6 +30,8 @@ func newSurgeryCommand() *cobra.Command {
 	surgeryCmd.AddCommand(newSurgeryClearPageElementsCommand())
 	surgeryCmd.AddCommand(newSurgeryFreelistCommand())
 	surgeryCmd.AddCommand(newSurgeryMetaCommand())
+	surgeryCmd.AddCommand(newSurgeryScrubFreeCommand())
+	surgeryCmd.AddCommand(newSurgerySalvageCommand())
 
 	return surgeryCmd
 }
@@ -279,6 +282,50 @@ func surgeryClearPageElementFunc(srcDBPath string, cfg surgeryClearPageElementsO
 	return nil
 }
 
+func newSurgeryScrubFreeCommand() *cobra.Command {
+	var o surgeryBaseOptions
+	scrubFreeCmd := &cobra.Command{
+		Use:   "scrub-free <bbolt-file>",
+		Short: "Overwrite all free pages with zeros",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := o.Validate(); err != nil {
+				return err
+			}
+			return surgeryScrubFreeFunc(args[0], o)
+		},
+	}
+	o.AddFlags(scrubFreeCmd.Flags())
+	return scrubFreeCmd
+}
+
+func surgeryScrubFreeFunc(srcDBPath string, cfg surgeryBaseOptions) error {
+	fi, err := checkSourceDBPath(srcDBPath)
+	if err != nil {
+		return err
+	}
+
+	if err := common.CopyFile(srcDBPath, cfg.outputDBFilePath); err != nil {
+		return fmt.Errorf("[scrub-free] copy file failed: %w", err)
+	}
+
+	db, err := bolt.Open(cfg.outputDBFilePath, fi.Mode(), nil)
+	if err != nil {
+		return fmt.Errorf("[scrub-free] open db file failed: %w", err)
+	}
+	n, err := db.ScrubFreePages()
+	if err != nil {
+		_ = db.Close()
+		return fmt.Errorf("scrub-free command failed: %w", err)
+	}
+	if err := db.Close(); err != nil {
+		return fmt.Errorf("[scrub-free] close db file failed: %w", err)
+	}
+
+	fmt.Fprintf(os.Stdout, "%d free pages were scrubbed.\n", n)
+	return nil
+}
+
 func readMetaPage(path string) (*common.Meta, error) {
 	pageSize, _, err := guts_cli.ReadPageAndHWMSize(path)
 	if err != nil {
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	bolt "go.etcd.io/bbolt"
+	berrors "go.etcd.io/bbolt/errors"
+	"go.etcd.io/bbolt/internal/surgeon"
+)
+
+// salvageBatchSize is the number of items written by each transaction.
+const salvageBatchSize = 10000
+
+type surgerySalvageOptions struct {
+	surgeryBaseOptions
+	pageSize      int
+	orphansBucket string
+}
+
+func (o *surgerySalvageOptions) AddFlags(fs *pflag.FlagSet) {
+	o.surgeryBaseOptions.AddFlags(fs)
+	fs.IntVarP(&o.pageSize, "page-size", "", 0, "page size of the source db file, read from the meta pages if 0")
+	fs.StringVarP(&o.orphansBucket, "orphans-bucket", "", "lost+found", "bucket receiving the key/values whose bucket is unknown, dropped if empty")
+}
+
+func (o *surgerySalvageOptions) Validate() error {
+	if err := o.surgeryBaseOptions.Validate(); err != nil {
+		return err
+	}
+	if o.pageSize < 0 {
+		return fmt.Errorf("the page size can't be negative: %d", o.pageSize)
+	}
+	return nil
+}
+
+func newSurgerySalvageCommand() *cobra.Command {
+	var o surgerySalvageOptions
+	salvageCmd := &cobra.Command{
+		Use:   "salvage <bbolt-file>",
+		Short: "Recover the key/values of a corrupted db by scanning its leaf pages",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := o.Validate(); err != nil {
+				return err
+			}
+			return surgerySalvageFunc(cmd.OutOrStdout(), args[0], o)
+		},
+	}
+	o.AddFlags(salvageCmd.Flags())
+	return salvageCmd
+}
+
+func surgerySalvageFunc(w io.Writer, srcDBPath string, cfg surgerySalvageOptions) error {
+	fi, err := checkSourceDBPath(srcDBPath)
+	if err != nil {
+		return err
+	}
+	// Writing into an existing db would mix its data with the salvaged one.
+	if _, err := os.Stat(cfg.outputDBFilePath); err == nil {
+		return fmt.Errorf("output database file %q already exists", cfg.outputDBFilePath)
+	}
+
+	db, err := bolt.Open(cfg.outputDBFilePath, fi.Mode(), nil)
+	if err != nil {
+		return fmt.Errorf("[salvage] open db file failed: %w", err)
+	}
+	sw := &salvageWriter{db: db}
+	stats, err := surgeon.Salvage(srcDBPath, surgeon.SalvageOptions{
+		PageSize:      cfg.pageSize,
+		OrphansBucket: []byte(cfg.orphansBucket),
+	}, sw.write)
+	if err == nil {
+		err = sw.commit()
+	} else if sw.tx != nil {
+		_ = sw.tx.Rollback()
+	}
+	if cerr := db.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("salvage command failed: %w", err)
+	}
+
+	fmt.Fprintf(w, "Scanned %d pages of %d bytes: %d leaf pages, %d branch pages, %d free pages skipped.\n",
+		stats.Pages, stats.PageSize, stats.LeafPages, stats.BranchPages, stats.FreePages)
+	fmt.Fprintf(w, "Salvaged %d buckets and %d key/values, %d conflicting items skipped.\n",
+		stats.Buckets, stats.Keys, sw.conflicts)
+	if stats.OrphanLeafPages > 0 {
+		if cfg.orphansBucket != "" {
+			fmt.Fprintf(w, "The bucket of %d leaf pages is unknown, their key/values are in the bucket %q.\n", stats.OrphanLeafPages, cfg.orphansBucket)
+		} else {
+			fmt.Fprintf(w, "The bucket of %d leaf pages is unknown, their key/values were dropped.\n", stats.OrphanLeafPages)
+		}
+	}
+	return nil
+}
+
+// salvageWriter writes the salvaged items into a db, in batches. A newer
+// item overwrites an older one with the same key.
+type salvageWriter struct {
+	db *bolt.DB
+	tx *bolt.Tx
+	n  int
+	// conflicts is the number of items skipped because their key, or the
+	// name of one of their buckets, is both a bucket and a key/value.
+	conflicts int
+}
+
+func (sw *salvageWriter) write(item surgeon.SalvagedItem) error {
+	if sw.tx == nil {
+		tx, err := sw.db.Begin(true)
+		if err != nil {
+			return err
+		}
+		sw.tx = tx
+	}
+
+	err := sw.put(item)
+	if errors.Is(err, berrors.ErrIncompatibleValue) {
+		sw.conflicts++
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if sw.n++; sw.n%salvageBatchSize == 0 {
+		return sw.commit()
+	}
+	return nil
+}
+
+func (sw *salvageWriter) put(item surgeon.SalvagedItem) error {
+	var b *bolt.Bucket
+	createBucket := func(name []byte) (*bolt.Bucket, error) {
+		if b == nil {
+			return sw.tx.CreateBucketIfNotExists(name)
+		}
+		return b.CreateBucketIfNotExists(name)
+	}
+	for _, name := range item.Bucket {
+		var err error
+		if b, err = createBucket(name); err != nil {
+			return err
+		}
+	}
+
+	if item.IsBucket {
+		nb, err := createBucket(item.Key)
+		if err != nil {
+			return err
+		}
+		return nb.SetSequence(item.Sequence)
+	}
+	if b == nil {
+		// The root bucket only has buckets.
+		return berrors.ErrIncompatibleValue
+	}
+	return b.Put(item.Key, item.Value)
+}
+
+func (sw *salvageWriter) commit() error {
+	if sw.tx == nil {
+		return nil
+	}
+	tx := sw.tx
+	sw.tx = nil
+	return tx.Commit()
+}
@@ -0,0 +1,74 @@
+package main_test
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "go.etcd.io/bbolt"
+	main "go.etcd.io/bbolt/cmd/bbolt"
+	"go.etcd.io/bbolt/internal/btesting"
+)
+
+func TestSurgery_Salvage(t *testing.T) {
+	pageSize := 4096
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{PageSize: pageSize})
+	require.NoError(t, db.Fill([]byte("data"), 1, 1000,
+		func(tx int, k int) []byte { return []byte(fmt.Sprintf("%04d", k)) },
+		func(tx int, k int) []byte { return make([]byte, 100) },
+	))
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("other"))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("key"), []byte("value"))
+	}))
+	srcPath := db.Path()
+	require.NoError(t, db.Close())
+
+	// Destroy the root page of the bucket, which loses the bucket of its
+	// leaf pages.
+	root := bucketRootPage(t, srcPath, "data")
+	f, err := os.OpenFile(srcPath, os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = f.WriteAt(make([]byte, pageSize), int64(root)*int64(pageSize))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	output := filepath.Join(t.TempDir(), "db")
+	rootCmd := main.NewRootCommand()
+	outputBuf := bytes.NewBufferString("")
+	rootCmd.SetOut(outputBuf)
+	rootCmd.SetArgs([]string{"surgery", "salvage", srcPath, "--output", output})
+	require.NoError(t, rootCmd.Execute())
+	require.Contains(t, outputBuf.String(), `their key/values are in the bucket "lost+found"`)
+
+	// The output must not be overwritten.
+	rootCmd = main.NewRootCommand()
+	rootCmd.SetArgs([]string{"surgery", "salvage", srcPath, "--output", output})
+	require.ErrorContains(t, rootCmd.Execute(), "already exists")
+
+	salvaged, err := bolt.Open(output, 0600, nil)
+	require.NoError(t, err)
+	defer salvaged.Close()
+	require.NoError(t, salvaged.View(func(tx *bolt.Tx) error {
+		for err := range tx.Check() {
+			return err
+		}
+
+		require.NotNil(t, tx.Bucket([]byte("data")))
+		require.Equal(t, []byte("value"), tx.Bucket([]byte("other")).Get([]byte("key")))
+		var orphans int
+		require.NoError(t, tx.Bucket([]byte("lost+found")).ForEachBucket(func(k []byte) error {
+			orphans += tx.Bucket([]byte("lost+found")).Bucket(k).Stats().KeyN
+			return nil
+		}))
+		require.Equal(t, 1000, orphans)
+		return nil
+	}))
+}
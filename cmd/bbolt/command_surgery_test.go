@@ -1,6 +1,7 @@
 package main_test
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -61,6 +62,45 @@ func TestSurgery_RevertMetaPage(t *testing.T) {
 	assert.Equal(t, pageDataWithoutPageId(nonActiveSrcBuf), pageDataWithoutPageId(dstBuf1))
 }
 
+func TestSurgery_ScrubFree(t *testing.T) {
+	pageSize := 4096
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{PageSize: pageSize})
+	srcPath := db.Path()
+
+	t.Log("Insert and then delete some sample data")
+	err := db.Fill([]byte("data"), 1, 100,
+		func(tx int, k int) []byte { return []byte(fmt.Sprintf("%04d", k)) },
+		func(tx int, k int) []byte { return bytes.Repeat([]byte{0xab}, 100) },
+	)
+	require.NoError(t, err)
+	err = db.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket([]byte("data"))
+	})
+	require.NoError(t, err)
+	db.Close()
+
+	defer requireDBNoChange(t, dbData(t, srcPath), srcPath)
+
+	rootCmd := main.NewRootCommand()
+	output := filepath.Join(t.TempDir(), "db")
+	rootCmd.SetArgs([]string{
+		"surgery", "scrub-free", srcPath,
+		"--output", output,
+	})
+	err = rootCmd.Execute()
+	require.NoError(t, err)
+
+	t.Log("Verify the deleted values are gone")
+	data, err := os.ReadFile(output)
+	require.NoError(t, err)
+	require.False(t, bytes.Contains(data, bytes.Repeat([]byte{0xab}, 100)))
+
+	t.Log("Verify the db is still consistent")
+	dstDB := btesting.MustOpenDBWithOption(t, output, &bolt.Options{PageSize: pageSize})
+	dstDB.MustCheck()
+	dstDB.MustClose()
+}
+
 func TestSurgery_CopyPage(t *testing.T) {
 	pageSize := 4096
 	db := btesting.MustCreateDBWithOption(t, &bolt.Options{PageSize: pageSize})
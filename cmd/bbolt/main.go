@@ -1019,7 +1019,10 @@ func (cmd *benchCommand) Run(args ...string) error {
 	}
 
 	// Create database.
-	db, err := bolt.Open(options.Path, 0600, nil)
+	dbOptions := *bolt.DefaultOptions
+	dbOptions.HugePages = options.HugePages
+	dbOptions.DirectIO = options.DirectIO
+	db, err := bolt.Open(options.Path, 0600, &dbOptions)
 	if err != nil {
 		return err
 	}
@@ -1092,6 +1095,8 @@ func (cmd *benchCommand) ParseFlags(args []string) (*BenchOptions, error) {
 	fs.StringVar(&options.BlockProfile, "blockprofile", "", "")
 	fs.Float64Var(&options.FillPercent, "fill-percent", bolt.DefaultFillPercent, "")
 	fs.BoolVar(&options.NoSync, "no-sync", false, "")
+	fs.BoolVar(&options.HugePages, "huge-pages", false, "")
+	fs.BoolVar(&options.DirectIO, "direct-io", false, "")
 	fs.BoolVar(&options.Work, "work", false, "")
 	fs.StringVar(&options.Path, "path", "", "")
 	fs.BoolVar(&options.GoBenchOutput, "gobench-output", false, "")
@@ -1572,6 +1577,8 @@ type BenchOptions struct {
 	StatsInterval time.Duration
 	FillPercent   float64
 	NoSync        bool
+	HugePages     bool
+	DirectIO      bool
 	Work          bool
 	Path          string
 	GoBenchOutput bool
@@ -1672,6 +1679,27 @@ type compactCommand struct {
 	DstPath   string
 	TxMaxSize int64
 	DstNoSync bool
+	Include   stringsFlag
+	Exclude   stringsFlag
+
+	DstPageSize        int
+	DstFreelistType    string
+	DstNoFreelistSync  bool
+	DstInitialMmapSize int
+
+	Parallelism int
+}
+
+// stringsFlag is a flag which can be given several times.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(v string) error {
+	*f = append(*f, v)
+	return nil
 }
 
 // newCompactCommand returns a CompactCommand.
@@ -1689,6 +1717,13 @@ func (cmd *compactCommand) Run(args ...string) (err error) {
 	fs.StringVar(&cmd.DstPath, "o", "", "")
 	fs.Int64Var(&cmd.TxMaxSize, "tx-max-size", 65536, "")
 	fs.BoolVar(&cmd.DstNoSync, "no-sync", false, "")
+	fs.Var(&cmd.Include, "include", "")
+	fs.Var(&cmd.Exclude, "exclude", "")
+	fs.IntVar(&cmd.DstPageSize, "page-size", 0, "")
+	fs.StringVar(&cmd.DstFreelistType, "freelist-type", string(bolt.FreelistArrayType), "")
+	fs.BoolVar(&cmd.DstNoFreelistSync, "no-freelist-sync", false, "")
+	fs.IntVar(&cmd.DstInitialMmapSize, "initial-mmap-size", 0, "")
+	fs.IntVar(&cmd.Parallelism, "parallelism", 1, "")
 	if err := fs.Parse(args); err == flag.ErrHelp {
 		fmt.Fprintln(cmd.Stderr, cmd.Usage())
 		return ErrUsage
@@ -1696,6 +1731,13 @@ func (cmd *compactCommand) Run(args ...string) (err error) {
 		return err
 	} else if cmd.DstPath == "" {
 		return errors.New("output file required")
+	} else if cmd.DstPageSize < 0 {
+		return fmt.Errorf("invalid page size: %d", cmd.DstPageSize)
+	}
+	switch bolt.FreelistType(cmd.DstFreelistType) {
+	case bolt.FreelistArrayType, bolt.FreelistMapType:
+	default:
+		return fmt.Errorf("unknown freelist type %q", cmd.DstFreelistType)
 	}
 
 	// Require database paths.
@@ -1720,17 +1762,29 @@ func (cmd *compactCommand) Run(args ...string) (err error) {
 	}
 	defer src.Close()
 
-	// Open destination database.
-	dst, err := bolt.Open(cmd.DstPath, fi.Mode(), &bolt.Options{NoSync: cmd.DstNoSync})
+	// Compact into the destination database, stopping on Ctrl-C and showing
+	// the progress on a terminal.
+	dstOptions := &bolt.Options{
+		NoSync:          cmd.DstNoSync,
+		PageSize:        cmd.DstPageSize,
+		FreelistType:    bolt.FreelistType(cmd.DstFreelistType),
+		NoFreelistSync:  cmd.DstNoFreelistSync,
+		InitialMmapSize: cmd.DstInitialMmapSize,
+	}
+	ctx, cancel := interruptContext()
+	defer cancel()
+	bar := newProgressBar(cmd.Stderr, "compacting")
+	err = bolt.CompactFile(ctx, cmd.DstPath, fi.Mode(), src, dstOptions, &bolt.CompactOptions{
+		TxMaxSize:   cmd.TxMaxSize,
+		Progress:    bar.Func(),
+		Include:     cmd.Include,
+		Exclude:     cmd.Exclude,
+		Parallelism: cmd.Parallelism,
+	})
+	bar.Done()
 	if err != nil {
 		return err
 	}
-	defer dst.Close()
-
-	// Run compaction.
-	if err := bolt.Compact(dst, src, cmd.TxMaxSize); err != nil {
-		return err
-	}
 
 	// Report stats on new size.
 	fi, err = os.Stat(cmd.DstPath)
@@ -1752,7 +1806,9 @@ usage: bolt compact [options] -o DST SRC
 Compact opens a database at SRC path and walks it recursively, copying keys
 as they are found from all buckets, to a newly created database at DST path.
 
-The original database is left untouched.
+The original database is left untouched. The progress is shown on stderr
+when it's a terminal, and an interrupt stops the compaction. The new
+database is checked once it's written.
 
 Additional options include:
 
@@ -1763,6 +1819,36 @@ Additional options include:
 	-no-sync BOOL
 		Skip fsync() calls after each commit (fast but unsafe)
 		Defaults to false
+
+	-include PATTERN
+		Only copies the buckets whose path, or the path of one of
+		their parents, matches the pattern, e.g. "tenants/*". The
+		names of the buckets are separated by "/". Can be given
+		several times. Defaults to all the buckets.
+
+	-exclude PATTERN
+		Skips the buckets whose path, or the path of one of their
+		parents, matches the pattern. Can be given several times.
+
+	-page-size NUM
+		Page size of the new database, e.g. to migrate it to 16KB
+		pages. Defaults to the OS page size.
+
+	-freelist-type TYPE
+		Freelist type used while writing the new database, "array"
+		or "hashmap". Defaults to "array".
+
+	-no-freelist-sync BOOL
+		Don't persist the freelist of the new database, it's rebuilt
+		when the database is opened. Defaults to false.
+
+	-initial-mmap-size NUM
+		Initial mmap size of the new database in bytes, which avoids
+		remapping it while it grows. Defaults to 0.
+
+	-parallelism NUM
+		Number of goroutines reading the top-level buckets of the
+		database concurrently. Defaults to 1.
 `, "\n")
 }
 
@@ -472,6 +472,8 @@ func TestBenchCommand_Run(t *testing.T) {
 	}{
 		"no-args":    {},
 		"100k count": {[]string{"-count", "100000"}},
+		"huge pages": {[]string{"-huge-pages"}},
+		"direct io":  {[]string{"-direct-io"}},
 	}
 
 	for name, test := range tests {
@@ -631,6 +633,80 @@ func TestCompactCommand_Run(t *testing.T) {
 	}
 }
 
+// Ensure the "compact" command migrates the database to another page size.
+func TestCompactCommand_Run_PageSize(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{PageSize: 4096})
+	require.NoError(t, db.Fill([]byte("data"), 1, 1000,
+		func(tx int, k int) []byte { return []byte(fmt.Sprintf("%04d", k)) },
+		func(tx int, k int) []byte { return make([]byte, 100) },
+	))
+	db.Close()
+
+	dstPath := db.Path() + ".compacted"
+	m := NewMain()
+	require.NoError(t, m.Run("compact", "-o", dstPath, "-page-size", "16384", "-freelist-type", "hashmap", "-parallelism", "4", db.Path()))
+
+	dst, err := bolt.Open(dstPath, 0600, nil)
+	require.NoError(t, err)
+	defer dst.Close()
+	require.Equal(t, 16384, dst.Info().PageSize)
+	require.NoError(t, dst.View(func(tx *bolt.Tx) error {
+		require.Equal(t, 1000, tx.Bucket([]byte("data")).Stats().KeyN)
+		return nil
+	}))
+
+	m = NewMain()
+	require.ErrorContains(t, m.Run("compact", "-o", dstPath, "-freelist-type", "list", db.Path()), `unknown freelist type "list"`)
+}
+
+// Ensure the "compact" command only copies the selected buckets.
+func TestCompactCommand_Run_IncludeExclude(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		tenants, err := tx.CreateBucket([]byte("tenants"))
+		if err != nil {
+			return err
+		}
+		for _, name := range []string{"acme", "old-corp", "old-inc"} {
+			b, err := tenants.CreateBucket([]byte(name))
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte("key"), []byte(name)); err != nil {
+				return err
+			}
+		}
+		if err := tenants.Put([]byte("count"), []byte("3")); err != nil {
+			return err
+		}
+		b, err := tx.CreateBucket([]byte("meta"))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("version"), []byte("1"))
+	}))
+	db.Close()
+
+	dstPath := db.Path() + ".compacted"
+	m := NewMain()
+	require.NoError(t, m.Run("compact", "-o", dstPath, "--include", "tenants/*", "--exclude", "tenants/old-*", db.Path()))
+
+	dst, err := bolt.Open(dstPath, 0600, nil)
+	require.NoError(t, err)
+	defer dst.Close()
+	require.NoError(t, dst.View(func(tx *bolt.Tx) error {
+		require.Nil(t, tx.Bucket([]byte("meta")))
+		tenants := tx.Bucket([]byte("tenants"))
+		require.NotNil(t, tenants)
+		// The key/values of the parents of the copied buckets aren't.
+		require.Nil(t, tenants.Get([]byte("count")))
+		require.Equal(t, []byte("acme"), tenants.Bucket([]byte("acme")).Get([]byte("key")))
+		require.Nil(t, tenants.Bucket([]byte("old-corp")))
+		require.Nil(t, tenants.Bucket([]byte("old-inc")))
+		return nil
+	}))
+}
+
 func TestCommands_Run_NoArgs(t *testing.T) {
 	testCases := []struct {
 		name   string
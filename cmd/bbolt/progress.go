@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// progressBarWidth is the number of characters of the bar itself.
+const progressBarWidth = 30
+
+// progressBar renders the progress of a long running operation on a
+// terminal, redrawing a single line.
+type progressBar struct {
+	w     io.Writer
+	label string
+	drawn bool
+}
+
+// newProgressBar returns a progress bar drawn on w, or nil if w isn't a
+// terminal, so that the output of the scripts stays clean.
+func newProgressBar(w io.Writer, label string) *progressBar {
+	if !isTerminal(w) {
+		return nil
+	}
+	return &progressBar{w: w, label: label}
+}
+
+// Func returns the function reporting the progress to the bar, or nil if
+// there is no bar.
+func (b *progressBar) Func() bolt.ProgressFunc {
+	if b == nil {
+		return nil
+	}
+	return b.draw
+}
+
+func (b *progressBar) draw(p bolt.Progress) {
+	var bar, percent string
+	if p.Total > 0 {
+		ratio := float64(p.Done) / float64(p.Total)
+		if ratio > 1 {
+			ratio = 1
+		}
+		filled := int(ratio * progressBarWidth)
+		bar = "[" + strings.Repeat("=", filled) + strings.Repeat(" ", progressBarWidth-filled) + "] "
+		percent = fmt.Sprintf("%3.0f%% ", ratio*100)
+	}
+	line := fmt.Sprintf("%s %s%s%s", b.label, bar, percent, formatProgress(p))
+	if len(p.Bucket) > 0 {
+		var names []string
+		for _, name := range p.Bucket {
+			names = append(names, bytesToAsciiOrHex(name))
+		}
+		line += " " + strings.Join(names, "/")
+	}
+	// Erase the rest of the previous line.
+	fmt.Fprintf(b.w, "\r%s\x1b[K", line)
+	b.drawn = true
+}
+
+// Clear erases the bar, before other output is printed.
+func (b *progressBar) Clear() {
+	if b != nil && b.drawn {
+		fmt.Fprint(b.w, "\r\x1b[K")
+		b.drawn = false
+	}
+}
+
+// Done ends the line of the bar once the operation completes.
+func (b *progressBar) Done() {
+	if b != nil && b.drawn {
+		fmt.Fprintln(b.w)
+		b.drawn = false
+	}
+}
+
+// formatProgress returns the work done and the total, if it's known.
+func formatProgress(p bolt.Progress) string {
+	if p.Unit == bolt.ProgressBytes {
+		if p.Total > 0 {
+			return fmt.Sprintf("%s/%s", formatSize(p.Done), formatSize(p.Total))
+		}
+		return formatSize(p.Done)
+	}
+	if p.Total > 0 {
+		return fmt.Sprintf("%d/%d %s", p.Done, p.Total, p.Unit)
+	}
+	return fmt.Sprintf("%d %s", p.Done, p.Unit)
+}
+
+// formatSize returns the size in a human readable form.
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// isTerminal returns true if w is a terminal.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// interruptContext returns a context canceled by an interrupt, so that the
+// long running commands stop cleanly on Ctrl-C.
+func interruptContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt)
+}
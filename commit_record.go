@@ -0,0 +1,197 @@
+package bbolt
+
+import (
+	"hash/crc32"
+	"sort"
+	"unsafe"
+
+	berrors "go.etcd.io/bbolt/errors"
+	"go.etcd.io/bbolt/internal/common"
+)
+
+// castagnoli is the CRC-32C table used to checksum the pages of a commit.
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+const pgidSize = int(unsafe.Sizeof(common.Pgid(0)))
+
+// A transaction committed with Options.SingleSyncCommit writes its data
+// pages and its meta page before a single fdatasync(), so the meta page may
+// reach the disk while some of the data pages don't. To detect that, the
+// transaction writes a commit record, which lists all the pages it writes,
+// and stores the record page id and a checksum of the listed pages in the
+// meta page, along with a checksum of these two fields. Open verifies the
+// checksum of the pages and falls back to the previous meta page if it
+// doesn't match. If the fields themselves are corrupted, the pages can't be
+// verified, and the transaction is kept.
+//
+// The record uses the freelist page format. It's freed by the transaction
+// that writes it, so it's reused once no reader can see the transaction.
+
+// singleSyncCommit returns true if the next commit syncs once.
+func (db *DB) singleSyncCommit() bool {
+	return db.singleSync && db.group == nil
+}
+
+// allocateCommitRecord allocates the commit record of the transaction. It's
+// freed right away, so it must be allocated before the freelist is written.
+func (tx *Tx) allocateCommitRecord() (*common.Page, error) {
+	// Leave room for the freelist page and the overflow count.
+	n := len(tx.pages) + 2
+	sz := int(common.PageHeaderSize) + n*pgidSize
+	p, err := tx.allocate((sz + tx.db.pageSize - 1) / tx.db.pageSize)
+	if err != nil {
+		return nil, err
+	}
+	tx.db.freelist.Free(tx.meta.Txid(), p)
+	return p, nil
+}
+
+// writeCommitRecord lists the dirty pages of the transaction in the commit
+// record and stores it in the meta along with the checksum of the pages.
+func (tx *Tx) writeCommitRecord(rec *common.Page) {
+	ids := make(common.Pgids, 0, len(tx.pages))
+	for id := range tx.pages {
+		if id != rec.Id() {
+			ids = append(ids, id)
+		}
+	}
+	sort.Sort(ids)
+
+	rec.SetFlags(common.FreelistPageFlag)
+	data := common.UnsafeAdd(unsafe.Pointer(rec), common.PageHeaderSize)
+	if len(ids) < 0xFFFF {
+		rec.SetCount(uint16(len(ids)))
+		copy(unsafe.Slice((*common.Pgid)(data), len(ids)), ids)
+	} else {
+		rec.SetCount(0xFFFF)
+		elems := unsafe.Slice((*common.Pgid)(data), len(ids)+1)
+		elems[0] = common.Pgid(len(ids))
+		copy(elems[1:], ids)
+	}
+
+	h := crc32.New(castagnoli)
+	for _, id := range ids {
+		p := tx.pages[id]
+		_, _ = h.Write(common.UnsafeByteSlice(unsafe.Pointer(p), 0, 0, (int(p.Overflow())+1)*tx.db.pageSize))
+	}
+	_, _ = h.Write(commitRecordBytes(rec))
+
+	tx.meta.SetCommitRecord(rec.Id())
+	tx.meta.SetCommitSum(uint64(h.Sum32()))
+}
+
+// commitRecordBytes returns the used part of a commit record.
+func commitRecordBytes(rec *common.Page) []byte {
+	idx, count := rec.FreelistPageCount()
+	sz := int(common.PageHeaderSize) + (idx+count)*pgidSize
+	return common.UnsafeByteSlice(unsafe.Pointer(rec), 0, 0, sz)
+}
+
+// verifyCommit checks that all the pages written by the latest transaction
+// reached the disk, if it was committed with a single fdatasync(). If not,
+// the latest meta page is discarded and the database is opened at the
+// previous transaction.
+func (db *DB) verifyCommit() error {
+	m := db.meta()
+	if err := m.ValidateCommitRecord(); err != nil {
+		// The meta page itself is valid, and the transaction is likely
+		// durable: don't discard it.
+		db.Logger().Warningf("the commit record of transaction %d is unreadable, its pages can't be verified: %v", m.Txid(), err)
+		return nil
+	}
+	if m.CommitRecord() == 0 {
+		return nil
+	}
+	fi, err := db.file.Stat()
+	if err != nil {
+		return err
+	}
+	if db.commitIntact(m, fi.Size()) {
+		return nil
+	}
+
+	prev := db.meta0
+	if m == db.meta0 {
+		prev = db.meta1
+	}
+	if err := prev.Validate(); err != nil {
+		db.Logger().Errorf("transaction %d wasn't completely written and the previous meta page is invalid: %v", m.Txid(), err)
+		return berrors.ErrChecksum
+	}
+	db.Logger().Warningf("transaction %d wasn't completely written, opening the database at transaction %d", m.Txid(), prev.Txid())
+
+	if db.readOnly {
+		db.tornMeta = m
+		return nil
+	}
+
+	// Overwrite both meta pages with the previous one, so that the torn
+	// one is never used again. The commit record of the previous one may
+	// have been reused by the torn transaction, so it's removed.
+	buf := make([]byte, db.pageSize)
+	p := db.pageInBuffer(buf, 0)
+	p.SetFlags(common.MetaPageFlag)
+	prev.Copy(p.Meta())
+	p.Meta().ClearCommitRecord()
+	torn := common.Pgid(0)
+	if m == db.meta1 {
+		torn = 1
+	}
+	for _, id := range []common.Pgid{torn, 1 - torn} {
+		p.SetId(id)
+		if _, err := db.ops.writeAt(buf, int64(id)*int64(db.pageSize)); err != nil {
+			return err
+		}
+		if db.pcache != nil {
+			db.pcache.metaWritten(p)
+		}
+		if err := fdatasync(db); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// commitIntact returns true if the pages listed by the commit record of the
+// given meta match its checksum.
+func (db *DB) commitIntact(m *common.Meta, fileSize int64) bool {
+	// inFile returns true if the pages are below the high water mark of
+	// the meta and within the file.
+	inFile := func(start, end common.Pgid) bool {
+		return start >= 2 && end < m.Pgid() && int64(end+1)*int64(db.pageSize) <= fileSize
+	}
+	pageAt := func(id common.Pgid) *common.Page {
+		if !inFile(id, id) {
+			return nil
+		}
+		p := db.page(id)
+		if !inFile(id, id+common.Pgid(p.Overflow())) {
+			return nil
+		}
+		return p
+	}
+
+	rec := pageAt(m.CommitRecord())
+	if rec == nil || !rec.IsFreelistPage() {
+		return false
+	}
+	recSize := (int(rec.Overflow()) + 1) * db.pageSize
+	if int(rec.Count()) == 0xFFFF && int(common.PageHeaderSize)+pgidSize > recSize {
+		return false
+	}
+	idx, count := rec.FreelistPageCount()
+	if int(common.PageHeaderSize)+(idx+count)*pgidSize > recSize {
+		return false
+	}
+
+	h := crc32.New(castagnoli)
+	for _, id := range rec.FreelistPageIds() {
+		p := pageAt(id)
+		if p == nil {
+			return false
+		}
+		_, _ = h.Write(common.UnsafeByteSlice(unsafe.Pointer(p), 0, 0, (int(p.Overflow())+1)*db.pageSize))
+	}
+	_, _ = h.Write(commitRecordBytes(rec))
+	return uint64(h.Sum32()) == m.CommitSum()
+}
@@ -0,0 +1,154 @@
+package bbolt
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/require"
+
+	"go.etcd.io/bbolt/internal/common"
+)
+
+func TestOptions_SingleSyncCommit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	db, err := Open(path, 0600, &Options{SingleSyncCommit: true})
+	require.NoError(t, err)
+
+	before := db.Stats()
+	for i := 0; i < 10; i++ {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			b, err := tx.CreateBucketIfNotExists([]byte("widgets"))
+			if err != nil {
+				return err
+			}
+			return b.Put([]byte(fmt.Sprintf("%04d", i)), make([]byte, 100))
+		}))
+		require.NotZero(t, db.meta().CommitRecord())
+	}
+	after := db.Stats()
+	stats := after.TxStats.Sub(&before.TxStats)
+	require.Equal(t, int64(10), stats.GetSync())
+
+	require.NoError(t, db.View(func(tx *Tx) error {
+		for err := range tx.Check() {
+			return err
+		}
+		return nil
+	}))
+	require.NoError(t, db.Close())
+
+	// A transaction committed without the option doesn't keep the record.
+	db, err = Open(path, 0600, nil)
+	require.NoError(t, err)
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		return tx.Bucket([]byte("widgets")).Put([]byte("foo"), []byte("bar"))
+	}))
+	require.Zero(t, db.meta().CommitRecord())
+	require.NoError(t, db.Close())
+}
+
+func TestOptions_SingleSyncCommit_TornCommit(t *testing.T) {
+	for _, readOnly := range []bool{false, true} {
+		t.Run(fmt.Sprintf("readOnly=%t", readOnly), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "db")
+			db, err := Open(path, 0600, &Options{SingleSyncCommit: true})
+			require.NoError(t, err)
+			require.NoError(t, db.Update(func(tx *Tx) error {
+				b, err := tx.CreateBucket([]byte("widgets"))
+				if err != nil {
+					return err
+				}
+				return b.Put([]byte("first"), []byte("value"))
+			}))
+			prevTxid := db.meta().Txid()
+			require.NoError(t, db.Update(func(tx *Tx) error {
+				return tx.Bucket([]byte("widgets")).Put([]byte("second"), []byte("value"))
+			}))
+
+			// Pick a page written by the last transaction.
+			rec := db.page(db.meta().CommitRecord())
+			require.NotEmpty(t, rec.FreelistPageIds())
+			id := rec.FreelistPageIds()[0]
+			pageSize := db.pageSize
+			require.NoError(t, db.Close())
+
+			// Simulate the page not reaching the disk.
+			f, err := os.OpenFile(path, os.O_RDWR, 0600)
+			require.NoError(t, err)
+			_, err = f.WriteAt(make([]byte, pageSize), int64(id)*int64(pageSize))
+			require.NoError(t, err)
+			require.NoError(t, f.Close())
+
+			db, err = Open(path, 0600, &Options{ReadOnly: readOnly})
+			require.NoError(t, err)
+			require.Equal(t, prevTxid, db.meta().Txid())
+			require.NoError(t, db.View(func(tx *Tx) error {
+				for err := range tx.Check() {
+					return err
+				}
+				b := tx.Bucket([]byte("widgets"))
+				require.NotNil(t, b.Get([]byte("first")))
+				require.Nil(t, b.Get([]byte("second")))
+				return nil
+			}))
+			if !readOnly {
+				// Both meta pages hold the previous transaction, without
+				// its commit record which may have been reused.
+				require.Equal(t, prevTxid, db.meta0.Txid())
+				require.Equal(t, prevTxid, db.meta1.Txid())
+				require.Zero(t, db.meta0.CommitRecord())
+				require.Zero(t, db.meta1.CommitRecord())
+				require.NoError(t, db.Update(func(tx *Tx) error {
+					return tx.Bucket([]byte("widgets")).Put([]byte("third"), []byte("value"))
+				}))
+			}
+			require.NoError(t, db.Close())
+		})
+	}
+}
+
+func TestOptions_SingleSyncCommit_UnreadableRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	db, err := Open(path, 0600, &Options{SingleSyncCommit: true})
+	require.NoError(t, err)
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("foo"), []byte("bar"))
+	}))
+	txid := db.meta().Txid()
+	pageSize := db.pageSize
+	require.NoError(t, db.Close())
+
+	// Flip a bit of the checksum of the pages, which the checksum of the
+	// meta page doesn't cover.
+	f, err := os.OpenFile(path, os.O_RDWR, 0600)
+	require.NoError(t, err)
+	buf := make([]byte, pageSize)
+	off := int64(txid%2) * int64(pageSize)
+	_, err = f.ReadAt(buf, off)
+	require.NoError(t, err)
+	m := (*common.Page)(unsafe.Pointer(&buf[0])).Meta()
+	require.NoError(t, m.Validate())
+	m.SetCommitSum(m.CommitSum() ^ 1)
+	require.NoError(t, m.Validate())
+	require.Error(t, m.ValidateCommitRecord())
+	_, err = f.WriteAt(buf, off)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	// The transaction isn't discarded.
+	db, err = Open(path, 0600, nil)
+	require.NoError(t, err)
+	require.Equal(t, txid, db.meta().Txid())
+	require.NoError(t, db.View(func(tx *Tx) error {
+		require.Equal(t, []byte("bar"), tx.Bucket([]byte("widgets")).Get([]byte("foo")))
+		return nil
+	}))
+	require.NoError(t, db.Close())
+}
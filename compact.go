@@ -1,104 +1,531 @@
 package bbolt
 
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	berrors "go.etcd.io/bbolt/errors"
+)
+
 // Compact will create a copy of the source DB and in the destination DB. This may
 // reclaim space that the source database no longer has use for. txMaxSize can be
 // used to limit the transactions size of this process and may trigger intermittent
 // commits. A value of zero will ignore transaction sizes.
 // TODO: merge with: https://github.com/etcd-io/etcd/blob/b7f0f52a16dbf83f18ca1d803f7892d750366a94/mvcc/backend/backend.go#L349
 func Compact(dst, src *DB, txMaxSize int64) error {
-	// commit regularly, or we'll run out of memory for large datasets if using one transaction.
-	var size int64
-	tx, err := dst.Begin(true)
+	return CompactWithOptions(context.Background(), dst, src, &CompactOptions{TxMaxSize: txMaxSize})
+}
+
+// CompactOptions represents the options of CompactWithOptions.
+type CompactOptions struct {
+	// TxMaxSize limits the size of the transactions writing to the
+	// destination DB, see Compact.
+	TxMaxSize int64
+
+	// Progress is called with the number of bytes of the keys and values
+	// copied. The total is the size of the pages in use in the source DB,
+	// which is usually larger.
+	Progress ProgressFunc
+
+	// Include and Exclude select the buckets copied by their path, the
+	// names of the bucket and of its parents separated by "/". Each element
+	// of a pattern matches a name with path.Match, e.g. "tenants/*". A
+	// bucket is copied if Include is empty or one of its patterns matches
+	// the bucket or one of its parents, and no pattern of Exclude does. The
+	// parents of a copied bucket are created without their key/values.
+	Include []string
+	Exclude []string
+
+	// Transform is called with the path of the bucket in the source DB and
+	// each key/value copied, and returns the key/value written to the
+	// destination DB instead, or errors.ErrSkipKey to drop it.
+	Transform func(bucket [][]byte, k, v []byte) ([]byte, []byte, error)
+
+	// Rename maps the path of a bucket in the source DB to its path in the
+	// destination DB, the names separated by "/", e.g. "tenants/old" to
+	// "archive/old". Its nested buckets move along. The buckets renamed to
+	// the same path are merged.
+	Rename map[string]string
+
+	// FillPercent maps the path of a bucket in the destination DB to its
+	// FillPercent. The pages of the other buckets are filled entirely.
+	FillPercent map[string]float64
+
+	// Parallelism is the number of goroutines reading the top-level buckets
+	// of the source DB concurrently, from transactions reading the same
+	// snapshot. The destination DB is still written by a single
+	// transaction at a time, and it's checked with Tx.Check once it's
+	// written. The source DB is read by a single goroutine if it's 0 or 1.
+	// DB.CompactInPlace ignores it.
+	Parallelism int
+}
+
+// CompactWithOptions copies the source DB into the destination DB, like
+// Compact. It stops with the error of the context once it's canceled, and
+// the transactions already committed to the destination DB are kept.
+func CompactWithOptions(ctx context.Context, dst, src *DB, options *CompactOptions) error {
+	_, err := compact(ctx, dst, src, options)
+	return err
+}
+
+// CompactFile copies the source DB into the DB at the given path, created
+// with the given options if it doesn't exist, like CompactWithOptions. This
+// migrates the data to another page size, or to a DB whose freelist isn't
+// synced. The destination DB is closed once the copy is verified: it must
+// pass Tx.Check and have the page size and the freelist persistence asked
+// for. If it was empty, and no bucket or key/value is selected, renamed or
+// transformed, it must also have as many buckets, key/values and bytes as
+// the source DB.
+func CompactFile(ctx context.Context, dstPath string, mode os.FileMode, src *DB, dstOptions *Options, options *CompactOptions) (err error) {
+	if dstOptions == nil {
+		dstOptions = DefaultOptions
+	}
+	if dstOptions.ReadOnly {
+		return errors.New("compact: the destination DB can't be read-only")
+	}
+	if options == nil {
+		options = &CompactOptions{}
+	}
+	dst, err := Open(dstPath, mode, dstOptions)
 	if err != nil {
 		return err
 	}
 	defer func() {
-		if tempErr := tx.Rollback(); tempErr != nil {
-			err = tempErr
+		if cerr := dst.Close(); err == nil {
+			err = cerr
 		}
 	}()
 
-	if err := walk(src, func(keys [][]byte, k, v []byte, seq uint64) error {
-		// On each key/value, check if we have exceeded tx size.
-		sz := int64(len(k) + len(v))
-		if size+sz > txMaxSize && txMaxSize != 0 {
-			// Commit previous transaction.
-			if err := tx.Commit(); err != nil {
-				return err
-			}
+	// The page size of an existing DB can't be changed.
+	if dstOptions.PageSize != 0 && dst.pageSize != dstOptions.PageSize {
+		return fmt.Errorf("compact: the page size of the destination DB is %d, not %d", dst.pageSize, dstOptions.PageSize)
+	}
+	// The counts can only be compared if the destination DB was empty.
+	var empty bool
+	if err := dst.View(func(tx *Tx) error {
+		k, _ := tx.Cursor().First()
+		empty = k == nil
+		return nil
+	}); err != nil {
+		return err
+	}
+	copied, err := compact(ctx, dst, src, options)
+	if err != nil {
+		return err
+	}
+	// A parallel compaction checked the destination DB already.
+	if options.Parallelism <= 1 {
+		if err := checkCompacted(dst); err != nil {
+			return err
+		}
+	}
+	return dst.View(func(tx *Tx) error {
+		if persisted := tx.meta.IsFreelistPersisted(); persisted == dstOptions.NoFreelistSync {
+			return fmt.Errorf("compact: the freelist of the destination DB is persisted: %t, expected: %t", persisted, !dstOptions.NoFreelistSync)
+		}
+		if !empty || !options.copiesAll() {
+			return nil
+		}
+		var found compactCount
+		if err := tx.ForEach(func(name []byte, b *Bucket) error {
+			return walkBucket(b, nil, name, nil, b.Sequence(), func(keys [][]byte, k, v []byte, seq uint64) error {
+				found.add(k, v)
+				return nil
+			})
+		}); err != nil {
+			return err
+		}
+		if found != copied {
+			return fmt.Errorf("compact: the destination DB has %d buckets and %d key/values of %d bytes, the source DB has %d buckets and %d key/values of %d bytes",
+				found.buckets, found.keys, found.bytes, copied.buckets, copied.keys, copied.bytes)
+		}
+		return nil
+	})
+}
 
-			// Start new transaction.
-			tx, err = dst.Begin(true)
-			if err != nil {
-				return err
-			}
-			size = 0
+// copiesAll returns true if all the buckets and key/values are copied
+// unchanged.
+func (o *CompactOptions) copiesAll() bool {
+	return len(o.Include) == 0 && len(o.Exclude) == 0 && o.Transform == nil && len(o.Rename) == 0
+}
+
+// compactCount counts the buckets and the key/values walked.
+type compactCount struct {
+	buckets, keys, bytes int64
+}
+
+func (c *compactCount) add(k, v []byte) {
+	if v == nil {
+		c.buckets++
+	} else {
+		c.keys++
+	}
+	c.bytes += int64(len(k) + len(v))
+}
+
+// compact copies the source DB into the destination DB, and returns the
+// count of the buckets and the key/values walked in the source DB.
+func compact(ctx context.Context, dst, src *DB, options *CompactOptions) (compactCount, error) {
+	var walked compactCount
+	if options == nil {
+		options = &CompactOptions{}
+	}
+	c, err := newCompactor(dst, options)
+	if err != nil {
+		return walked, err
+	}
+	newProgress := func(tx *Tx) *progressTracker {
+		return newProgressTracker(ctx, options.Progress, ProgressBytes, inUseSize(tx))
+	}
+	if options.Parallelism > 1 {
+		if err := c.copyParallel(src, options.Parallelism, newProgress, &walked); err != nil {
+			return walked, err
 		}
-		size += sz
+		return walked, checkCompacted(dst)
+	}
+	err = src.View(func(tx *Tx) error {
+		progress := newProgress(tx)
+		if err := c.copyBuckets(tx, nil, progress, &walked); err != nil {
+			return err
+		}
+		progress.finish()
+		return nil
+	})
+	return walked, err
+}
 
-		// Create bucket on the root transaction if this is the first level.
-		nk := len(keys)
-		if nk == 0 {
-			bkt, err := tx.CreateBucket(k)
-			if err != nil {
-				return err
-			}
-			if err := bkt.SetSequence(seq); err != nil {
-				return err
+// compactor writes the buckets and key/values walked in the source DB to the
+// destination DB.
+type compactor struct {
+	dst       *DB
+	tx        *Tx
+	size      int64
+	txMaxSize int64
+
+	include, exclude [][]string
+	transform        func(bucket [][]byte, k, v []byte) ([]byte, []byte, error)
+	rename           map[string][][]byte
+	fillPercent      map[string]float64
+
+	// The bucket of the last key/value copied.
+	last       [][]byte
+	lastCopied bool
+	lastDst    [][]byte
+	lastFill   float64
+
+	// The last leaf node of the destination bucket as of transaction
+	// lastNodeTx, which the next key/values are appended to.
+	lastNode   *node
+	lastNodeTx *Tx
+}
+
+func newCompactor(dst *DB, options *CompactOptions) (*compactor, error) {
+	c := &compactor{
+		dst:         dst,
+		txMaxSize:   options.TxMaxSize,
+		transform:   options.Transform,
+		fillPercent: options.FillPercent,
+	}
+	var err error
+	if c.include, err = splitPatterns(options.Include); err != nil {
+		return nil, err
+	}
+	if c.exclude, err = splitPatterns(options.Exclude); err != nil {
+		return nil, err
+	}
+	if len(options.Rename) > 0 {
+		c.rename = make(map[string][][]byte)
+		for from, to := range options.Rename {
+			var keys [][]byte
+			for _, name := range strings.Split(to, "/") {
+				if name == "" {
+					return nil, fmt.Errorf("invalid bucket path %q to rename %q to", to, from)
+				}
+				keys = append(keys, []byte(name))
 			}
-			return nil
+			c.rename[from] = keys
 		}
+	}
+	return c, nil
+}
 
-		// Create buckets on subsequent levels, if necessary.
-		b := tx.Bucket(keys[0])
-		if nk > 1 {
-			for _, k := range keys[1:] {
-				b = b.Bucket(k)
+// splitPatterns splits the bucket path patterns into their elements, and
+// checks them.
+func splitPatterns(patterns []string) ([][]string, error) {
+	var split [][]string
+	for _, pattern := range patterns {
+		elems := strings.Split(pattern, "/")
+		for _, elem := range elems {
+			if _, err := path.Match(elem, ""); err != nil {
+				return nil, fmt.Errorf("invalid bucket pattern %q: %w", pattern, err)
 			}
 		}
+		split = append(split, elems)
+	}
+	return split, nil
+}
 
-		// Fill the entire page for best compaction.
-		b.FillPercent = 1.0
+// reserve commits the transaction and starts a new one if writing sz more
+// bytes exceeds the maximum size of the transactions.
+func (c *compactor) reserve(sz int64) error {
+	if c.size+sz > c.txMaxSize && c.txMaxSize != 0 {
+		// Commit previous transaction.
+		tx := c.tx
+		c.tx = nil
+		if err := tx.Commit(); err != nil {
+			return err
+		}
 
-		// If there is no value then this is a bucket call.
-		if v == nil {
-			bkt, err := b.CreateBucket(k)
-			if err != nil {
+		// Start new transaction.
+		var err error
+		if c.tx, err = c.dst.Begin(true); err != nil {
+			return err
+		}
+		c.size = 0
+	}
+	c.size += sz
+	return nil
+}
+
+// copyBuckets copies the top-level buckets of the source transaction whose
+// names are in the given set, or all of them if it's nil, and commits them.
+// The buckets of the set are deleted from the destination DB first, so that
+// they're copied again.
+func (c *compactor) copyBuckets(srcTx *Tx, names map[string]bool, progress *progressTracker, walked *compactCount) (err error) {
+	// commit regularly, or we'll run out of memory for large datasets if using one transaction.
+	if c.tx, err = c.dst.Begin(true); err != nil {
+		return err
+	}
+	c.size = 0
+	defer func() {
+		if c.tx != nil {
+			_ = c.tx.Rollback()
+			c.tx = nil
+		}
+	}()
+
+	for name := range names {
+		if err := c.tx.DeleteBucket([]byte(name)); err != nil && !errors.Is(err, berrors.ErrBucketNotFound) {
+			return err
+		}
+	}
+	if err := srcTx.ForEach(func(name []byte, b *Bucket) error {
+		if names != nil && !names[string(name)] {
+			return nil
+		}
+		return walkBucket(b, nil, name, nil, b.Sequence(), func(keys [][]byte, k, v []byte, seq uint64) error {
+			if err := progress.add(int64(len(k)+len(v)), keys); err != nil {
 				return err
 			}
-			if err := bkt.SetSequence(seq); err != nil {
-				return err
+			walked.add(k, v)
+			// If there is no value then this is a bucket call.
+			if v == nil {
+				return c.copyBucket(appendPath(keys, k), seq)
 			}
+			return c.copyKV(keys, k, v)
+		})
+	}); err != nil {
+		return err
+	}
+	tx := c.tx
+	c.tx = nil
+	return tx.Commit()
+}
+
+// copyBucket creates the bucket at the given path of the source DB in the
+// destination DB, or returns skipBucket if neither it nor its nested
+// buckets are copied.
+func (c *compactor) copyBucket(keys [][]byte, seq uint64) error {
+	copied, descend := c.selected(keys)
+	if !descend {
+		return skipBucket
+	}
+	if !copied {
+		return nil
+	}
+	if err := c.reserve(int64(len(keys[len(keys)-1]))); err != nil {
+		return err
+	}
+	b, err := c.createBucket(c.dstPath(keys))
+	if err != nil {
+		return err
+	}
+	return b.SetSequence(seq)
+}
+
+// copyKV copies a key/value of the bucket at the given path of the source DB
+// to the destination DB.
+func (c *compactor) copyKV(keys [][]byte, k, v []byte) error {
+	if !equalPaths(keys, c.last) {
+		c.last = clonePath(keys)
+		c.lastCopied, _ = c.selected(keys)
+		c.lastDst = c.dstPath(c.last)
+		c.lastFill = c.fill(c.lastDst)
+		c.lastNode = nil
+	}
+	if !c.lastCopied {
+		return nil
+	}
+	if c.transform != nil {
+		var err error
+		if k, v, err = c.transform(keys, k, v); errors.Is(err, berrors.ErrSkipKey) {
 			return nil
+		} else if err != nil {
+			return err
 		}
+	}
 
-		// Otherwise treat it as a key/value pair.
-		return b.Put(k, v)
-	}); err != nil {
+	// On each key/value, check if we have exceeded tx size.
+	if err := c.reserve(int64(len(k) + len(v))); err != nil {
 		return err
 	}
-	err = tx.Commit()
+	b := c.tx.Bucket(c.lastDst[0])
+	for _, name := range c.lastDst[1:] {
+		b = b.Bucket(name)
+	}
+	b.FillPercent = c.lastFill
 
-	return err
+	// The keys of a bucket are walked in order, so they're appended to its
+	// last node, unless they're transformed or merged into another bucket.
+	if c.lastNode == nil || c.lastNodeTx != c.tx {
+		c.lastNode, c.lastNodeTx = b.lastNode(), c.tx
+	}
+	if c.lastNode != nil && b.appendSorted(c.lastNode, k, v) {
+		return nil
+	}
+	return b.Put(k, v)
+}
+
+// createBucket creates the bucket at the given path of the destination DB,
+// and its parents, if they don't exist.
+func (c *compactor) createBucket(keys [][]byte) (*Bucket, error) {
+	b, err := c.tx.CreateBucketIfNotExists(keys[0])
+	for i := 1; err == nil; i++ {
+		b.FillPercent = c.fill(keys[:i])
+		if i == len(keys) {
+			break
+		}
+		b, err = b.CreateBucketIfNotExists(keys[i])
+	}
+	return b, err
+}
+
+// fill returns the FillPercent of the bucket at the given path of the
+// destination DB.
+func (c *compactor) fill(keys [][]byte) float64 {
+	if fill, ok := c.fillPercent[string(joinPath(keys))]; ok {
+		return fill
+	}
+	// Fill the entire page for best compaction.
+	return 1.0
+}
+
+// selected returns whether the key/values of the bucket at the given path
+// are copied, and whether it or some of its nested buckets are.
+func (c *compactor) selected(keys [][]byte) (copied bool, descend bool) {
+	for _, pattern := range c.exclude {
+		if matchParent(pattern, keys) {
+			return false, false
+		}
+	}
+	if len(c.include) == 0 {
+		return true, true
+	}
+	for _, pattern := range c.include {
+		if matchParent(pattern, keys) {
+			return true, true
+		}
+	}
+	for _, pattern := range c.include {
+		if len(pattern) > len(keys) && matchPath(pattern[:len(keys)], keys) {
+			descend = true
+		}
+	}
+	return false, descend
+}
+
+// dstPath returns the path in the destination DB of the bucket at the given
+// path of the source DB.
+func (c *compactor) dstPath(keys [][]byte) [][]byte {
+	// The deepest renamed parent wins.
+	for i := len(keys); i > 0 && c.rename != nil; i-- {
+		if to, ok := c.rename[string(joinPath(keys[:i]))]; ok {
+			return append(to[:len(to):len(to)], keys[i:]...)
+		}
+	}
+	return keys
+}
+
+// matchParent returns true if the pattern matches the bucket path or the
+// path of one of its parents.
+func matchParent(pattern []string, keys [][]byte) bool {
+	return len(pattern) <= len(keys) && matchPath(pattern, keys[:len(pattern)])
+}
+
+// matchPath returns true if each element of the pattern matches the name at
+// the same depth of the bucket path.
+func matchPath(pattern []string, keys [][]byte) bool {
+	if len(pattern) != len(keys) {
+		return false
+	}
+	for i, elem := range pattern {
+		if ok, _ := path.Match(elem, string(keys[i])); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// joinPath returns the names of the bucket path separated by "/".
+func joinPath(keys [][]byte) []byte {
+	var b []byte
+	for i, name := range keys {
+		if i > 0 {
+			b = append(b, '/')
+		}
+		b = append(b, name...)
+	}
+	return b
+}
+
+func equalPaths(a, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if string(a[i]) != string(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// inUseSize returns the size of the pages in use in the DB, counting the
+// free pages as in use if the freelist isn't loaded.
+func inUseSize(tx *Tx) int64 {
+	stats := tx.db.Stats()
+	inUse := int64(tx.meta.Pgid()) - int64(stats.FreePageN+stats.PendingPageN)
+	return inUse * int64(tx.db.pageSize)
 }
 
 // walkFunc is the type of the function called for keys (buckets and "normal"
-// values) discovered by Walk. keys is the list of keys to descend to the bucket
-// owning the discovered key/value pair k/v.
+// values) discovered by walkBucket. keys is the list of keys to descend to the bucket
+// owning the discovered key/value pair k/v. It returns skipBucket to skip
+// the key/values of a bucket.
 type walkFunc func(keys [][]byte, k, v []byte, seq uint64) error
 
-// walk walks recursively the bolt database db, calling walkFn for each key it finds.
-func walk(db *DB, walkFn walkFunc) error {
-	return db.View(func(tx *Tx) error {
-		return tx.ForEach(func(name []byte, b *Bucket) error {
-			return walkBucket(b, nil, name, nil, b.Sequence(), walkFn)
-		})
-	})
-}
+// skipBucket is returned by a walkFunc to skip the key/values of a bucket.
+var skipBucket = errors.New("skip this bucket")
 
 func walkBucket(b *Bucket, keypath [][]byte, k, v []byte, seq uint64, fn walkFunc) error {
 	// Execute callback.
-	if err := fn(keypath, k, v, seq); err != nil {
+	if err := fn(keypath, k, v, seq); err == skipBucket {
+		return nil
+	} else if err != nil {
 		return err
 	}
 
@@ -0,0 +1,290 @@
+package bbolt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	berrors "go.etcd.io/bbolt/errors"
+	"go.etcd.io/bbolt/internal/common"
+)
+
+// compactInPlaceRounds is the number of times CompactInPlace copies the
+// modified buckets again before it blocks the writers.
+const compactInPlaceRounds = 3
+
+// CompactInPlace compacts the database while it's in use: the *DB stays
+// valid, and the transactions can go on. The database is copied from a read
+// transaction into a temporary file next to it, like CompactWithOptions.
+// The top-level buckets modified meanwhile are then copied again from a
+// newer read transaction, a few times, and one last time while the writers
+// are blocked. The temporary file finally replaces the database file, which
+// is mapped again. The read transactions which began before keep reading
+// the previous file until they are closed.
+//
+// Options.Rename isn't supported, and Options.TxMaxSize limits the
+// transactions writing to the temporary file. The database is left
+// untouched if an error is returned, in particular if the context is
+// canceled, except if mapping the compacted file fails once it replaced the
+// database file: the *DB then fails every following transaction, and must
+// be closed and opened again. It isn't supported with Options.NoMmap, nor
+// on Windows, where a file which is open can't be replaced.
+func (db *DB) CompactInPlace(ctx context.Context, options *CompactOptions) (err error) {
+	if options == nil {
+		options = &CompactOptions{}
+	}
+	if db.readOnly {
+		return berrors.ErrDatabaseReadOnly
+	}
+	if runtime.GOOS == "windows" {
+		return errors.New("compact: the db file can't be replaced on Windows while it's open")
+	}
+	if db.pcache != nil {
+		return errors.New("compact: the file isn't memory mapped with Options.NoMmap")
+	}
+	if len(options.Rename) > 0 {
+		return errors.New("compact: the buckets can't be renamed in place")
+	}
+
+	lg := db.Logger()
+	snap, err := db.Begin(false)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if snap != nil {
+			_ = snap.Rollback()
+		}
+	}()
+
+	// Create the temporary file in the same directory, so that it can be
+	// renamed over the database file.
+	fi, err := db.file.Stat()
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(db.path), filepath.Base(db.path)+".compact-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(tmpPath)
+		}
+	}()
+
+	// The temporary file is synced once, before it replaces the database
+	// file. Its freelist is always synced, so that it can be read back
+	// while the writers are blocked.
+	dst, err := Open(tmpPath, fi.Mode(), &Options{
+		Timeout:      time.Second,
+		NoSync:       true,
+		PageSize:     db.pageSize,
+		FreelistType: db.FreelistType,
+		OpenFile:     db.openFile,
+	})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if dst != nil {
+			_ = dst.Close()
+		}
+	}()
+
+	c, err := newCompactor(dst, options)
+	if err != nil {
+		return err
+	}
+	progress := newProgressTracker(ctx, options.Progress, ProgressBytes, inUseSize(snap))
+	var walked compactCount
+	if err := c.copyBuckets(snap, nil, progress, &walked); err != nil {
+		return err
+	}
+
+	// Catch up with the writers, then block them for the last round.
+	for round := 0; ; round++ {
+		last := round == compactInPlaceRounds
+		if last {
+			db.rwlock.Lock()
+			defer db.rwlock.Unlock()
+			// Make the transactions committed in group commit mode durable,
+			// so that the latest one is read.
+			if db.group != nil {
+				if err := db.group.flush(db); err != nil {
+					return err
+				}
+			}
+		}
+
+		cur, err := db.Begin(false)
+		if err != nil {
+			return err
+		}
+		changed := changedBuckets(snap, cur)
+		_ = snap.Rollback()
+		snap = cur
+		lg.Debugf("compacting in place, round %d: copying %d modified buckets again", round, len(changed))
+		if len(changed) > 0 {
+			if err := c.copyBuckets(snap, changed, progress, &walked); err != nil {
+				return err
+			}
+		} else if !last {
+			// Block the writers right away.
+			round = compactInPlaceRounds - 1
+		}
+		if last {
+			break
+		}
+	}
+
+	// Continue the txids of the database, so that the readers of the
+	// previous file can't be mistaken for readers of the new one.
+	txid := snap.meta.Txid() + 1
+	if err := dst.Update(func(tx *Tx) error {
+		tx.meta.SetTxid(txid)
+		return nil
+	}); err != nil {
+		return err
+	}
+	if err := dst.Sync(); err != nil {
+		return err
+	}
+	err = dst.Close()
+	dst = nil
+	if err != nil {
+		return err
+	}
+	progress.finish()
+
+	if err := db.swapFile(tmpPath, txid); err != nil {
+		return err
+	}
+	if sz, err := db.fileSize(); err == nil {
+		lg.Infof("compacted db file (%s) in place: %d -> %d bytes", db.path, fi.Size(), sz)
+	}
+	return nil
+}
+
+// changedBuckets returns the names of the top-level buckets created,
+// modified or deleted between two transactions. A modified bucket has a new
+// root page, or new inline contents.
+func changedBuckets(from, to *Tx) map[string]bool {
+	changed := make(map[string]bool)
+	prev := make(map[string][]byte)
+	c := from.Cursor()
+	for k, v, _ := c.first(); k != nil; k, v, _ = c.next() {
+		prev[string(k)] = v
+	}
+	c = to.Cursor()
+	for k, v, _ := c.first(); k != nil; k, v, _ = c.next() {
+		if old, ok := prev[string(k)]; !ok || !bytes.Equal(old, v) {
+			changed[string(k)] = true
+		}
+		delete(prev, string(k))
+	}
+	for name := range prev {
+		changed[name] = true
+	}
+	return changed
+}
+
+// swapFile replaces the database file with the file at the given path,
+// whose latest transaction has the given txid, and maps it. The caller must
+// hold the writer lock. Once the file is replaced, an error fails the DB.
+func (db *DB) swapFile(path string, txid common.Txid) error {
+	lg := db.Logger()
+	f, err := db.openFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	// Lock the new file before it can be opened under the database path.
+	if err := flock(f, true, time.Second); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := os.Rename(path, db.path); err != nil {
+		_ = f.Close()
+		return err
+	}
+	syncDir(filepath.Dir(db.path))
+
+	// The previous file stays mapped until its transactions are closed.
+	db.metalock.Lock()
+	old := db.file
+	if err := funlock(db); err != nil {
+		lg.Warningf("unlocking the previous db file failed: %v", err)
+	}
+	db.file = f
+	db.ops.writeAt = f.WriteAt
+	db.ops.writeAtHooked = false
+	db.metalock.Unlock()
+	if err := old.Close(); err != nil {
+		lg.Warningf("closing the previous db file failed: %v", err)
+	}
+	if db.directFile != nil {
+		_ = db.directFile.Close()
+		db.directFile = nil
+		db.openDirect()
+	}
+
+	// The locked pages are located again once the file is mapped.
+	db.pinlock.Lock()
+	db.pinnedRanges, db.pinnedTxid = nil, 0
+	db.pinlock.Unlock()
+	if err := db.mmap(0); err != nil {
+		err = fmt.Errorf("mmap of the compacted db file failed: %w", err)
+		db.swapErr.Store(err)
+		lg.Errorf("%v, failing all the following transactions", err)
+		return err
+	}
+
+	// Read the freelist of the new file, and keep the pages of the
+	// transactions which began on it since.
+	db.metalock.Lock()
+	freelist := newFreelist(db.FreelistType)
+	freelist.SetTrackReleased(db.secureDelete)
+	freelist.Read(db.page(db.meta().Freelist()))
+	for _, t := range db.txs {
+		if t.gen == db.gen {
+			freelist.AddReadonlyTXID(t.meta.Txid())
+		}
+	}
+	db.freelist = freelist
+	db.metalock.Unlock()
+
+	db.statlock.Lock()
+	db.stats.FreePageN = freelist.FreeCount()
+	db.stats.PendingPageN = 0
+	db.statlock.Unlock()
+
+	if db.group != nil {
+		db.group.reset(txid)
+	}
+
+	// Lock the pages of the locked buckets at their new locations.
+	if err := db.repin(); err != nil {
+		lg.Warningf("locking the pages of the locked buckets failed: %v", err)
+	}
+	return nil
+}
+
+// syncDir syncs a directory, so that a file renamed in it is durable. It's
+// best effort, as directories can't be synced on every platform.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	_ = d.Sync()
+	_ = d.Close()
+}
@@ -0,0 +1,40 @@
+package bbolt_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+
+	bolt "go.etcd.io/bbolt"
+	"go.etcd.io/bbolt/internal/btesting"
+)
+
+// Ensure that the DB fails the following transactions if the compacted file
+// can't be mapped once it replaced the database file.
+func TestDB_CompactInPlace_MapFail(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.Fill([]byte("data"), 1, 1000,
+		func(tx int, k int) []byte { return []byte(fmt.Sprintf("%04d", k)) },
+		func(tx int, k int) []byte { return make([]byte, 100) },
+	))
+
+	// MAP_SYNC is rejected on the file systems without DAX.
+	db.MmapFlags = unix.MAP_SHARED_VALIDATE | unix.MAP_SYNC
+	err := db.CompactInPlace(context.Background(), nil)
+	require.ErrorContains(t, err, "mmap of the compacted db file failed")
+
+	_, berr := db.Begin(false)
+	require.Equal(t, err, berr)
+	require.Equal(t, err, db.Update(func(tx *bolt.Tx) error { return nil }))
+
+	// The compacted file is read once the database is opened again.
+	db.MustClose()
+	db.MustReopen()
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		require.Equal(t, 1000, tx.Bucket([]byte("data")).Stats().KeyN)
+		return nil
+	}))
+}
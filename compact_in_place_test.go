@@ -0,0 +1,122 @@
+package bbolt_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "go.etcd.io/bbolt"
+	"go.etcd.io/bbolt/internal/btesting"
+)
+
+func TestDB_CompactInPlace(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.Fill([]byte("data"), 10, 1000,
+		func(tx int, k int) []byte { return []byte(fmt.Sprintf("%02d-%04d", tx, k)) },
+		func(tx int, k int) []byte { return make([]byte, 100) },
+	))
+	// Free most of the pages.
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("data"))
+		for i := 1; i < 10; i++ {
+			for k := 0; k < 1000; k++ {
+				if err := b.Delete([]byte(fmt.Sprintf("%02d-%04d", i, k))); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}))
+	before, err := os.Stat(db.Path())
+	require.NoError(t, err)
+
+	// A reader which began before keeps its snapshot.
+	reader, err := db.Begin(false)
+	require.NoError(t, err)
+
+	// The writers go on during the compaction.
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	var written int
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if err := db.Update(func(tx *bolt.Tx) error {
+				b, err := tx.CreateBucketIfNotExists([]byte("live"))
+				if err != nil {
+					return err
+				}
+				return b.Put([]byte(fmt.Sprintf("%06d", written)), []byte("value"))
+			}); err != nil {
+				t.Error(err)
+				return
+			}
+			written++
+		}
+	}()
+
+	require.NoError(t, db.CompactInPlace(context.Background(), &bolt.CompactOptions{TxMaxSize: 65536}))
+	close(stop)
+	wg.Wait()
+
+	after, err := os.Stat(db.Path())
+	require.NoError(t, err)
+	require.Less(t, after.Size(), before.Size())
+
+	require.Equal(t, 10000-9000, reader.Bucket([]byte("data")).Stats().KeyN)
+	require.Nil(t, reader.Bucket([]byte("live")))
+	require.NoError(t, reader.Rollback())
+
+	// All the committed writes were kept, and the handle is still usable.
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		require.Equal(t, 1000, tx.Bucket([]byte("data")).Stats().KeyN)
+		if written > 0 {
+			require.Equal(t, written, tx.Bucket([]byte("live")).Stats().KeyN)
+		}
+		return tx.Bucket([]byte("data")).Put([]byte("new"), []byte("value"))
+	}))
+	db.MustCheck()
+
+	// The compacted file is the database once it's opened again.
+	require.NoError(t, db.Close())
+	db.MustReopen()
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		require.Equal(t, []byte("value"), tx.Bucket([]byte("data")).Get([]byte("new")))
+		return nil
+	}))
+	entries, err := os.ReadDir(filepath.Dir(db.Path()))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+}
+
+func TestDB_CompactInPlace_Canceled(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.Fill([]byte("data"), 1, 100,
+		func(tx int, k int) []byte { return []byte(fmt.Sprintf("%04d", k)) },
+		func(tx int, k int) []byte { return make([]byte, 100) },
+	))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.ErrorIs(t, db.CompactInPlace(ctx, nil), context.Canceled)
+
+	// The temporary file is removed.
+	entries, err := os.ReadDir(filepath.Dir(db.Path()))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		require.Equal(t, 100, tx.Bucket([]byte("data")).Stats().KeyN)
+		return nil
+	}))
+}
@@ -0,0 +1,198 @@
+package bbolt
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// With CompactOptions.Parallelism, the top-level buckets of the source DB
+// are read by concurrent readers, each in its own read-only transaction.
+// The transactions begin while the writer lock is held, so that they all
+// read the same snapshot. The readers send the buckets and key/values in
+// batches to the caller's goroutine, the only one writing to the destination
+// DB, and calling CompactOptions.Transform and CompactOptions.Progress. The
+// writer copies the top-level buckets one after the other, in order, while
+// the readers read the next ones ahead, so that the keys of a bucket are
+// appended to its last node as in a sequential compaction.
+
+// compactBatchSize is the number of items sent at once by a reader.
+const compactBatchSize = 1024
+
+// compactAhead is the number of batches of a bucket read ahead of the writer.
+const compactAhead = 4
+
+// compactItem is a bucket or a key/value read from the source DB. The slices
+// point into the transaction of the reader.
+type compactItem struct {
+	keys [][]byte
+	k, v []byte
+	seq  uint64
+}
+
+// compactJob is a top-level bucket read by a reader, and the batches of its
+// items.
+type compactJob struct {
+	name    []byte
+	batches chan []compactItem
+}
+
+// errCompactStopped is returned by a reader once the writer stopped.
+var errCompactStopped = errors.New("compaction stopped")
+
+// copyParallel copies the source DB into the destination DB with the given
+// number of readers, and commits it.
+func (c *compactor) copyParallel(src *DB, n int, newProgress func(*Tx) *progressTracker, walked *compactCount) (err error) {
+	// No writer may commit while the transactions begin.
+	var txs []*Tx
+	src.rwlock.Lock()
+	for i := 0; i < n && err == nil; i++ {
+		var tx *Tx
+		if tx, err = src.Begin(false); err == nil {
+			txs = append(txs, tx)
+		}
+	}
+	src.rwlock.Unlock()
+	defer func() {
+		for _, tx := range txs {
+			_ = tx.Rollback()
+		}
+	}()
+	if err != nil {
+		return err
+	}
+	progress := newProgress(txs[0])
+
+	// Queue the top-level buckets, taken by the readers in order.
+	var jobs []*compactJob
+	if err := txs[0].ForEach(func(name []byte, _ *Bucket) error {
+		jobs = append(jobs, &compactJob{name: name, batches: make(chan []compactItem, compactAhead)})
+		return nil
+	}); err != nil {
+		return err
+	}
+	queue := make(chan *compactJob, len(jobs))
+	for _, job := range jobs {
+		queue <- job
+	}
+	close(queue)
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, tx := range txs {
+		wg.Add(1)
+		go func(tx *Tx) {
+			defer wg.Done()
+			for job := range queue {
+				if c.readBucket(tx, job, stop) != nil {
+					return
+				}
+			}
+		}(tx)
+	}
+
+	err = c.writeBatches(jobs, progress, walked)
+	// Stop the readers, which may be blocked sending a batch.
+	close(stop)
+	wg.Wait()
+	if err != nil {
+		return err
+	}
+	progress.finish()
+	return nil
+}
+
+// readBucket reads the top-level bucket of the job, and sends its nested
+// buckets and key/values in batches until the writer stops.
+func (c *compactor) readBucket(tx *Tx, job *compactJob, stop <-chan struct{}) error {
+	defer close(job.batches)
+	var batch []compactItem
+	send := func() error {
+		select {
+		case job.batches <- batch:
+			batch = nil
+			return nil
+		case <-stop:
+			return errCompactStopped
+		}
+	}
+
+	// The path of the bucket of the last item, shared by the items of the
+	// bucket, since walkBucket reuses its slices.
+	var last [][]byte
+	b := tx.Bucket(job.name)
+	if err := walkBucket(b, nil, job.name, nil, b.Sequence(), func(keys [][]byte, k, v []byte, seq uint64) error {
+		// Skip the buckets of which nothing is copied.
+		if v == nil {
+			if _, descend := c.selected(appendPath(keys, k)); !descend {
+				return skipBucket
+			}
+		}
+		if !equalPaths(keys, last) {
+			last = clonePath(keys)
+		}
+		batch = append(batch, compactItem{keys: last, k: k, v: v, seq: seq})
+		if len(batch) < compactBatchSize {
+			return nil
+		}
+		return send()
+	}); err != nil {
+		return err
+	}
+	if len(batch) > 0 {
+		return send()
+	}
+	return nil
+}
+
+// writeBatches writes the batches of the jobs to the destination DB, a job
+// after the other, and commits them.
+func (c *compactor) writeBatches(jobs []*compactJob, progress *progressTracker, walked *compactCount) (err error) {
+	// commit regularly, or we'll run out of memory for large datasets if using one transaction.
+	if c.tx, err = c.dst.Begin(true); err != nil {
+		return err
+	}
+	c.size = 0
+	defer func() {
+		if c.tx != nil {
+			_ = c.tx.Rollback()
+			c.tx = nil
+		}
+	}()
+
+	for _, job := range jobs {
+		for batch := range job.batches {
+			for _, item := range batch {
+				if err := progress.add(int64(len(item.k)+len(item.v)), item.keys); err != nil {
+					return err
+				}
+				walked.add(item.k, item.v)
+				if item.v == nil {
+					err = c.copyBucket(appendPath(item.keys, item.k), item.seq)
+				} else {
+					err = c.copyKV(item.keys, item.k, item.v)
+				}
+				if err != nil && err != skipBucket {
+					return err
+				}
+			}
+		}
+	}
+	tx := c.tx
+	c.tx = nil
+	return tx.Commit()
+}
+
+// checkCompacted checks the destination DB of a compaction, and returns the
+// first inconsistency found.
+func checkCompacted(dst *DB) error {
+	return dst.View(func(tx *Tx) error {
+		var first error
+		for err := range tx.Check() {
+			if first == nil {
+				first = fmt.Errorf("compact: the destination DB is inconsistent: %w", err)
+			}
+		}
+		return first
+	})
+}
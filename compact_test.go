@@ -0,0 +1,284 @@
+package bbolt_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "go.etcd.io/bbolt"
+	berrors "go.etcd.io/bbolt/errors"
+	"go.etcd.io/bbolt/internal/btesting"
+)
+
+func TestCompactWithOptions_Progress(t *testing.T) {
+	src := btesting.MustCreateDB(t)
+	require.NoError(t, src.Fill([]byte("data"), 1, 1000,
+		func(tx int, k int) []byte { return []byte(fmt.Sprintf("%04d", k)) },
+		func(tx int, k int) []byte { return make([]byte, 100) },
+	))
+	dst := btesting.MustCreateDB(t)
+
+	var last bolt.Progress
+	err := bolt.CompactWithOptions(context.Background(), dst.DB, src.DB, &bolt.CompactOptions{
+		TxMaxSize: 65536,
+		Progress:  func(p bolt.Progress) { last = p },
+	})
+	require.NoError(t, err)
+
+	// The bucket name, and the keys and values.
+	require.Equal(t, bolt.ProgressBytes, last.Unit)
+	require.Equal(t, int64(len("data")+1000*(4+100)), last.Done)
+	require.Greater(t, last.Total, last.Done)
+	require.Nil(t, last.Bucket)
+
+	require.NoError(t, dst.View(func(tx *bolt.Tx) error {
+		require.Equal(t, 1000, tx.Bucket([]byte("data")).Stats().KeyN)
+		return nil
+	}))
+}
+
+func TestCompactWithOptions_Canceled(t *testing.T) {
+	src := btesting.MustCreateDB(t)
+	require.NoError(t, src.Fill([]byte("data"), 1, 100,
+		func(tx int, k int) []byte { return []byte(fmt.Sprintf("%04d", k)) },
+		func(tx int, k int) []byte { return make([]byte, 100) },
+	))
+	dst := btesting.MustCreateDB(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := bolt.CompactWithOptions(ctx, dst.DB, src.DB, nil)
+	require.ErrorIs(t, err, context.Canceled)
+
+	require.NoError(t, dst.View(func(tx *bolt.Tx) error {
+		require.Nil(t, tx.Bucket([]byte("data")))
+		return nil
+	}))
+}
+
+func TestCompactWithOptions_Filter(t *testing.T) {
+	src := btesting.MustCreateDB(t)
+	require.NoError(t, src.Update(func(tx *bolt.Tx) error {
+		for _, tenant := range []string{"a", "b", "c"} {
+			b, err := tx.CreateBucketIfNotExists([]byte("tenants"))
+			if err != nil {
+				return err
+			}
+			if b, err = b.CreateBucket([]byte(tenant)); err != nil {
+				return err
+			}
+			if err := b.SetSequence(42); err != nil {
+				return err
+			}
+			for i := 0; i < 100; i++ {
+				if err := b.Put([]byte(fmt.Sprintf("%03d", i)), []byte(fmt.Sprintf("v%d", i))); err != nil {
+					return err
+				}
+			}
+			if _, err := b.CreateBucket([]byte("nested")); err != nil {
+				return err
+			}
+		}
+		b, err := tx.CreateBucket([]byte("logs"))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("k"), []byte("v"))
+	}))
+
+	dst := btesting.MustCreateDB(t)
+	err := bolt.CompactWithOptions(context.Background(), dst.DB, src.DB, &bolt.CompactOptions{
+		Include: []string{"tenants/*"},
+		Exclude: []string{"tenants/c"},
+		Transform: func(bucket [][]byte, k, v []byte) ([]byte, []byte, error) {
+			// Drop the odd keys and re-encode the values.
+			if k[len(k)-1]%2 == 1 {
+				return nil, nil, berrors.ErrSkipKey
+			}
+			return k, append([]byte("new-"), v...), nil
+		},
+		Rename:      map[string]string{"tenants/b": "archive/b"},
+		FillPercent: map[string]float64{"archive/b": 0.5},
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, dst.View(func(tx *bolt.Tx) error {
+		require.Nil(t, tx.Bucket([]byte("logs")))
+		tenants := tx.Bucket([]byte("tenants"))
+		require.NotNil(t, tenants.Bucket([]byte("a")).Bucket([]byte("nested")))
+		require.Nil(t, tenants.Bucket([]byte("b")))
+		require.Nil(t, tenants.Bucket([]byte("c")))
+
+		for _, b := range []*bolt.Bucket{tenants.Bucket([]byte("a")), tx.Bucket([]byte("archive")).Bucket([]byte("b"))} {
+			require.Equal(t, uint64(42), b.Sequence())
+			require.Equal(t, []byte("new-v10"), b.Get([]byte("010")))
+			require.Nil(t, b.Get([]byte("011")))
+			// The 50 kept keys and the nested bucket.
+			require.Equal(t, 51, b.Stats().KeyN)
+		}
+		return nil
+	}))
+}
+
+func TestCompactWithOptions_InvalidPattern(t *testing.T) {
+	src := btesting.MustCreateDB(t)
+	dst := btesting.MustCreateDB(t)
+	err := bolt.CompactWithOptions(context.Background(), dst.DB, src.DB, &bolt.CompactOptions{
+		Include: []string{"tenants/["},
+	})
+	require.ErrorContains(t, err, "invalid bucket pattern")
+}
+
+func TestCompactFile(t *testing.T) {
+	src := btesting.MustCreateDBWithOption(t, &bolt.Options{PageSize: 4096})
+	require.NoError(t, src.Fill([]byte("data"), 1, 1000,
+		func(tx int, k int) []byte { return []byte(fmt.Sprintf("%04d", k)) },
+		func(tx int, k int) []byte { return make([]byte, 100) },
+	))
+
+	dstPath := filepath.Join(t.TempDir(), "db")
+	err := bolt.CompactFile(context.Background(), dstPath, 0600, src.DB, &bolt.Options{
+		PageSize:       16384,
+		FreelistType:   bolt.FreelistMapType,
+		NoFreelistSync: true,
+	}, nil)
+	require.NoError(t, err)
+
+	dst, err := bolt.Open(dstPath, 0600, &bolt.Options{NoFreelistSync: true})
+	require.NoError(t, err)
+	require.Equal(t, 16384, dst.Info().PageSize)
+	require.NoError(t, dst.View(func(tx *bolt.Tx) error {
+		require.Equal(t, 1000, tx.Bucket([]byte("data")).Stats().KeyN)
+		return nil
+	}))
+	require.NoError(t, dst.Close())
+
+	// The page size of an existing DB can't be changed.
+	err = bolt.CompactFile(context.Background(), dstPath, 0600, src.DB, &bolt.Options{PageSize: 4096}, nil)
+	require.ErrorContains(t, err, "the page size of the destination DB is 16384, not 4096")
+}
+
+func TestCompactWithOptions_Parallel(t *testing.T) {
+	src := btesting.MustCreateDB(t)
+	require.NoError(t, src.Update(func(tx *bolt.Tx) error {
+		for i := 0; i < 20; i++ {
+			b, err := tx.CreateBucket([]byte(fmt.Sprintf("bucket-%02d", i)))
+			if err != nil {
+				return err
+			}
+			if err := b.SetSequence(uint64(i)); err != nil {
+				return err
+			}
+			// The buckets have a different size, from inline to many pages.
+			for k := 0; k < i*200; k++ {
+				if err := b.Put([]byte(fmt.Sprintf("%05d", k)), make([]byte, 50)); err != nil {
+					return err
+				}
+			}
+			nested, err := b.CreateBucket([]byte("nested"))
+			if err != nil {
+				return err
+			}
+			if err := nested.Put([]byte("key"), []byte(fmt.Sprintf("value-%d", i))); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+
+	compacted := func(t *testing.T, options *bolt.CompactOptions) map[string]string {
+		dst := btesting.MustCreateDB(t)
+		require.NoError(t, bolt.CompactWithOptions(context.Background(), dst.DB, src.DB, options))
+		contents := make(map[string]string)
+		require.NoError(t, dst.View(func(tx *bolt.Tx) error {
+			var dump func(path string, b *bolt.Bucket) error
+			dump = func(path string, b *bolt.Bucket) error {
+				contents[path] = fmt.Sprintf("sequence %d", b.Sequence())
+				return b.ForEach(func(k, v []byte) error {
+					if v == nil {
+						return dump(path+"/"+string(k), b.Bucket(k))
+					}
+					contents[path+"/"+string(k)] = string(v)
+					return nil
+				})
+			}
+			return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+				return dump(string(name), b)
+			})
+		}))
+		return contents
+	}
+
+	// The parallel compaction copies the same buckets and key/values.
+	options := &bolt.CompactOptions{
+		TxMaxSize: 65536,
+		Exclude:   []string{"bucket-01"},
+		Transform: func(bucket [][]byte, k, v []byte) ([]byte, []byte, error) {
+			return k, append([]byte("new-"), v...), nil
+		},
+	}
+	want := compacted(t, options)
+	require.NotContains(t, want, "bucket-01")
+	require.Equal(t, "new-value-19", want["bucket-19/nested/key"])
+	for _, n := range []int{2, 8} {
+		options.Parallelism = n
+		require.Equal(t, want, compacted(t, options))
+	}
+}
+
+func TestCompactWithOptions_ParallelError(t *testing.T) {
+	src := btesting.MustCreateDB(t)
+	for i := 0; i < 10; i++ {
+		require.NoError(t, src.Fill([]byte(fmt.Sprintf("bucket-%d", i)), 1, 5000,
+			func(tx int, k int) []byte { return []byte(fmt.Sprintf("%04d", k)) },
+			func(tx int, k int) []byte { return make([]byte, 10) },
+		))
+	}
+
+	// The readers are stopped once the writer fails.
+	failure := errors.New("transform failed")
+	dst := btesting.MustCreateDB(t)
+	err := bolt.CompactWithOptions(context.Background(), dst.DB, src.DB, &bolt.CompactOptions{
+		Parallelism: 4,
+		Transform: func(bucket [][]byte, k, v []byte) ([]byte, []byte, error) {
+			return nil, nil, failure
+		},
+	})
+	require.ErrorIs(t, err, failure)
+
+	// The source DB can be written once the readers are done.
+	require.NoError(t, src.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket([]byte("bucket-0"))
+	}))
+}
+
+func BenchmarkCompactWithOptions(b *testing.B) {
+	src := btesting.MustCreateDB(b)
+	for i := 0; i < 8; i++ {
+		require.NoError(b, src.Fill([]byte(fmt.Sprintf("bucket-%d", i)), 10, 2000,
+			func(tx int, k int) []byte { return []byte(fmt.Sprintf("%02d-%04d", tx, k)) },
+			func(tx int, k int) []byte { return make([]byte, 100) },
+		))
+	}
+
+	for _, n := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("parallelism-%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				dst := btesting.MustCreateDBWithOption(b, &bolt.Options{NoSync: true})
+				b.StartTimer()
+				require.NoError(b, bolt.CompactWithOptions(context.Background(), dst.DB, src.DB, &bolt.CompactOptions{
+					TxMaxSize:   1 << 24,
+					Parallelism: n,
+				}))
+				b.StopTimer()
+				dst.MustClose()
+				b.StartTimer()
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package bbolt
 import (
 	"bytes"
 	"fmt"
+	"runtime/debug"
 	"sort"
 
 	"go.etcd.io/bbolt/errors"
@@ -34,6 +35,9 @@ func (c *Cursor) Bucket() *Bucket {
 // The returned key and value are only valid for the life of the transaction.
 func (c *Cursor) First() (key []byte, value []byte) {
 	common.Assert(c.bucket.tx.db != nil, "tx closed")
+	if db := c.bucket.tx.db; db.guarded {
+		defer db.recoverFault(debug.SetPanicOnFault(true))
+	}
 	k, v, flags := c.first()
 	if (flags & uint32(common.BucketLeafFlag)) != 0 {
 		return k, nil
@@ -65,6 +69,9 @@ func (c *Cursor) first() (key []byte, value []byte, flags uint32) {
 // The returned key and value are only valid for the life of the transaction.
 func (c *Cursor) Last() (key []byte, value []byte) {
 	common.Assert(c.bucket.tx.db != nil, "tx closed")
+	if db := c.bucket.tx.db; db.guarded {
+		defer db.recoverFault(debug.SetPanicOnFault(true))
+	}
 	c.stack = c.stack[:0]
 	p, n := c.bucket.pageNode(c.bucket.RootPage())
 	ref := elemRef{page: p, node: n}
@@ -94,6 +101,9 @@ func (c *Cursor) Last() (key []byte, value []byte) {
 // The returned key and value are only valid for the life of the transaction.
 func (c *Cursor) Next() (key []byte, value []byte) {
 	common.Assert(c.bucket.tx.db != nil, "tx closed")
+	if db := c.bucket.tx.db; db.guarded {
+		defer db.recoverFault(debug.SetPanicOnFault(true))
+	}
 	k, v, flags := c.next()
 	if (flags & uint32(common.BucketLeafFlag)) != 0 {
 		return k, nil
@@ -106,6 +116,9 @@ func (c *Cursor) Next() (key []byte, value []byte) {
 // The returned key and value are only valid for the life of the transaction.
 func (c *Cursor) Prev() (key []byte, value []byte) {
 	common.Assert(c.bucket.tx.db != nil, "tx closed")
+	if db := c.bucket.tx.db; db.guarded {
+		defer db.recoverFault(debug.SetPanicOnFault(true))
+	}
 	k, v, flags := c.prev()
 	if (flags & uint32(common.BucketLeafFlag)) != 0 {
 		return k, nil
@@ -119,6 +132,9 @@ func (c *Cursor) Prev() (key []byte, value []byte) {
 // The returned key and value are only valid for the life of the transaction.
 func (c *Cursor) Seek(seek []byte) (key []byte, value []byte) {
 	common.Assert(c.bucket.tx.db != nil, "tx closed")
+	if db := c.bucket.tx.db; db.guarded {
+		defer db.recoverFault(debug.SetPanicOnFault(true))
+	}
 
 	k, v, flags := c.seek(seek)
 
@@ -137,12 +153,15 @@ func (c *Cursor) Seek(seek []byte) (key []byte, value []byte) {
 
 // Delete removes the current key/value under the cursor from the bucket.
 // Delete fails if current key/value is a bucket or if the transaction is not writable.
-func (c *Cursor) Delete() error {
+func (c *Cursor) Delete() (err error) {
 	if c.bucket.tx.db == nil {
 		return errors.ErrTxClosed
 	} else if !c.bucket.Writable() {
 		return errors.ErrTxNotWritable
 	}
+	if db := c.bucket.tx.db; db.catchesIOErrors() {
+		defer db.recoverIOError(&err, db.guardFaults())
+	}
 
 	key, _, flags := c.keyValue()
 	// Return an error if current value is a bucket.
@@ -4,9 +4,13 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math/bits"
 	"os"
 	"runtime"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 	"unsafe"
 
@@ -32,6 +36,21 @@ const (
 	FreelistMapType = FreelistType("hashmap")
 )
 
+// DurabilityMode defines when committed transactions are synced to disk.
+type DurabilityMode string
+
+const (
+	// DurabilitySync syncs every transaction before Tx.Commit returns.
+	// This is the default.
+	DurabilitySync = DurabilityMode("sync")
+	// DurabilityPeriodic returns from Tx.Commit without syncing, and syncs
+	// all the committed transactions every Options.FlushInterval.
+	DurabilityPeriodic = DurabilityMode("periodic")
+	// DurabilityNone only syncs the committed transactions on DB.Flush and
+	// DB.Close.
+	DurabilityNone = DurabilityMode("none")
+)
+
 // DB represents a collection of buckets persisted to a file on disk.
 // All data access is performed through transactions which can be obtained through the DB.
 // All the functions on DB will return a ErrDatabaseNotOpen if accessed before Open() is called.
@@ -116,8 +135,92 @@ type DB struct {
 	// Supported only on Unix via mlock/munlock syscalls.
 	Mlock bool
 
+	// WriteConcurrency is the number of goroutines writing the dirty pages
+	// of a commit. Adjacent pages are always coalesced into a single write;
+	// the non-adjacent runs of pages are written concurrently if it's
+	// larger than 1.
+	//
+	// Do not change concurrently with calls to Commit.
+	WriteConcurrency int
+
 	logger Logger
 
+	// secureDelete overwrites pages with zeros once they are released
+	// from pending to free. See Options.SecureDelete.
+	secureDelete bool
+
+	// readerWarn configures the reporting of long-running read-only
+	// transactions. See Options.ReaderWarnThreshold.
+	readerWarn ReaderWarnThreshold
+
+	// growthPolicy controls how the data file grows. See Options.GrowthPolicy.
+	growthPolicy GrowthPolicy
+
+	// group shares the syncing of the file between concurrent writers.
+	// It's nil unless Options.GroupCommit is set or durability isn't
+	// DurabilitySync.
+	group *groupCommit
+
+	// durability defines when committed transactions are synced.
+	// See Options.DurabilityMode.
+	durability DurabilityMode
+
+	// mmapAdvice is given to the kernel for the whole mmap. See
+	// Options.MmapAdvice.
+	mmapAdvice MmapAdvice
+
+	// singleSync commits with a single fdatasync(). See
+	// Options.SingleSyncCommit.
+	singleSync bool
+
+	// tornMeta is the latest meta page of a read-only database, if the
+	// transaction which wrote it wasn't completely written. It's ignored.
+	tornMeta *common.Meta
+
+	// pinned are the paths of the buckets locked with DB.MlockBucket, and
+	// pinnedRanges the ranges of the mmap holding their pages as of
+	// transaction pinnedTxid. Protected by pinlock.
+	pinned       [][][]byte
+	pinnedRanges []byteRange
+	pinnedTxid   common.Txid
+	pinlock      sync.Mutex
+
+	// guarded turns the faults reading the mmap into errors. See
+	// Options.GuardedReads.
+	guarded bool
+
+	// ioErr is the error of a fault reading the mmap, which fails all the
+	// following transactions.
+	ioErr atomic.Pointer[berrors.IOError]
+
+	// swapErr is the error of CompactInPlace failing to map the compacted
+	// file once it replaced the database file, which fails all the
+	// following transactions.
+	swapErr atomic.Value
+
+	// pcache reads the pages with pread() instead of the mmap. It's nil
+	// unless Options.NoMmap is set.
+	pcache *pageCache
+
+	// hugePages backs the mmap with transparent huge pages. See
+	// Options.HugePages.
+	hugePages bool
+
+	// directFile is the data file opened with O_DIRECT, which the dirty
+	// pages are written to. It's nil unless Options.DirectIO is in effect.
+	directFile *os.File
+
+	// flushStop stops the background flushing of DurabilityPeriodic.
+	flushStop chan struct{}
+	flushWg   sync.WaitGroup
+
+	// scrubStop stops the scrubber started by StartScrubber, and scrubDone
+	// is closed once it's stopped.
+	scrubStop chan struct{}
+	scrubDone chan struct{}
+	scrublock sync.Mutex // Protects scrubStop and scrubDone.
+	scrubFree scrubFree  // Protected by metalock.
+
 	path     string
 	openFile func(string, int, os.FileMode) (*os.File, error)
 	file     *os.File
@@ -127,6 +230,8 @@ type DB struct {
 	dataref  []byte // mmap'ed readonly, write throws SEGV
 	data     *[maxMapSize]byte
 	datasz   int
+	gen      *mmapGen   // current mapping, mirrored by dataref, data and datasz
+	retired  []*mmapGen // previous mappings still referenced by transactions
 	meta0    *common.Meta
 	meta1    *common.Meta
 	pageSize int
@@ -144,11 +249,16 @@ type DB struct {
 
 	rwlock   sync.Mutex   // Allows only one writer at a time.
 	metalock sync.Mutex   // Protects meta page access.
-	mmaplock sync.RWMutex // Protects mmap access during remapping.
+	mmaplock sync.RWMutex // Prevents the mmap from being closed under the readers.
+	genlock  sync.RWMutex // Protects the mmap fields while the file is remapped.
 	statlock sync.RWMutex // Protects stats access.
 
 	ops struct {
 		writeAt func(b []byte, off int64) (n int, err error)
+
+		// writeAtHooked is set along with writeAt when it no longer writes
+		// to the database file directly, e.g. by the tests.
+		writeAtHooked bool
 	}
 
 	// Read only mode.
@@ -191,6 +301,20 @@ func Open(path string, mode os.FileMode, options *Options) (db *DB, err error) {
 	db.PreLoadFreelist = options.PreLoadFreelist
 	db.FreelistType = options.FreelistType
 	db.Mlock = options.Mlock
+	db.WriteConcurrency = options.WriteConcurrency
+	db.secureDelete = options.SecureDelete
+	db.readerWarn = options.ReaderWarnThreshold
+	db.growthPolicy = options.GrowthPolicy
+	db.singleSync = options.SingleSyncCommit
+	db.guarded = options.GuardedReads
+	db.hugePages = options.HugePages
+	db.mmapAdvice = options.MmapAdvice
+	if db.mmapAdvice == "" {
+		db.mmapAdvice = MmapAdviceRandom
+	}
+	if err := db.mmapAdvice.validate(); err != nil {
+		return nil, err
+	}
 
 	// Set default values for later DB operations.
 	db.MaxBatchSize = common.DefaultMaxBatchSize
@@ -245,7 +369,7 @@ func Open(path string, mode os.FileMode, options *Options) (db *DB, err error) {
 	// if !options.ReadOnly.
 	// The database file is locked using the shared lock (more than one process may
 	// hold a lock at the same time) otherwise (options.ReadOnly is set).
-	if err = flock(db, !db.readOnly, options.Timeout); err != nil {
+	if err = flock(db.file, !db.readOnly, options.Timeout); err != nil {
 		_ = db.close()
 		lg.Errorf("failed to lock db file (%s), readonly: %t, error: %v", path, db.readOnly, err)
 		return nil, err
@@ -281,10 +405,32 @@ func Open(path string, mode os.FileMode, options *Options) (db *DB, err error) {
 		}
 	}
 
+	if options.NoMmap {
+		cacheSize := options.PageCacheSize
+		if cacheSize <= 0 {
+			cacheSize = common.DefaultPageCacheSize
+		}
+		db.pcache = newPageCache(db, cacheSize)
+
+		// Pages are read with pread(), return a failed read as an error.
+		defer func() {
+			if rerr := ioErrorFrom(recover()); rerr != nil {
+				_ = db.close()
+				lg.Errorf("failed to read db file (%s): %v", path, rerr)
+				db, err = nil, rerr
+			}
+		}()
+	}
+
+	// Write the dirty pages with O_DIRECT, from aligned buffers.
+	if options.DirectIO && !db.readOnly {
+		db.openDirect()
+	}
+
 	// Initialize page pool.
 	db.pagePool = sync.Pool{
 		New: func() interface{} {
-			return make([]byte, db.pageSize)
+			return db.pageBuffer(db.pageSize)
 		},
 	}
 
@@ -295,6 +441,14 @@ func Open(path string, mode os.FileMode, options *Options) (db *DB, err error) {
 		return nil, err
 	}
 
+	// Discard the latest transaction if it was committed with a single
+	// fdatasync() and didn't completely reach the disk.
+	if err = db.verifyCommit(); err != nil {
+		_ = db.close()
+		lg.Errorf("failed to verify the last commit of db file (%s): %v", path, err)
+		return nil, err
+	}
+
 	if db.PreLoadFreelist {
 		db.loadFreelist()
 	}
@@ -303,6 +457,21 @@ func Open(path string, mode os.FileMode, options *Options) (db *DB, err error) {
 		return db, nil
 	}
 
+	db.durability = options.DurabilityMode
+	if db.durability == "" {
+		db.durability = DurabilitySync
+	}
+	if options.GroupCommit || db.durability != DurabilitySync {
+		db.group = newGroupCommit(db.meta().Txid())
+	}
+	if db.durability == DurabilityPeriodic {
+		interval := options.FlushInterval
+		if interval <= 0 {
+			interval = common.DefaultFlushInterval
+		}
+		db.startFlusher(interval)
+	}
+
 	// Flush freelist when transitioning from no sync to sync so
 	// NoFreelistSync unaware boltdb can open the db later.
 	if !db.NoFreelistSync && !db.hasSyncedFreelist() {
@@ -417,6 +586,7 @@ func (db *DB) getPageSizeFromSecondMeta() (int, bool, error) {
 func (db *DB) loadFreelist() {
 	db.freelistLoad.Do(func() {
 		db.freelist = newFreelist(db.FreelistType)
+		db.freelist.SetTrackReleased(db.secureDelete)
 		if !db.hasSyncedFreelist() {
 			// Reconstruct free list by scanning the DB.
 			db.freelist.Init(db.freepages())
@@ -432,6 +602,12 @@ func (db *DB) hasSyncedFreelist() bool {
 	return db.meta().Freelist() != common.PgidNoFreelist
 }
 
+// writesToFile returns true if DB.ops.writeAt writes to the database file
+// directly, so that it can be bypassed by vectored and direct writes.
+func (db *DB) writesToFile() bool {
+	return !db.ops.writeAtHooked
+}
+
 func (db *DB) fileSize() (int, error) {
 	info, err := db.file.Stat()
 	if err != nil {
@@ -447,8 +623,10 @@ func (db *DB) fileSize() (int, error) {
 // mmap opens the underlying memory-mapped file and initializes the meta references.
 // minsz is the minimum size that the new mmap can be.
 func (db *DB) mmap(minsz int) (err error) {
-	db.mmaplock.Lock()
-	defer db.mmaplock.Unlock()
+	if db.pcache != nil {
+		// Nothing is mapped, so there is no need to wait for the readers.
+		return db.pcache.resize(minsz)
+	}
 
 	lg := db.Logger()
 
@@ -476,28 +654,25 @@ func (db *DB) mmap(minsz int) (err error) {
 		}
 	}
 
-	// Dereference all mmap references before unmapping.
-	if db.rwtx != nil {
-		db.rwtx.root.dereference()
-	}
-
-	// Unmap existing data before continuing.
-	if err = db.munmap(); err != nil {
-		return err
-	}
-
-	// Memory-map the data file as a byte slice.
+	// Memory-map the data file as a byte slice. The current mapping is kept
+	// until the transactions which began on it are closed, see mmapGen.
 	// gofail: var mapError string
 	// return errors.New(mapError)
-	if err = mmap(db, size); err != nil {
+	g := &mmapGen{}
+	if err = mmap(db, g, size); err != nil {
 		lg.Errorf("[GOOS: %s, GOARCH: %s] mmap failed, size: %d, error: %v", runtime.GOOS, runtime.GOARCH, size, err)
 		return err
 	}
+	db.metalock.Lock()
+	db.setGen(g)
+	db.metalock.Unlock()
 
 	// Perform unmmap on any error to reset all data fields:
 	// dataref, data, datasz, meta0 and meta1.
 	defer func() {
 		if err != nil {
+			db.metalock.Lock()
+			defer db.metalock.Unlock()
 			if unmapErr := db.munmap(); unmapErr != nil {
 				err = fmt.Errorf("%w; rollback unmap also failed: %v", err, unmapErr)
 			}
@@ -511,9 +686,11 @@ func (db *DB) mmap(minsz int) (err error) {
 		}
 	}
 
-	// Save references to the meta pages.
-	db.meta0 = db.page(0).Meta()
-	db.meta1 = db.page(1).Meta()
+	// Lock the pages of the buckets locked with MlockBucket again.
+	if err := db.relockPinned(); err != nil {
+		lg.Errorf("[GOOS: %s, GOARCH: %s] mlock of locked buckets failed, error: %v", runtime.GOOS, runtime.GOARCH, err)
+		return fmt.Errorf("mlock error: %w", err)
+	}
 
 	// Validate the meta pages. We only return an error if both meta pages fail
 	// validation, since meta0 failing validation means that it wasn't saved
@@ -528,7 +705,14 @@ func (db *DB) mmap(minsz int) (err error) {
 	return nil
 }
 
+// mapped returns true if the pages of the file can be read, either through
+// the mmap or through the page cache.
+func (db *DB) mapped() bool {
+	return db.data != nil || (db.pcache != nil && db.meta0 != nil)
+}
+
 func (db *DB) invalidate() {
+	db.gen = nil
 	db.dataref = nil
 	db.data = nil
 	db.datasz = 0
@@ -537,13 +721,24 @@ func (db *DB) invalidate() {
 	db.meta1 = nil
 }
 
-// munmap unmaps the data file from memory.
+// munmap unmaps the data file from memory. The mapping is retired instead
+// if transactions still reference it. The caller must hold the meta lock.
 func (db *DB) munmap() error {
+	db.genlock.Lock()
+	defer db.genlock.Unlock()
 	defer db.invalidate()
 
 	// gofail: var unmapError string
 	// return errors.New(unmapError)
-	if err := munmap(db); err != nil {
+	g := db.gen
+	if g == nil {
+		return nil
+	}
+	if g.txN > 0 {
+		db.retired = append(db.retired, g)
+		return nil
+	}
+	if err := munmap(g); err != nil {
 		db.Logger().Errorf("[GOOS: %s, GOARCH: %s] munmap failed, db.datasz: %d, error: %v", runtime.GOOS, runtime.GOARCH, db.datasz, err)
 		return fmt.Errorf("unmap error: " + err.Error())
 	}
@@ -563,7 +758,7 @@ func (db *DB) mmapSize(size int) (int, error) {
 	}
 
 	// Verify the requested size is not above the maximum allowed.
-	if size > maxMapSize {
+	if size > maxMapSize && db.pcache == nil {
 		return 0, errors.New("mmap too large")
 	}
 
@@ -581,7 +776,7 @@ func (db *DB) mmapSize(size int) (int, error) {
 	}
 
 	// If we've exceeded the max size then only grow up to the max size.
-	if sz > maxMapSize {
+	if sz > maxMapSize && db.pcache == nil {
 		sz = maxMapSize
 	}
 
@@ -595,6 +790,7 @@ func (db *DB) munlock(fileSize int) error {
 		db.Logger().Errorf("[GOOS: %s, GOARCH: %s] munlock failed, fileSize: %d, db.datasz: %d, error: %v", runtime.GOOS, runtime.GOARCH, fileSize, db.datasz, err)
 		return fmt.Errorf("munlock error: " + err.Error())
 	}
+	db.setMlockBytes(0)
 	return nil
 }
 
@@ -605,6 +801,7 @@ func (db *DB) mlock(fileSize int) error {
 		db.Logger().Errorf("[GOOS: %s, GOARCH: %s] mlock failed, fileSize: %d, db.datasz: %d, error: %v", runtime.GOOS, runtime.GOARCH, fileSize, db.datasz, err)
 		return fmt.Errorf("mlock error: " + err.Error())
 	}
+	db.setMlockBytes(min(fileSize, db.datasz))
 	return nil
 }
 
@@ -668,16 +865,29 @@ func (db *DB) init() error {
 // It will block waiting for any open transactions to finish
 // before closing the database and returning.
 func (db *DB) Close() error {
+	// The scrubber may wait for the writer lock.
+	db.StopScrubber()
+
 	db.rwlock.Lock()
 	defer db.rwlock.Unlock()
 
+	// Make the transactions committed in group commit mode durable.
+	db.stopFlusher()
+	var groupErr error
+	if db.group != nil && db.opened {
+		groupErr = db.group.flush(db)
+	}
+
 	db.metalock.Lock()
 	defer db.metalock.Unlock()
 
 	db.mmaplock.Lock()
 	defer db.mmaplock.Unlock()
 
-	return db.close()
+	if err := db.close(); err != nil {
+		return err
+	}
+	return groupErr
 }
 
 func (db *DB) close() error {
@@ -697,8 +907,23 @@ func (db *DB) close() error {
 	if err := db.munmap(); err != nil {
 		errs = append(errs, err)
 	}
+	if err := db.unmapRetired(); err != nil {
+		errs = append(errs, err)
+	}
+
+	// Unmapping the file unlocked all the pages.
+	db.pinlock.Lock()
+	db.pinned, db.pinnedRanges = nil, nil
+	db.pinlock.Unlock()
+	db.setMlockBytes(0)
 
 	// Close file handles.
+	if db.directFile != nil {
+		if err := db.directFile.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("db direct file close: %w", err))
+		}
+		db.directFile = nil
+	}
 	if db.file != nil {
 		// No need to unlock read-only file.
 		if !db.readOnly {
@@ -771,9 +996,9 @@ func (db *DB) beginTx() (*Tx, error) {
 	// write transaction will obtain them.
 	db.metalock.Lock()
 
-	// Obtain a read-only lock on the mmap. When the mmap is remapped it will
-	// obtain a write lock so all transactions must finish before it can be
-	// remapped.
+	// Obtain a read-only lock on the mmap, so that the database can't be
+	// closed until the transaction finishes. Remapping the file doesn't
+	// wait for it, see mmapGen.
 	db.mmaplock.RLock()
 
 	// Exit if the database is not open yet.
@@ -784,15 +1009,24 @@ func (db *DB) beginTx() (*Tx, error) {
 	}
 
 	// Exit if the database is not correctly mapped.
-	if db.data == nil {
+	if !db.mapped() {
 		db.mmaplock.RUnlock()
 		db.metalock.Unlock()
 		return nil, berrors.ErrInvalidMapping
 	}
 
+	// Exit if reading the mmap failed.
+	if err := db.failed(); err != nil {
+		db.mmaplock.RUnlock()
+		db.metalock.Unlock()
+		return nil, err
+	}
+
 	// Create a transaction associated with the database.
 	t := &Tx{}
 	t.init(db)
+	t.gen = db.acquireGen()
+	db.trackReader(t)
 
 	// Keep track of transaction until it closes.
 	db.txs = append(db.txs, t)
@@ -835,16 +1069,41 @@ func (db *DB) beginRWTx() (*Tx, error) {
 	}
 
 	// Exit if the database is not correctly mapped.
-	if db.data == nil {
+	if !db.mapped() {
 		db.rwlock.Unlock()
 		return nil, berrors.ErrInvalidMapping
 	}
 
+	// Exit if reading the mmap failed.
+	if err := db.failed(); err != nil {
+		db.rwlock.Unlock()
+		return nil, err
+	}
+
+	// Exit if syncing the committed transactions failed.
+	if db.group != nil {
+		if err := db.group.failed(); err != nil {
+			db.rwlock.Unlock()
+			return nil, err
+		}
+	}
+
 	// Create a transaction associated with the database.
 	t := &Tx{writable: true}
 	t.init(db)
+	t.gen = db.acquireGen()
 	db.rwtx = t
+	if db.group != nil {
+		// Keep the pages of the last durable transaction, and of the one
+		// a leader may be making durable, until a newer meta page is
+		// durable, like for open read-only transactions.
+		for _, txid := range db.group.pinnedTxids() {
+			db.freelist.AddReadonlyTXID(txid)
+			defer db.freelist.RemoveReadonlyTXID(txid)
+		}
+	}
 	db.freelist.ReleasePendingPages()
+	db.checkReaders()
 	return t, nil
 }
 
@@ -870,6 +1129,7 @@ func (db *DB) removeTx(tx *Tx) {
 	if db.freelist != nil {
 		db.freelist.RemoveReadonlyTXID(tx.meta.Txid())
 	}
+	db.releaseGen(tx.gen)
 
 	// Unlock the meta pages.
 	db.metalock.Unlock()
@@ -888,12 +1148,17 @@ func (db *DB) removeTx(tx *Tx) {
 // returned from the Update() method.
 //
 // Attempting to manually commit or rollback within the function will cause a panic.
-func (db *DB) Update(fn func(*Tx) error) error {
+func (db *DB) Update(fn func(*Tx) error) (err error) {
 	t, err := db.Begin(true)
 	if err != nil {
 		return err
 	}
 
+	if db.catchesIOErrors() {
+		// Return a page which can't be read as an error.
+		defer db.recoverIOError(&err, db.guardFaults())
+	}
+
 	// Make sure the transaction rolls back in the event of a panic.
 	defer func() {
 		if t.db != nil {
@@ -919,12 +1184,17 @@ func (db *DB) Update(fn func(*Tx) error) error {
 // Any error that is returned from the function is returned from the View() method.
 //
 // Attempting to manually rollback within the function will cause a panic.
-func (db *DB) View(fn func(*Tx) error) error {
+func (db *DB) View(fn func(*Tx) error) (err error) {
 	t, err := db.Begin(false)
 	if err != nil {
 		return err
 	}
 
+	if db.catchesIOErrors() {
+		// Return a page which can't be read as an error.
+		defer db.recoverIOError(&err, db.guardFaults())
+	}
+
 	// Make sure the transaction rolls back in the event of a panic.
 	defer func() {
 		if t.db != nil {
@@ -964,6 +1234,32 @@ func (db *DB) View(fn func(*Tx) error) error {
 //
 // Batch is only useful when there are multiple goroutines calling it.
 func (db *DB) Batch(fn func(*Tx) error) error {
+	return db.BatchWithOptions(fn, BatchOptions{})
+}
+
+// BatchOptions configures a single call to DB.BatchWithOptions.
+type BatchOptions struct {
+	// Priority orders the calls within a batch. The calls with a higher
+	// priority run first; calls with the same priority run in the order
+	// they were made.
+	Priority int
+
+	// Deadline is the time by which the call must be run. The batch is
+	// started right away if it wouldn't otherwise start before Deadline,
+	// and the call fails with ErrBatchDeadline without being run if the
+	// batch transaction can't begin before Deadline. Zero means no deadline.
+	Deadline time.Time
+
+	// Isolated makes a failing function leave the batch: its error is
+	// returned and the function isn't called again, while the rest of the
+	// batch is run again without it. Otherwise, a failing function makes
+	// the rest of the batch run again and is then run alone.
+	Isolated bool
+}
+
+// BatchWithOptions calls fn as part of a batch, like Batch, configured by
+// the given options.
+func (db *DB) BatchWithOptions(fn func(*Tx) error, opts BatchOptions) error {
 	errCh := make(chan error, 1)
 
 	db.batchMu.Lock()
@@ -973,9 +1269,10 @@ func (db *DB) Batch(fn func(*Tx) error) error {
 			db: db,
 		}
 		db.batch.timer = time.AfterFunc(db.MaxBatchDelay, db.batch.trigger)
+		db.batch.due = time.Now().Add(db.MaxBatchDelay)
 	}
-	db.batch.calls = append(db.batch.calls, call{fn: fn, err: errCh})
-	if len(db.batch.calls) >= db.MaxBatchSize {
+	db.batch.calls = append(db.batch.calls, call{fn: fn, err: errCh, opts: opts})
+	if len(db.batch.calls) >= db.MaxBatchSize || (!opts.Deadline.IsZero() && opts.Deadline.Before(db.batch.due)) {
 		// wake up batch, it's ready to run
 		go db.batch.trigger()
 	}
@@ -983,19 +1280,24 @@ func (db *DB) Batch(fn func(*Tx) error) error {
 
 	err := <-errCh
 	if err == trySolo {
+		db.statlock.Lock()
+		db.stats.BatchSoloN++
+		db.statlock.Unlock()
 		err = db.Update(fn)
 	}
 	return err
 }
 
 type call struct {
-	fn  func(*Tx) error
-	err chan<- error
+	fn   func(*Tx) error
+	err  chan<- error
+	opts BatchOptions
 }
 
 type batch struct {
 	db    *DB
 	timer *time.Timer
+	due   time.Time
 	start sync.Once
 	calls []call
 }
@@ -1017,27 +1319,52 @@ func (b *batch) run() {
 	}
 	b.db.batchMu.Unlock()
 
+	sort.SliceStable(b.calls, func(i, j int) bool {
+		return b.calls[i].opts.Priority > b.calls[j].opts.Priority
+	})
+
 retry:
 	for len(b.calls) > 0 {
 		var failIdx = -1
+		var failErr error
+		var expired []int
 		err := b.db.Update(func(tx *Tx) error {
+			now := time.Now()
 			for i, c := range b.calls {
+				if !c.opts.Deadline.IsZero() && now.After(c.opts.Deadline) {
+					expired = append(expired, i)
+					continue
+				}
 				if err := safelyCall(c.fn, tx); err != nil {
-					failIdx = i
+					failIdx, failErr = i, err
 					return err
 				}
 			}
 			return nil
 		})
+		isolated := failIdx >= 0 && b.calls[failIdx].opts.Isolated
+		b.db.recordBatch(len(b.calls), failIdx >= 0, isolated)
+
+		// Answer the expired calls and the failed isolated call, which are
+		// never run again, and take them out of the batch.
+		var rest []call
+		for i, c := range b.calls {
+			if i == failIdx && isolated {
+				c.err <- failErr
+			} else if i == failIdx {
+				// tell the submitter re-run it solo, continue with the rest of the batch
+				c.err <- trySolo
+			} else if containsIndex(expired, i) {
+				c.err <- berrors.ErrBatchDeadline
+			} else {
+				rest = append(rest, c)
+			}
+		}
+		// it's safe to replace b.calls here because db.batch no longer
+		// points to us, and we hold the mutex anyway.
+		b.calls = rest
 
 		if failIdx >= 0 {
-			// take the failing transaction out of the batch. it's
-			// safe to shorten b.calls here because db.batch no longer
-			// points to us, and we hold the mutex anyway.
-			c := b.calls[failIdx]
-			b.calls[failIdx], b.calls = b.calls[len(b.calls)-1], b.calls[:len(b.calls)-1]
-			// tell the submitter re-run it solo, continue with the rest of the batch
-			c.err <- trySolo
 			continue retry
 		}
 
@@ -1049,6 +1376,32 @@ retry:
 	}
 }
 
+// containsIndex returns true if the sorted list of indexes contains i.
+func containsIndex(idxs []int, i int) bool {
+	j := sort.SearchInts(idxs, i)
+	return j < len(idxs) && idxs[j] == i
+}
+
+// recordBatch updates the batch statistics after running a batch
+// transaction with n calls.
+func (db *DB) recordBatch(n int, retry bool, isolated bool) {
+	db.statlock.Lock()
+	defer db.statlock.Unlock()
+	db.stats.BatchN++
+	db.stats.BatchCallN += n
+	if retry {
+		db.stats.BatchRetryN++
+	}
+	if isolated {
+		db.stats.BatchRollbackN++
+	}
+	bin := bits.Len(uint(n)) - 1
+	if bin >= len(db.stats.BatchSizeHist) {
+		bin = len(db.stats.BatchSizeHist) - 1
+	}
+	db.stats.BatchSizeHist[bin]++
+}
+
 // trySolo is a special sentinel error value used for signaling that a
 // transaction function should be re-run. It should never be seen by
 // callers.
@@ -1097,19 +1450,30 @@ func (db *DB) Sync() (err error) {
 // This is only updated when a transaction closes.
 func (db *DB) Stats() Stats {
 	db.statlock.RLock()
-	defer db.statlock.RUnlock()
-	return db.stats
+	s := db.stats
+	db.statlock.RUnlock()
+	if db.pcache != nil {
+		db.pcache.stats(&s)
+	}
+	return s
 }
 
 // This is for internal access to the raw data bytes from the C cursor, use
 // carefully, or not at all.
 func (db *DB) Info() *Info {
+	if db.pcache != nil {
+		// The file isn't mapped with Options.NoMmap.
+		return &Info{0, db.pageSize}
+	}
 	common.Assert(db.data != nil, "database file isn't correctly mapped")
 	return &Info{uintptr(unsafe.Pointer(&db.data[0])), db.pageSize}
 }
 
 // page retrieves a page reference from the mmap based on the current page size.
 func (db *DB) page(id common.Pgid) *common.Page {
+	if db.pcache != nil {
+		return db.pcache.page(id)
+	}
 	pos := id * common.Pgid(db.pageSize)
 	return (*common.Page)(unsafe.Pointer(&db.data[pos]))
 }
@@ -1132,7 +1496,7 @@ func (db *DB) meta() *common.Meta {
 	}
 
 	// Use higher meta page if valid. Otherwise, fallback to previous, if valid.
-	if err := metaA.Validate(); err == nil {
+	if err := metaA.Validate(); err == nil && metaA != db.tornMeta {
 		return metaA
 	} else if err := metaB.Validate(); err == nil {
 		return metaB
@@ -1143,6 +1507,18 @@ func (db *DB) meta() *common.Meta {
 	panic("bolt.DB.meta(): invalid meta pages")
 }
 
+// writerMeta returns the meta a new writable transaction starts from. In
+// group commit mode it's the latest committed meta, which may not be written
+// to disk yet.
+func (db *DB) writerMeta() *common.Meta {
+	if db.group != nil {
+		if m := db.group.pendingMeta(); m != nil {
+			return m
+		}
+	}
+	return db.meta()
+}
+
 // allocate returns a contiguous block of memory starting at a given page.
 func (db *DB) allocate(txid common.Txid, count int) (*common.Page, error) {
 	// Allocate a temporary buffer for the page.
@@ -1150,7 +1526,7 @@ func (db *DB) allocate(txid common.Txid, count int) (*common.Page, error) {
 	if count == 1 {
 		buf = db.pagePool.Get().([]byte)
 	} else {
-		buf = make([]byte, count*db.pageSize)
+		buf = db.pageBuffer(count * db.pageSize)
 	}
 	p := (*common.Page)(unsafe.Pointer(&buf[0]))
 	p.SetOverflow(uint32(count - 1))
@@ -1195,7 +1571,7 @@ func (db *DB) grow(sz int) error {
 	if db.datasz <= db.AllocSize {
 		sz = db.datasz
 	} else {
-		sz += db.AllocSize
+		sz += db.growStep(fileSize)
 	}
 
 	// Truncate and fsync to ensure file size metadata is flushed.
@@ -1204,15 +1580,23 @@ func (db *DB) grow(sz int) error {
 		if runtime.GOOS != "windows" {
 			// gofail: var resizeFileError string
 			// return errors.New(resizeFileError)
-			if err := db.file.Truncate(int64(sz)); err != nil {
+			if err := db.resize(fileSize, sz); err != nil {
 				lg.Errorf("[GOOS: %s, GOARCH: %s] truncating file failed, size: %d, db.datasz: %d, error: %v", runtime.GOOS, runtime.GOARCH, sz, db.datasz, err)
-				return fmt.Errorf("file resize error: %s", err)
+				return fmt.Errorf("file resize error: %w", err)
 			}
 		}
 		if err := db.file.Sync(); err != nil {
 			lg.Errorf("[GOOS: %s, GOARCH: %s] syncing file failed, db.datasz: %d, error: %v", runtime.GOOS, runtime.GOARCH, db.datasz, err)
 			return fmt.Errorf("file sync error: %s", err)
 		}
+		if db.growthPolicy.ReserveSize > 0 {
+			// Keep the space for the next growth allocated, so that running
+			// out of disk space is detected before the pages are written.
+			if err := fallocate(db, int64(sz), int64(db.growthPolicy.ReserveSize), true); err != nil && err != errFallocateUnsupported {
+				lg.Errorf("[GOOS: %s, GOARCH: %s] reserving disk space failed, size: %d, error: %v", runtime.GOOS, runtime.GOARCH, db.growthPolicy.ReserveSize, err)
+				return fmt.Errorf("file reserve error: %w", noSpaceError(err))
+			}
+		}
 		if db.Mlock {
 			// unlock old file and lock new one
 			if err := db.mrelock(fileSize, sz); err != nil {
@@ -1224,6 +1608,49 @@ func (db *DB) grow(sz int) error {
 	return nil
 }
 
+// growStep returns the number of bytes to grow a data file of the given size
+// by, once it's larger than the allocation size.
+func (db *DB) growStep(fileSize int) int {
+	step := db.AllocSize
+	if db.growthPolicy.GrowPercent > 0 {
+		sz := int64(fileSize) * int64(db.growthPolicy.GrowPercent) / 100
+		if db.growthPolicy.MaxGrowSize > 0 && sz > int64(db.growthPolicy.MaxGrowSize) {
+			sz = int64(db.growthPolicy.MaxGrowSize)
+		}
+		if sz > maxMapSize {
+			sz = maxMapSize
+		}
+		if sz > int64(step) {
+			step = int(sz)
+		}
+	}
+	return step
+}
+
+// resize changes the size of the data file from fileSize to sz, allocating
+// the disk blocks when preallocation is enabled.
+func (db *DB) resize(fileSize, sz int) error {
+	if db.growthPolicy.Preallocate {
+		err := fallocate(db, int64(fileSize), int64(sz-fileSize), false)
+		if err != errFallocateUnsupported {
+			return noSpaceError(err)
+		}
+	}
+	return noSpaceError(db.file.Truncate(int64(sz)))
+}
+
+// noSpaceError converts an ENOSPC error into ErrNoSpace.
+func noSpaceError(err error) error {
+	if errors.Is(err, syscall.ENOSPC) {
+		return fmt.Errorf("%w: %v", berrors.ErrNoSpace, err)
+	}
+	return err
+}
+
+// errFallocateUnsupported is returned by fallocate when preallocating disk
+// blocks isn't supported by the platform or file system.
+var errFallocateUnsupported = errors.New("fallocate not supported")
+
 func (db *DB) IsReadOnly() bool {
 	return db.readOnly
 }
@@ -1240,7 +1667,12 @@ func (db *DB) freepages() []common.Pgid {
 		panic("freepages: failed to open read only tx")
 	}
 
-	reachable := make(map[common.Pgid]*common.Page)
+	// Scan the latest committed transaction, which may not be durable yet
+	// in group commit mode.
+	m := db.writerMeta()
+	*tx.root.InBucket = *m.RootBucket()
+
+	reachable := make(map[common.Pgid]bool)
 	nofreed := make(map[common.Pgid]bool)
 	ech := make(chan error)
 	go func() {
@@ -1248,14 +1680,15 @@ func (db *DB) freepages() []common.Pgid {
 			panic(fmt.Sprintf("freepages: failed to get all reachable pages (%v)", e))
 		}
 	}()
-	tx.recursivelyCheckBucket(&tx.root, reachable, nofreed, HexKVStringer(), ech)
+	// The check can't be canceled without a context.
+	_ = tx.recursivelyCheckBucket(&tx.root, nil, reachable, nofreed, HexKVStringer(), newProgressTracker(nil, nil, ProgressPages, 0), ech)
 	close(ech)
 
 	// TODO: If check bucket reported any corruptions (ech) we shouldn't proceed to freeing the pages.
 
 	var fids []common.Pgid
-	for i := common.Pgid(2); i < db.meta().Pgid(); i++ {
-		if _, ok := reachable[i]; !ok {
+	for i := common.Pgid(2); i < m.Pgid(); i++ {
+		if !reachable[i] {
 			fids = append(fids, i)
 		}
 	}
@@ -1301,6 +1734,13 @@ type Options struct {
 	// Sets the DB.MmapFlags flag before memory mapping the file.
 	MmapFlags int
 
+	// MmapAdvice is given to the kernel for the whole mmap every time the
+	// file is mapped. Use DB.Advise, DB.AdviseBucket and DB.Warm to advise
+	// parts of the file. (UNIX only)
+	//
+	// The default is MmapAdviceRandom.
+	MmapAdvice MmapAdvice
+
 	// InitialMmapSize is the initial mmap size of the database
 	// in bytes. Read transactions won't block write transaction
 	// if the InitialMmapSize is large enough to hold database mmap
@@ -1330,6 +1770,133 @@ type Options struct {
 
 	// Logger is the logger used for bbolt.
 	Logger Logger
+
+	// SecureDelete overwrites pages with zeros on disk once they are no
+	// longer referenced by any transaction, so that deleted or overwritten
+	// values don't linger in the file until the pages are reused. The zeroed
+	// pages are written and synced by the next commit.
+	//
+	// Pages which were already free when the database was opened are not
+	// zeroed; use DB.ScrubFreePages for them.
+	SecureDelete bool
+
+	// ReaderWarnThreshold reports read-only transactions which stay open
+	// for too long or prevent too many pages from being reused, which
+	// otherwise makes the database file grow without bound.
+	ReaderWarnThreshold ReaderWarnThreshold
+
+	// GrowthPolicy controls how the data file grows once it's larger than
+	// DB.AllocSize. The default grows by DB.AllocSize using truncate().
+	GrowthPolicy GrowthPolicy
+
+	// WriteConcurrency sets the DB.WriteConcurrency value.
+	WriteConcurrency int
+
+	// GroupCommit lets concurrent writable transactions share the syncing
+	// of the file. A transaction writes its dirty pages and hands over to
+	// the next writer without syncing them, then Tx.Commit waits until a
+	// single fdatasync() of the data and of the meta page covers all the
+	// transactions committed meanwhile. Commit still returns only once the
+	// transaction is durable, and read-only transactions only see durable
+	// transactions.
+	//
+	// If syncing the file fails, every following writable transaction
+	// fails with the same error and the database must be reopened.
+	GroupCommit bool
+
+	// DurabilityMode defines when committed transactions are synced to
+	// disk. With DurabilityPeriodic and DurabilityNone, Tx.Commit returns
+	// before the transaction is durable, and a crash loses the transactions
+	// committed since the last sync but never corrupts the database: the
+	// file always holds the state of the last synced transaction. Use
+	// DB.Flush to sync the committed transactions explicitly. As with
+	// Options.GroupCommit, a failed sync makes the following writable
+	// transactions fail.
+	//
+	// Unlike DB.NoSync, these modes are crash safe, but the pages freed
+	// since the last sync can't be reused until the next sync.
+	//
+	// The default is DurabilitySync.
+	DurabilityMode DurabilityMode
+
+	// FlushInterval is the interval at which the committed transactions
+	// are synced with DurabilityPeriodic.
+	//
+	// If <=0, defaults to 100ms.
+	FlushInterval time.Duration
+
+	// NoMmap reads the pages of the file with pread() instead of memory
+	// mapping it. The pages read last are cached, up to PageCacheSize bytes.
+	// It lifts the limit on the size of the database on 32-bit platforms,
+	// and a page which can't be read makes the managed transactions and
+	// Tx.Check return an error instead of crashing the process. Cursors and
+	// buckets used outside of DB.View and DB.Update panic instead.
+	//
+	// Options.Mlock, DB.MlockBucket and the madvise() hints have no effect.
+	NoMmap bool
+
+	// PageCacheSize is the maximum size of the page cache used with NoMmap,
+	// in bytes.
+	//
+	// If <=0, defaults to 64MB.
+	PageCacheSize int
+
+	// GuardedReads turns an I/O error reading the memory mapped file, which
+	// otherwise crashes the process with SIGBUS, into an error. The reads
+	// made by cursors, Bucket.Get, Tx.Check and the functions passed to
+	// DB.View and DB.Update are guarded with debug.SetPanicOnFault. A fault
+	// makes the managed transactions and Tx.Check return an IOError, and
+	// cursors and Bucket.Get used outside of them panic with it. Once a read
+	// failed, every following transaction fails with the same error.
+	GuardedReads bool
+
+	// HugePages asks the kernel to back the memory mapped file with
+	// transparent huge pages (MADV_HUGEPAGE), which reduces the TLB misses
+	// of large read-mostly databases. It's ignored if the kernel doesn't
+	// support them for the file, and with NoMmap. (Linux only)
+	HugePages bool
+
+	// DirectIO writes the dirty pages of a commit with O_DIRECT, bypassing
+	// the page cache, from page buffers aligned for it. The pages are
+	// written through the page cache instead when the page size isn't a
+	// multiple of 4KB, when the file system doesn't support O_DIRECT, or
+	// when a write can't be aligned. (Linux only)
+	DirectIO bool
+
+	// SingleSyncCommit commits a transaction with a single fdatasync()
+	// instead of two, by writing the data pages and the meta page before
+	// syncing. The meta page stores a checksum of the pages written by the
+	// transaction, so that Open detects a transaction which didn't
+	// completely reach the disk and falls back to the previous one.
+	//
+	// It's ignored with GroupCommit and deferred durability modes, which
+	// sync the meta page separately anyway.
+	SingleSyncCommit bool
+}
+
+// GrowthPolicy controls how the database file grows.
+type GrowthPolicy struct {
+	// Preallocate allocates the disk blocks with fallocate() when growing
+	// the file, instead of creating a sparse file with truncate(), so that
+	// running out of disk space is reported as ErrNoSpace by Tx.Commit
+	// rather than by a later write. It falls back to truncate() when the
+	// file system doesn't support it. (Linux only)
+	Preallocate bool
+
+	// GrowPercent grows the file by the given percentage of its current
+	// size, if that's larger than DB.AllocSize. E.g. 100 doubles the file
+	// every time it grows.
+	GrowPercent int
+
+	// MaxGrowSize caps the growth computed from GrowPercent, in bytes.
+	// If <=0, the growth isn't capped.
+	MaxGrowSize int
+
+	// ReserveSize is the number of bytes kept allocated on disk past the
+	// end of the file with fallocate(FALLOC_FL_KEEP_SIZE). Tx.Commit fails
+	// with ErrNoSpace when the reservation can't be refilled after the file
+	// grows. (Linux only)
+	ReserveSize int
 }
 
 func (o *Options) String() string {
@@ -1337,8 +1904,8 @@ func (o *Options) String() string {
 		return "{}"
 	}
 
-	return fmt.Sprintf("{Timeout: %s, NoGrowSync: %t, NoFreelistSync: %t, PreLoadFreelist: %t, FreelistType: %s, ReadOnly: %t, MmapFlags: %x, InitialMmapSize: %d, PageSize: %d, NoSync: %t, OpenFile: %p, Mlock: %t, Logger: %p}",
-		o.Timeout, o.NoGrowSync, o.NoFreelistSync, o.PreLoadFreelist, o.FreelistType, o.ReadOnly, o.MmapFlags, o.InitialMmapSize, o.PageSize, o.NoSync, o.OpenFile, o.Mlock, o.Logger)
+	return fmt.Sprintf("{Timeout: %s, NoGrowSync: %t, NoFreelistSync: %t, PreLoadFreelist: %t, FreelistType: %s, ReadOnly: %t, MmapFlags: %x, MmapAdvice: %s, InitialMmapSize: %d, PageSize: %d, NoSync: %t, OpenFile: %p, Mlock: %t, Logger: %p, SecureDelete: %t, ReaderWarnThreshold: %+v, GrowthPolicy: %+v, WriteConcurrency: %d, GroupCommit: %t, DurabilityMode: %s, FlushInterval: %s, SingleSyncCommit: %t, NoMmap: %t, PageCacheSize: %d, GuardedReads: %t, HugePages: %t, DirectIO: %t}",
+		o.Timeout, o.NoGrowSync, o.NoFreelistSync, o.PreLoadFreelist, o.FreelistType, o.ReadOnly, o.MmapFlags, o.MmapAdvice, o.InitialMmapSize, o.PageSize, o.NoSync, o.OpenFile, o.Mlock, o.Logger, o.SecureDelete, o.ReaderWarnThreshold, o.GrowthPolicy, o.WriteConcurrency, o.GroupCommit, o.DurabilityMode, o.FlushInterval, o.SingleSyncCommit, o.NoMmap, o.PageCacheSize, o.GuardedReads, o.HugePages, o.DirectIO)
 
 }
 
@@ -1367,6 +1934,29 @@ type Stats struct {
 	// Transaction stats
 	TxN     int // total number of started read transactions
 	OpenTxN int // number of currently open read transactions
+
+	// Memory stats
+	MlockBytes int // bytes of the mmap locked in memory, see Options.Mlock and DB.MlockBucket
+
+	// Page cache stats, see Options.NoMmap
+	PageCacheHitN  int // total number of page reads served from the page cache
+	PageCacheMissN int // total number of page reads from the file
+	PageCacheSize  int // total bytes of the pages in the page cache
+
+	// Batch stats
+	BatchN         int     // total number of batch transactions
+	BatchCallN     int     // total number of calls run in batch transactions
+	BatchRetryN    int     // total number of batch transactions retried after a failed call
+	BatchSoloN     int     // total number of failed calls run again in their own transaction
+	BatchRollbackN int     // total number of failed isolated calls left out of their batch
+	BatchSizeHist  [16]int // number of batch transactions by size; bin i counts sizes in [2^i, 2^(i+1))
+
+	// Scrubber stats, see DB.StartScrubber
+	ScrubPassN      int       // total number of completed scrubber passes
+	ScrubPageN      int       // total number of pages checked by the scrubber
+	ScrubErrorN     int       // total number of inconsistencies found by the scrubber
+	LastScrubTime   time.Time // end of the last completed scrubber pass
+	LastScrubErrorN int       // number of inconsistencies found by the last completed scrubber pass
 }
 
 // Sub calculates and returns the difference between two sets of database stats.
@@ -1381,7 +1971,24 @@ func (s *Stats) Sub(other *Stats) Stats {
 	diff.PendingPageN = s.PendingPageN
 	diff.FreeAlloc = s.FreeAlloc
 	diff.FreelistInuse = s.FreelistInuse
+	diff.MlockBytes = s.MlockBytes
+	diff.PageCacheHitN = s.PageCacheHitN - other.PageCacheHitN
+	diff.PageCacheMissN = s.PageCacheMissN - other.PageCacheMissN
+	diff.PageCacheSize = s.PageCacheSize
 	diff.TxN = s.TxN - other.TxN
+	diff.BatchN = s.BatchN - other.BatchN
+	diff.BatchCallN = s.BatchCallN - other.BatchCallN
+	diff.BatchRetryN = s.BatchRetryN - other.BatchRetryN
+	diff.BatchSoloN = s.BatchSoloN - other.BatchSoloN
+	diff.BatchRollbackN = s.BatchRollbackN - other.BatchRollbackN
+	diff.ScrubPassN = s.ScrubPassN - other.ScrubPassN
+	diff.ScrubPageN = s.ScrubPageN - other.ScrubPageN
+	diff.ScrubErrorN = s.ScrubErrorN - other.ScrubErrorN
+	diff.LastScrubTime = s.LastScrubTime
+	diff.LastScrubErrorN = s.LastScrubErrorN
+	for i := range diff.BatchSizeHist {
+		diff.BatchSizeHist[i] = s.BatchSizeHist[i] - other.BatchSizeHist[i]
+	}
 	diff.TxStats = s.TxStats.Sub(&other.TxStats)
 	return diff
 }
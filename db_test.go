@@ -419,6 +419,32 @@ func TestOpen_Check(t *testing.T) {
 	}
 }
 
+// Ensure that the data file grows by a percentage of its size with GrowPercent.
+func TestOpen_GrowthPolicy_GrowPercent(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{
+		GrowthPolicy: bolt.GrowthPolicy{GrowPercent: 100},
+	})
+	db.AllocSize = 64 * 1024
+
+	var prevSz int64
+	for i := 0; i < 20; i++ {
+		err := db.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists([]byte("data"))
+			if err != nil {
+				return err
+			}
+			return b.Put([]byte(fmt.Sprintf("%04d", i)), make([]byte, 64*1024))
+		})
+		require.NoError(t, err)
+
+		sz := fileSize(db.Path())
+		if prevSz > int64(db.AllocSize) && sz > prevSz {
+			require.GreaterOrEqualf(t, sz, 2*prevSz, "unexpected file growth: %d => %d", prevSz, sz)
+		}
+		prevSz = sz
+	}
+}
+
 // Ensure that write errors to the meta file handler during initialization are returned.
 func TestOpen_MetaInitWriteError(t *testing.T) {
 	t.Skip("pending")
@@ -823,6 +849,58 @@ func testDB_Close_PendingTx(t *testing.T, writable bool) {
 	}
 }
 
+// Ensure that growing the mmap doesn't wait for the open read transactions,
+// which keep reading the mapping they began on.
+func TestDB_Remap_PendingTx(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("foo"), []byte("bar"))
+	}))
+
+	tx, err := db.Begin(false)
+	require.NoError(t, err)
+	v := tx.Bucket([]byte("widgets")).Get([]byte("foo"))
+	require.Equal(t, []byte("bar"), v)
+	mmapSize := db.Info().Data
+
+	// Grow the file past the initial 32KB mmap a few times.
+	done := make(chan error, 1)
+	go func() {
+		done <- db.Update(func(tx *bolt.Tx) error {
+			b := tx.Bucket([]byte("widgets"))
+			for i := 0; i < 1000; i++ {
+				if err := b.Put([]byte(fmt.Sprintf("%04d", i)), make([]byte, 500)); err != nil {
+					return err
+				}
+			}
+			return b.Put([]byte("foo"), []byte("baz"))
+		})
+	}()
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("remapping waited for the read transaction")
+	}
+	require.NotEqual(t, mmapSize, db.Info().Data, "the file wasn't remapped")
+
+	// The read transaction still reads its snapshot from the previous mmap.
+	require.Equal(t, []byte("bar"), v)
+	require.Equal(t, []byte("bar"), tx.Bucket([]byte("widgets")).Get([]byte("foo")))
+	require.Nil(t, tx.Bucket([]byte("widgets")).Get([]byte("0000")))
+	require.NoError(t, tx.Rollback())
+
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		require.Equal(t, []byte("baz"), tx.Bucket([]byte("widgets")).Get([]byte("foo")))
+		require.Equal(t, 1001, tx.Bucket([]byte("widgets")).Stats().KeyN)
+		return nil
+	}))
+}
+
 // Ensure a database can provide a transactional block.
 func TestDB_Update(t *testing.T) {
 	db := btesting.MustCreateDB(t)
@@ -1351,6 +1429,192 @@ func TestDB_BatchTime(t *testing.T) {
 	}
 }
 
+// Ensure a failing isolated call leaves the batch and isn't run again.
+func TestDB_BatchWithOptions_Isolated(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 1000; i++ {
+			if err := b.Put(u64tob(uint64(i)), make([]byte, 100)); err != nil {
+				return err
+			}
+		}
+		_, err = tx.CreateBucket([]byte("gadgets"))
+		return err
+	}))
+
+	db.MaxBatchSize = 3
+	db.MaxBatchDelay = 1 * time.Hour
+	before := db.Stats()
+
+	put := func(k uint64) func(*bolt.Tx) error {
+		return func(tx *bolt.Tx) error {
+			return tx.Bucket([]byte("gadgets")).Put(u64tob(k), []byte{})
+		}
+	}
+	errFailed := errors.New("failed")
+	var calls int
+	fail := func(tx *bolt.Tx) error {
+		calls++
+		if err := tx.Bucket([]byte("gadgets")).Put(u64tob(2), []byte{}); err != nil {
+			return err
+		}
+		if err := tx.Bucket([]byte("gadgets")).Delete(u64tob(1)); err != nil {
+			return err
+		}
+		if err := tx.DeleteBucket([]byte("widgets")); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucket([]byte("doodads")); err != nil {
+			return err
+		}
+		return errFailed
+	}
+
+	ch := make(chan error, 3)
+	go func() { ch <- db.Batch(put(1)) }()
+	time.Sleep(10 * time.Millisecond)
+	go func() { ch <- db.BatchWithOptions(fail, bolt.BatchOptions{Isolated: true}) }()
+	time.Sleep(10 * time.Millisecond)
+	go func() { ch <- db.Batch(put(3)) }()
+
+	var errs []error
+	for i := 0; i < 3; i++ {
+		if err := <-ch; err != nil {
+			errs = append(errs, err)
+		}
+	}
+	require.Equal(t, []error{errFailed}, errs)
+	require.Equal(t, 1, calls)
+
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		require.Nil(t, tx.Bucket([]byte("doodads")))
+		require.Equal(t, 1000, tx.Bucket([]byte("widgets")).Stats().KeyN)
+		b := tx.Bucket([]byte("gadgets"))
+		require.NotNil(t, b.Get(u64tob(1)))
+		require.Nil(t, b.Get(u64tob(2)))
+		require.NotNil(t, b.Get(u64tob(3)))
+		return nil
+	}))
+	db.MustCheck()
+
+	stats := db.Stats()
+	diff := stats.Sub(&before)
+	require.Equal(t, 2, diff.BatchN)
+	require.Equal(t, 5, diff.BatchCallN)
+	require.Equal(t, 1, diff.BatchRollbackN)
+	require.Equal(t, 1, diff.BatchRetryN)
+	require.Equal(t, 0, diff.BatchSoloN)
+	require.Equal(t, 2, diff.BatchSizeHist[1])
+}
+
+// Ensure a failing call which isn't isolated makes the rest of the batch
+// run again and is then run alone.
+func TestDB_BatchWithOptions_Retry(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("widgets"))
+		return err
+	}))
+
+	db.MaxBatchSize = 2
+	db.MaxBatchDelay = 1 * time.Hour
+	before := db.Stats()
+
+	errFailed := errors.New("failed")
+	ch := make(chan error, 2)
+	go func() {
+		ch <- db.Batch(func(tx *bolt.Tx) error {
+			return errFailed
+		})
+	}()
+	go func() {
+		ch <- db.Batch(func(tx *bolt.Tx) error {
+			return tx.Bucket([]byte("widgets")).Put([]byte("foo"), []byte("bar"))
+		})
+	}()
+
+	var errs []error
+	for i := 0; i < 2; i++ {
+		if err := <-ch; err != nil {
+			errs = append(errs, err)
+		}
+	}
+	require.Equal(t, []error{errFailed}, errs)
+
+	stats := db.Stats()
+	diff := stats.Sub(&before)
+	require.Equal(t, 2, diff.BatchN)
+	require.Equal(t, 3, diff.BatchCallN)
+	require.Equal(t, 1, diff.BatchRetryN)
+	require.Equal(t, 1, diff.BatchSoloN)
+	require.Equal(t, 1, diff.BatchSizeHist[0])
+	require.Equal(t, 1, diff.BatchSizeHist[1])
+}
+
+// Ensure the calls with a higher priority run first.
+func TestDB_BatchWithOptions_Priority(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+
+	const size = 4
+	db.MaxBatchSize = size
+	db.MaxBatchDelay = 1 * time.Hour
+
+	var order []int
+	ch := make(chan error, size)
+	for _, priority := range []int{0, 2, -1, 2} {
+		priority := priority
+		go func() {
+			ch <- db.BatchWithOptions(func(tx *bolt.Tx) error {
+				order = append(order, priority)
+				return nil
+			}, bolt.BatchOptions{Priority: priority})
+		}()
+		// Give the call a chance to join the batch.
+		time.Sleep(10 * time.Millisecond)
+	}
+	for i := 0; i < size; i++ {
+		require.NoError(t, <-ch)
+	}
+	require.Equal(t, []int{2, 2, 0, -1}, order)
+}
+
+// Ensure a call with a deadline starts the batch early, and fails without
+// being run if the batch can't begin in time.
+func TestDB_BatchWithOptions_Deadline(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	db.MaxBatchSize = 1000
+	db.MaxBatchDelay = 1 * time.Hour
+
+	var called bool
+	fn := func(tx *bolt.Tx) error {
+		called = true
+		return nil
+	}
+
+	// The batch is triggered right away instead of after MaxBatchDelay.
+	err := db.BatchWithOptions(fn, bolt.BatchOptions{Deadline: time.Now().Add(time.Second)})
+	require.NoError(t, err)
+	require.True(t, called)
+
+	// Hold the writer lock past the deadline.
+	called = false
+	tx, err := db.Begin(true)
+	require.NoError(t, err)
+	ch := make(chan error, 1)
+	go func() {
+		ch <- db.BatchWithOptions(fn, bolt.BatchOptions{Deadline: time.Now().Add(10 * time.Millisecond)})
+	}()
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, tx.Rollback())
+
+	require.ErrorIs(t, <-ch, berrors.ErrBatchDeadline)
+	require.False(t, called)
+}
+
 // TestDBUnmap verifes that `dataref`, `data` and `datasz` must be reset
 // to zero values respectively after unmapping the db.
 func TestDBUnmap(t *testing.T) {
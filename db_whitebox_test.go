@@ -1,7 +1,9 @@
 package bbolt
 
 import (
+	"fmt"
 	"path/filepath"
+	"syscall"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -124,3 +126,52 @@ func prepareData(t *testing.T) (string, error) {
 
 	return fileName, nil
 }
+
+// Ensure that all the data pages are written through DB.ops.writeAt once
+// it's hooked, including the adjacent pages written at once.
+func TestDB_WriteAtHook(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "db"), 0600, nil)
+	require.NoError(t, err)
+	defer db.Close()
+	require.True(t, db.writesToFile())
+
+	var sizes []int
+	writeAt := db.ops.writeAt
+	db.ops.writeAt = func(b []byte, off int64) (int, error) {
+		sizes = append(sizes, len(b))
+		if off >= 2*int64(db.pageSize) {
+			return 0, syscall.EIO
+		}
+		return writeAt(b, off)
+	}
+	db.ops.writeAtHooked = true
+	require.False(t, db.writesToFile())
+
+	err = db.Update(func(tx *Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 100; i++ {
+			if err := b.Put([]byte(fmt.Sprintf("%04d", i)), make([]byte, 1000)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.ErrorIs(t, err, syscall.EIO)
+	require.NotEmpty(t, sizes)
+	require.Greater(t, sizes[0], db.pageSize)
+}
+
+func TestDB_GrowStep(t *testing.T) {
+	db := &DB{AllocSize: 1}
+	db.growthPolicy.GrowPercent = 300
+	require.Equal(t, 150, db.growStep(50))
+
+	db.growthPolicy.GrowPercent = 50
+	require.Equal(t, 125, db.growStep(250))
+
+	db.growthPolicy.MaxGrowSize = 100
+	require.Equal(t, 100, db.growStep(250))
+}
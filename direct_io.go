@@ -0,0 +1,82 @@
+package bbolt
+
+import (
+	"errors"
+	"syscall"
+	"unsafe"
+)
+
+// With Options.DirectIO, the dirty pages of a commit are written through a
+// second descriptor of the data file opened with O_DIRECT, so that they
+// bypass the page cache. O_DIRECT requires the buffers, offsets and sizes of
+// the writes to be aligned to the logical block size of the device, so the
+// page buffers are allocated aligned to directIOAlignment. The meta page is
+// still written through the page cache, and fdatasync() makes both durable.
+
+// directIOAlignment is the alignment of the writes made with O_DIRECT. It's
+// a multiple of the logical block size of the common devices.
+const directIOAlignment = 4096
+
+// errDirectIOUnsupported is returned by openDirectFile on the platforms
+// which don't support O_DIRECT.
+var errDirectIOUnsupported = errors.New("direct I/O is not supported")
+
+// openDirect opens the data file with O_DIRECT for the writes, unless the
+// pages can't be aligned or the platform or the file system doesn't support
+// it, in which case the pages are written through the page cache.
+func (db *DB) openDirect() {
+	lg := db.Logger()
+	if db.pageSize%directIOAlignment != 0 {
+		lg.Warningf("direct I/O disabled, the page size %d isn't a multiple of %d", db.pageSize, directIOAlignment)
+		return
+	}
+	f, err := openDirectFile(db.path)
+	if err != nil {
+		lg.Warningf("direct I/O disabled, opening %s with O_DIRECT failed: %v", db.path, err)
+		return
+	}
+	db.directFile = f
+}
+
+// pageBuffer allocates a buffer for dirty pages, aligned for the writes made
+// with O_DIRECT if Options.DirectIO is in effect.
+func (db *DB) pageBuffer(size int) []byte {
+	if db.directFile == nil {
+		return make([]byte, size)
+	}
+	buf := make([]byte, size+directIOAlignment)
+	off := 0
+	if rem := int(uintptr(unsafe.Pointer(&buf[0])) % directIOAlignment); rem != 0 {
+		off = directIOAlignment - rem
+	}
+	return buf[off : off+size : off+size]
+}
+
+// writeDirect writes an extent through the file opened with O_DIRECT. It
+// returns false if the extent must be written through the page cache
+// instead, because O_DIRECT isn't in effect or the extent isn't aligned.
+func (db *DB) writeDirect(e extent) (int, bool, error) {
+	if db.directFile == nil || !e.aligned() || !db.writesToFile() {
+		return 0, false, nil
+	}
+	calls, err := writeDirectFile(db.directFile, e.bufs, e.offset)
+	if errors.Is(err, syscall.EINVAL) {
+		// The file system rejected the alignment of the write.
+		db.Logger().Warningf("direct write failed, offset: %d, size: %d, falling back to a buffered write: %v", e.offset, e.size, err)
+		return calls, false, nil
+	}
+	return calls, true, err
+}
+
+// aligned returns true if the extent can be written with O_DIRECT.
+func (e *extent) aligned() bool {
+	if e.offset%directIOAlignment != 0 {
+		return false
+	}
+	for _, b := range e.bufs {
+		if len(b)%directIOAlignment != 0 || uintptr(unsafe.Pointer(&b[0]))%directIOAlignment != 0 {
+			return false
+		}
+	}
+	return true
+}
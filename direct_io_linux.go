@@ -0,0 +1,18 @@
+package bbolt
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// openDirectFile opens the data file for writing with O_DIRECT.
+func openDirectFile(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_WRONLY|unix.O_DIRECT, 0)
+}
+
+// writeDirectFile writes the buffers to the file opened with O_DIRECT at the
+// given offset. It returns the number of syscalls made.
+func writeDirectFile(f *os.File, bufs [][]byte, offset int64) (int, error) {
+	return pwritevFd(int(f.Fd()), bufs, offset)
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+// +build !linux
+
+package bbolt
+
+import "os"
+
+// openDirectFile isn't supported on this platform.
+func openDirectFile(_ string) (*os.File, error) {
+	return nil, errDirectIOUnsupported
+}
+
+// writeDirectFile isn't supported on this platform.
+func writeDirectFile(_ *os.File, _ [][]byte, _ int64) (int, error) {
+	return 0, errDirectIOUnsupported
+}
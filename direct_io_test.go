@@ -0,0 +1,59 @@
+package bbolt_test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "go.etcd.io/bbolt"
+	"go.etcd.io/bbolt/internal/btesting"
+)
+
+func TestOptions_DirectIO(t *testing.T) {
+	for name, pageSize := range map[string]int{
+		"aligned page size": 4096,
+		// The pages can't be aligned, they're written through the page cache.
+		"unaligned page size": 1024,
+	} {
+		pageSize := pageSize
+		t.Run(name, func(t *testing.T) {
+			db := btesting.MustCreateDBWithOption(t, &bolt.Options{DirectIO: true, PageSize: pageSize})
+			value := func(k int) []byte {
+				if k%100 == 0 {
+					return bytes.Repeat([]byte{byte(k)}, 3*pageSize+10)
+				}
+				return []byte(fmt.Sprintf("value-%04d", k))
+			}
+			require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+				b, err := tx.CreateBucket([]byte("widgets"))
+				if err != nil {
+					return err
+				}
+				for k := 0; k < 1000; k++ {
+					if err := b.Put([]byte(fmt.Sprintf("%04d", k)), value(k)); err != nil {
+						return err
+					}
+				}
+				return nil
+			}))
+
+			verify := func() {
+				require.NoError(t, db.View(func(tx *bolt.Tx) error {
+					b := tx.Bucket([]byte("widgets"))
+					for k := 0; k < 1000; k++ {
+						require.Equal(t, value(k), b.Get([]byte(fmt.Sprintf("%04d", k))))
+					}
+					return nil
+				}))
+			}
+			verify()
+			db.MustCheck()
+
+			db.MustClose()
+			db.MustReopen()
+			verify()
+		})
+	}
+}
@@ -2,7 +2,10 @@
 // during bbolt operations.
 package errors
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 // These errors can be returned when opening or calling methods on a DB.
 var (
@@ -27,6 +30,14 @@ var (
 	// ErrTimeout is returned when a database cannot obtain an exclusive lock
 	// on the data file after the timeout passed to Open().
 	ErrTimeout = errors.New("timeout")
+
+	// ErrNoSpace is returned when the database file cannot grow because
+	// there is no space left on the device.
+	ErrNoSpace = errors.New("no space left on device")
+
+	// ErrScrubberRunning is returned by DB.StartScrubber when the scrubber
+	// of the DB is already running.
+	ErrScrubberRunning = errors.New("scrubber already running")
 )
 
 // These errors can occur when beginning or committing a Tx.
@@ -46,6 +57,10 @@ var (
 	// ErrFreePagesNotLoaded is returned when a readonly transaction without
 	// preloading the free pages is trying to access the free pages.
 	ErrFreePagesNotLoaded = errors.New("free pages are not pre-loaded")
+
+	// ErrBatchDeadline is returned by DB.BatchWithOptions when the batch
+	// couldn't start before the deadline of the call.
+	ErrBatchDeadline = errors.New("batch deadline exceeded")
 )
 
 // These errors can occur when putting or deleting a value or a bucket.
@@ -82,3 +97,117 @@ var (
 	// source and target buckets, while source and target buckets are in different database files.
 	ErrDifferentDB = errors.New("the source and target buckets are in different database files")
 )
+
+// ErrSkipKey is returned by CompactOptions.Transform to drop a key/value
+// from the compacted database.
+var ErrSkipKey = errors.New("skip this key")
+
+// ErrIO is matched by the IOError returned when a page of the database file
+// can't be read.
+var ErrIO = errors.New("i/o error")
+
+// IOError is returned when reading a page of the database file fails, with
+// Options.NoMmap or Options.GuardedReads.
+type IOError struct {
+	Pgid uint64 // id of the page which couldn't be read
+	Err  error  // underlying error
+}
+
+func (e *IOError) Error() string {
+	return fmt.Sprintf("i/o error reading page %d: %v", e.Pgid, e.Err)
+}
+
+func (e *IOError) Unwrap() error {
+	return e.Err
+}
+
+// Is returns true for ErrIO.
+func (e *IOError) Is(target error) bool {
+	return target == ErrIO
+}
+
+// These errors are matched by the CheckError reported by Tx.Check for each
+// kind of inconsistency.
+var (
+	// ErrDoubleFree is matched when a page is in the freelist more than once.
+	ErrDoubleFree = errors.New("page freed more than once")
+
+	// ErrReachableFreed is matched when a page is in the freelist, but it's
+	// still referenced by a bucket.
+	ErrReachableFreed = errors.New("reachable page freed")
+
+	// ErrUnreachableUnfreed is matched when a page is neither referenced by
+	// a bucket nor in the freelist.
+	ErrUnreachableUnfreed = errors.New("unreachable page not freed")
+
+	// ErrKeyOrder is matched when the keys of a page aren't sorted, or
+	// aren't within the range given by the parent page.
+	ErrKeyOrder = errors.New("keys out of order")
+
+	// ErrPageTypeMismatch is matched when a page of a bucket is neither a
+	// branch nor a leaf page.
+	ErrPageTypeMismatch = errors.New("unexpected page type")
+
+	// ErrOutOfBounds is matched when a page is past the high water mark.
+	ErrOutOfBounds = errors.New("page out of bounds")
+
+	// ErrMultipleReferences is matched when a page is referenced more than
+	// once.
+	ErrMultipleReferences = errors.New("page referenced multiple times")
+)
+
+// CheckSeverity tells how serious an inconsistency found by Tx.Check is.
+type CheckSeverity int
+
+const (
+	// SeverityWarning is an inconsistency which only wastes space, e.g.
+	// pages which were never freed. Rebuilding the freelist reclaims it.
+	SeverityWarning CheckSeverity = iota + 1
+
+	// SeverityError is an inconsistency of the freelist which corrupts the
+	// data once a freed page is reused. Rebuilding the freelist fixes it.
+	SeverityError
+
+	// SeverityCritical is an inconsistency of the B+trees of the buckets,
+	// which means that data is lost or can't be read reliably. The
+	// database should be restored from a backup.
+	SeverityCritical
+)
+
+func (s CheckSeverity) String() string {
+	switch s {
+	case SeverityWarning:
+		return "warning"
+	case SeverityError:
+		return "error"
+	case SeverityCritical:
+		return "critical"
+	}
+	return fmt.Sprintf("CheckSeverity(%d)", int(s))
+}
+
+// MarshalText encodes the severity as its name.
+func (s CheckSeverity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// CheckError is an inconsistency found by Tx.Check. It matches its Kind, one
+// of ErrDoubleFree, ErrReachableFreed, ErrUnreachableUnfreed, ErrKeyOrder,
+// ErrPageTypeMismatch, ErrOutOfBounds or ErrMultipleReferences, with
+// errors.Is.
+type CheckError struct {
+	Kind     error         // kind of inconsistency
+	Severity CheckSeverity // how serious the inconsistency is
+	Pgid     uint64        // id of the inconsistent page
+	Stack    []uint64      // ids of the pages from the root of the bucket to the page, if known
+	Bucket   [][]byte      // path of the bucket holding the page, if known
+	Message  string        // description of the inconsistency
+}
+
+func (e *CheckError) Error() string {
+	return e.Message
+}
+
+func (e *CheckError) Unwrap() error {
+	return e.Kind
+}
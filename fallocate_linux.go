@@ -0,0 +1,23 @@
+package bbolt
+
+import (
+	"errors"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// fallocate allocates the disk blocks for the given range of the database
+// file. When keepSize is set the file size isn't changed, which reserves the
+// blocks past the end of the file.
+func fallocate(db *DB, offset, length int64, keepSize bool) error {
+	var mode uint32
+	if keepSize {
+		mode = unix.FALLOC_FL_KEEP_SIZE
+	}
+	err := unix.Fallocate(int(db.file.Fd()), mode, offset, length)
+	if errors.Is(err, syscall.EOPNOTSUPP) || errors.Is(err, syscall.ENOSYS) {
+		return errFallocateUnsupported
+	}
+	return err
+}
@@ -0,0 +1,36 @@
+package bbolt_test
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "go.etcd.io/bbolt"
+	"go.etcd.io/bbolt/internal/btesting"
+)
+
+func TestOpen_GrowthPolicy_Preallocate(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{
+		GrowthPolicy: bolt.GrowthPolicy{Preallocate: true, ReserveSize: 1024 * 1024},
+	})
+	db.AllocSize = 1024 * 1024
+
+	err := db.Fill([]byte("data"), 4, 100,
+		func(tx int, k int) []byte { return []byte(fmt.Sprintf("%04d%04d", tx, k)) },
+		func(tx int, k int) []byte { return make([]byte, 1000) },
+	)
+	require.NoError(t, err)
+
+	fi, err := os.Stat(db.Path())
+	require.NoError(t, err)
+	st := fi.Sys().(*syscall.Stat_t)
+	if st.Blocks*512 < fi.Size() {
+		// The file system doesn't support fallocate, so the file is sparse.
+		t.Skipf("fallocate not supported, size: %d, allocated: %d", fi.Size(), st.Blocks*512)
+	}
+	// The reservation past the end of the file is allocated too.
+	require.GreaterOrEqual(t, st.Blocks*512, fi.Size()+1024*1024)
+}
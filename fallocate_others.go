@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package bbolt
+
+// fallocate isn't supported on this platform.
+func fallocate(_ *DB, _, _ int64, _ bool) error {
+	return errFallocateUnsupported
+}
@@ -0,0 +1,240 @@
+package bbolt
+
+import (
+	"runtime"
+	"sync"
+	"time"
+
+	berrors "go.etcd.io/bbolt/errors"
+	"go.etcd.io/bbolt/internal/common"
+)
+
+// groupCommit shares the syncing of the file between concurrent writers
+// when Options.GroupCommit is set, and defers it with DurabilityPeriodic
+// and DurabilityNone.
+//
+// A committing writer writes its dirty pages without syncing them and
+// publishes its meta in memory, so that the next writer can start on top of
+// it right away. It then waits until a leader, which is the first waiting
+// committer, syncs the data pages of all the published transactions, writes
+// the latest meta page and syncs it again. The meta page is always written
+// over the older of the two meta pages, and the pages of the last durable
+// transaction, and of the transaction a leader is making durable, are never
+// reused until a newer meta page is durable, so that a crash at any point
+// leaves a consistent database behind.
+type groupCommit struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	meta    *common.Meta // latest published meta
+	durable common.Txid  // txid of the last durable meta page
+	syncing bool         // true while a leader syncs the file
+	leading common.Txid  // txid of the meta page written by the leader
+	err     error        // sticky error of a failed sync
+}
+
+func newGroupCommit(durable common.Txid) *groupCommit {
+	g := &groupCommit{durable: durable}
+	g.cond = sync.NewCond(&g.mu)
+	return g
+}
+
+// publish makes the meta of a committed transaction visible to the next
+// writer. The meta page is written to disk by the next leader.
+func (g *groupCommit) publish(m *common.Meta) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.meta = &common.Meta{}
+	m.Copy(g.meta)
+}
+
+// pendingMeta returns the latest published meta which isn't durable yet,
+// or nil if there is none.
+func (g *groupCommit) pendingMeta() *common.Meta {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.meta == nil || g.meta.Txid() <= g.durable {
+		return nil
+	}
+	return g.meta
+}
+
+// durableTxid returns the txid of the last durable meta page.
+func (g *groupCommit) durableTxid() common.Txid {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.durable
+}
+
+// pinnedTxids returns the txids whose pages the next writer must not reuse:
+// the last durable one, and the one a leader is making durable, if any.
+func (g *groupCommit) pinnedTxids() []common.Txid {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	txids := []common.Txid{g.durable}
+	if g.syncing && g.leading > g.durable {
+		txids = append(txids, g.leading)
+	}
+	return txids
+}
+
+// failed returns the error of a previous failed sync, if any.
+func (g *groupCommit) failed() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.err
+}
+
+// wait blocks until the meta page of the given transaction is durable. The
+// caller syncs the file itself if no other committer is doing so.
+func (g *groupCommit) wait(db *DB, txid common.Txid) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for g.durable < txid {
+		if g.err != nil {
+			return g.err
+		}
+		if g.syncing {
+			g.cond.Wait()
+			continue
+		}
+
+		// Become the leader and sync all the published transactions.
+		g.syncing = true
+		m := &common.Meta{}
+		g.meta.Copy(m)
+		g.leading = m.Txid()
+		g.mu.Unlock()
+		err := db.syncGroupMeta(m)
+		g.mu.Lock()
+		g.syncing = false
+		if err != nil {
+			g.err = err
+		} else {
+			g.durable = m.Txid()
+		}
+		g.cond.Broadcast()
+	}
+	return nil
+}
+
+// flush makes all the published transactions durable.
+func (g *groupCommit) flush(db *DB) error {
+	m := g.pendingMeta()
+	if m == nil {
+		return g.failed()
+	}
+	return g.wait(db, m.Txid())
+}
+
+// reset forgets the published transactions once the file was replaced by
+// DB.CompactInPlace, whose durable meta page has the given txid.
+func (g *groupCommit) reset(durable common.Txid) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.meta = nil
+	g.durable = durable
+}
+
+// Flush syncs all the transactions committed with DurabilityPeriodic or
+// DurabilityNone to disk. It returns once they are durable. It's a no-op
+// with DurabilitySync.
+func (db *DB) Flush() error {
+	if db.group == nil {
+		return nil
+	}
+	return db.group.flush(db)
+}
+
+// startFlusher starts a goroutine which syncs the committed transactions
+// at the given interval. A failed sync is sticky: the next writable
+// transactions fail with its error, and it's only logged once here.
+func (db *DB) startFlusher(interval time.Duration) {
+	db.flushStop = make(chan struct{})
+	db.flushWg.Add(1)
+	go func() {
+		defer db.flushWg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		var logged bool
+		for {
+			select {
+			case <-db.flushStop:
+				return
+			case <-ticker.C:
+				if err := db.group.flush(db); err != nil && !logged {
+					db.Logger().Errorf("periodic flush failed: %v", err)
+					logged = true
+				}
+			}
+		}
+	}()
+}
+
+// stopFlusher stops the goroutine started by startFlusher, if any.
+func (db *DB) stopFlusher() {
+	if db.flushStop == nil {
+		return
+	}
+	close(db.flushStop)
+	db.flushWg.Wait()
+	db.flushStop = nil
+}
+
+// syncGroupMeta syncs the data pages written by the published transactions,
+// then writes the given meta page and syncs it.
+func (db *DB) syncGroupMeta(m *common.Meta) error {
+	// Hold the mmap lock so that the file isn't closed underneath.
+	db.mmaplock.RLock()
+	defer db.mmaplock.RUnlock()
+	db.genlock.RLock()
+	mapped := db.mapped()
+	// Never overwrite the meta page of the last durable transaction.
+	overwrite0 := mapped && db.meta() == db.meta1
+	db.genlock.RUnlock()
+	if !mapped {
+		return berrors.ErrDatabaseNotOpen
+	}
+
+	lg := db.Logger()
+	if err := db.syncGroup(); err != nil {
+		return err
+	}
+
+	buf := make([]byte, db.pageSize)
+	p := db.pageInBuffer(buf, 0)
+	m.Write(p)
+
+	if overwrite0 {
+		p.SetId(0)
+	} else {
+		p.SetId(1)
+	}
+	if _, err := db.ops.writeAt(buf, int64(p.Id())*int64(db.pageSize)); err != nil {
+		lg.Errorf("writeAt failed, pgid: %d, pageSize: %d, error: %v", p.Id(), db.pageSize, err)
+		return err
+	}
+	if db.pcache != nil {
+		db.pcache.metaWritten(p)
+	}
+	db.statlock.Lock()
+	db.stats.TxStats.IncWrite(1)
+	db.statlock.Unlock()
+
+	return db.syncGroup()
+}
+
+// syncGroup syncs the file unless DB.NoSync is set.
+func (db *DB) syncGroup() error {
+	if db.NoSync && !common.IgnoreNoSync {
+		return nil
+	}
+	if err := fdatasync(db); err != nil {
+		db.Logger().Errorf("[GOOS: %s, GOARCH: %s] fdatasync failed: %v", runtime.GOOS, runtime.GOARCH, err)
+		return err
+	}
+	db.statlock.Lock()
+	db.stats.TxStats.IncSync(1)
+	db.statlock.Unlock()
+	return nil
+}
@@ -0,0 +1,159 @@
+package bbolt_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "go.etcd.io/bbolt"
+	"go.etcd.io/bbolt/internal/btesting"
+)
+
+func TestOptions_GroupCommit(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{GroupCommit: true})
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("widgets"))
+		return err
+	}))
+
+	const writers = 100
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := db.Update(func(tx *bolt.Tx) error {
+				return tx.Bucket([]byte("widgets")).Put([]byte(fmt.Sprintf("%04d", i)), []byte("value"))
+			})
+			require.NoError(t, err)
+		}(i)
+	}
+	wg.Wait()
+
+	// A rolled back transaction doesn't lose the committed ones.
+	tx, err := db.Begin(true)
+	require.NoError(t, err)
+	require.NoError(t, tx.Bucket([]byte("widgets")).Put([]byte("rollback"), []byte("value")))
+	require.NoError(t, tx.Rollback())
+	require.Error(t, db.Update(func(tx *bolt.Tx) error {
+		require.NoError(t, tx.Bucket([]byte("widgets")).Put([]byte("rollback"), []byte("value")))
+		return errors.New("rollback")
+	}))
+
+	db.MustCheck()
+	db.MustClose()
+	db.MustReopen()
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		require.Equal(t, writers, b.Stats().KeyN)
+		require.Nil(t, b.Get([]byte("rollback")))
+		return nil
+	}))
+}
+
+// Ensure that a transaction committed in group commit mode is on disk once
+// Commit returns.
+func TestOptions_GroupCommit_Durable(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{GroupCommit: true})
+	for i := 0; i < 10; i++ {
+		require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists([]byte("widgets"))
+			if err != nil {
+				return err
+			}
+			return b.Put([]byte(fmt.Sprintf("%04d", i)), []byte("value"))
+		}))
+
+		require.Equal(t, i+1, durableKeyN(t, db.Path()))
+	}
+}
+
+func TestOptions_DurabilityMode(t *testing.T) {
+	t.Run("none", func(t *testing.T) {
+		db := btesting.MustCreateDBWithOption(t, &bolt.Options{DurabilityMode: bolt.DurabilityNone})
+		require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+			_, err := tx.CreateBucket([]byte("widgets"))
+			return err
+		}))
+		require.NoError(t, db.Flush())
+
+		for round := 1; round <= 3; round++ {
+			for i := 0; i < 10; i++ {
+				require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+					return tx.Bucket([]byte("widgets")).Put([]byte(fmt.Sprintf("%02d%04d", round, i)), make([]byte, 1000))
+				}))
+			}
+
+			// The committed transactions are visible, but not on disk yet.
+			require.NoError(t, db.View(func(tx *bolt.Tx) error {
+				require.Equal(t, round*10, tx.Bucket([]byte("widgets")).Stats().KeyN)
+				return nil
+			}))
+			require.Equal(t, (round-1)*10, durableKeyN(t, db.Path()))
+
+			require.NoError(t, db.Flush())
+			require.Equal(t, round*10, durableKeyN(t, db.Path()))
+		}
+		db.MustCheck()
+
+		// A failed transaction doesn't sync the file either.
+		before := db.Stats()
+		require.Error(t, db.Update(func(tx *bolt.Tx) error {
+			if err := tx.Bucket([]byte("widgets")).Put([]byte("foo"), []byte("bar")); err != nil {
+				return err
+			}
+			return errors.New("failed")
+		}))
+		after := db.Stats()
+		require.Equal(t, before.TxStats.GetSync(), after.TxStats.GetSync())
+	})
+
+	t.Run("periodic", func(t *testing.T) {
+		db := btesting.MustCreateDBWithOption(t, &bolt.Options{
+			DurabilityMode: bolt.DurabilityPeriodic,
+			FlushInterval:  10 * time.Millisecond,
+		})
+		require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucket([]byte("widgets"))
+			if err != nil {
+				return err
+			}
+			return b.Put([]byte("foo"), []byte("bar"))
+		}))
+		require.Eventually(t, func() bool {
+			return durableKeyN(t, db.Path()) == 1
+		}, 10*time.Second, 10*time.Millisecond)
+	})
+}
+
+// durableKeyN opens a copy of the database file, which reflects the state
+// after a crash, checks it and returns the number of keys in the widgets
+// bucket.
+func durableKeyN(t *testing.T, path string) int {
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	cpath := filepath.Join(t.TempDir(), "copy.db")
+	require.NoError(t, os.WriteFile(cpath, data, 0600))
+
+	cdb, err := bolt.Open(cpath, 0600, &bolt.Options{ReadOnly: true})
+	require.NoError(t, err)
+	defer cdb.Close()
+
+	var n int
+	require.NoError(t, cdb.View(func(tx *bolt.Tx) error {
+		for err := range tx.Check() {
+			return err
+		}
+		if b := tx.Bucket([]byte("widgets")); b != nil {
+			n = b.Stats().KeyN
+		}
+		return nil
+	}))
+	return n
+}
@@ -0,0 +1,177 @@
+package bbolt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Ensure that the transactions committed while a leader syncs the file
+// share a single sync of the data and of the meta page.
+func TestGroupCommit_SharedSync(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "db"), 0600, &Options{GroupCommit: true})
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		_, err := tx.CreateBucket([]byte("widgets"))
+		return err
+	}))
+	durable := db.group.durableTxid()
+
+	// Pretend that a leader is syncing the file, so that the committers queue up.
+	db.group.mu.Lock()
+	db.group.syncing = true
+	db.group.mu.Unlock()
+
+	const writers = 10
+	before := db.Stats()
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := db.Update(func(tx *Tx) error {
+				return tx.Bucket([]byte("widgets")).Put([]byte(fmt.Sprintf("%04d", i)), []byte("value"))
+			})
+			require.NoError(t, err)
+		}(i)
+	}
+
+	// All the transactions are committed before any of them is durable.
+	require.Eventually(t, func() bool {
+		m := db.group.pendingMeta()
+		return m != nil && m.Txid() == durable+writers
+	}, 10*time.Second, time.Millisecond)
+	require.Equal(t, durable, db.meta().Txid())
+
+	db.group.mu.Lock()
+	db.group.syncing = false
+	db.group.cond.Broadcast()
+	db.group.mu.Unlock()
+	wg.Wait()
+
+	after := db.Stats()
+	stats := after.TxStats.Sub(&before.TxStats)
+	require.Equal(t, int64(2), stats.GetSync())
+	require.Equal(t, durable+writers, db.meta().Txid())
+	require.Equal(t, durable+writers, db.group.durableTxid())
+}
+
+// Ensure that the pages of the transaction a leader is making durable aren't
+// reused by the next writers while it syncs the file.
+func TestGroupCommit_SlowLeader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	db, err := Open(path, 0600, &Options{DurabilityMode: DurabilityNone})
+	require.NoError(t, err)
+	defer db.Close()
+
+	put := func(name string, n int, value byte) {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			b, err := tx.CreateBucketIfNotExists([]byte(name))
+			if err != nil {
+				return err
+			}
+			for i := 0; i < n; i++ {
+				if err := b.Put([]byte(fmt.Sprintf("%04d", i)), bytes.Repeat([]byte{value}, 1000)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+	}
+	// Free pages, so that the next transactions allocate from the freelist.
+	put("widgets", 3, 'a')
+	put("gadgets", 100, 'a')
+	require.NoError(t, db.Flush())
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		return tx.DeleteBucket([]byte("gadgets"))
+	}))
+	require.NoError(t, db.Flush())
+	put("widgets", 3, 'a')
+	require.NoError(t, db.Flush())
+	put("widgets", 3, 'b')
+
+	// Slow the leader down when it writes the meta page.
+	writing := make(chan struct{})
+	resume := make(chan struct{})
+	var once sync.Once
+	writeAt := db.ops.writeAt
+	db.ops.writeAt = func(b []byte, off int64) (int, error) {
+		if off < 2*int64(db.pageSize) {
+			once.Do(func() {
+				close(writing)
+				<-resume
+			})
+		}
+		return writeAt(b, off)
+	}
+	db.ops.writeAtHooked = true
+	flushed := make(chan error)
+	go func() {
+		flushed <- db.Flush()
+	}()
+	<-writing
+
+	// The next writers free the pages of the transaction being made
+	// durable, and allocate pages.
+	put("widgets", 3, 'c')
+	put("gadgets", 100, 'd')
+	close(resume)
+	require.NoError(t, <-flushed)
+
+	// The file is consistent after a crash, with the flushed transaction.
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	cpath := filepath.Join(t.TempDir(), "copy.db")
+	require.NoError(t, os.WriteFile(cpath, data, 0600))
+	cdb, err := Open(cpath, 0600, &Options{ReadOnly: true})
+	require.NoError(t, err)
+	defer cdb.Close()
+	require.NoError(t, cdb.View(func(tx *Tx) error {
+		for err := range tx.Check() {
+			return err
+		}
+		require.Nil(t, tx.Bucket([]byte("gadgets")))
+		v := tx.Bucket([]byte("widgets")).Get([]byte("0000"))
+		require.Equal(t, bytes.Repeat([]byte{'b'}, 1000), v)
+		return nil
+	}))
+}
+
+// Ensure that a failed sync makes the next writable transactions fail.
+func TestGroupCommit_SyncError(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "db"), 0600, &Options{DurabilityMode: DurabilityNone})
+	require.NoError(t, err)
+	defer func() {
+		require.Error(t, db.Close())
+	}()
+
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		_, err := tx.CreateBucket([]byte("widgets"))
+		return err
+	}))
+	errWrite := errors.New("write failed")
+	db.ops.writeAt = func(b []byte, off int64) (int, error) {
+		return 0, errWrite
+	}
+	db.ops.writeAtHooked = true
+	require.ErrorIs(t, db.Flush(), errWrite)
+
+	_, err = db.Begin(true)
+	require.ErrorIs(t, err, errWrite)
+	require.ErrorIs(t, db.Update(func(tx *Tx) error { return nil }), errWrite)
+
+	// The committed transactions can still be read.
+	require.NoError(t, db.View(func(tx *Tx) error {
+		require.NotNil(t, tx.Bucket([]byte("widgets")))
+		return nil
+	}))
+}
@@ -0,0 +1,113 @@
+package bbolt
+
+import (
+	"runtime/debug"
+	"unsafe"
+
+	berrors "go.etcd.io/bbolt/errors"
+	"go.etcd.io/bbolt/internal/common"
+)
+
+// With Options.GuardedReads, the page accesses which may fault are made
+// with debug.SetPanicOnFault, so that an I/O error reading the mmap panics
+// instead of crashing the process with SIGBUS. The fault is turned into an
+// IOError, which is raised again as a panic since the page accessors can't
+// return errors, and returned by the managed transactions and Tx.Check.
+// The mmap can't be trusted anymore, so the DB fails every following
+// transaction with the same error.
+
+// recoverFault restores the previous debug.SetPanicOnFault setting, and
+// turns a fault reading the mmap into an IOError panic.
+func (db *DB) recoverFault(panicOnFault bool) {
+	debug.SetPanicOnFault(panicOnFault)
+	if p := recover(); p != nil {
+		panic(db.faultError(p))
+	}
+}
+
+// recoverIOError turns an IOError panic, or a fault reading the mmap, into
+// the returned error.
+func (db *DB) recoverIOError(err *error, panicOnFault bool) {
+	if db.guarded {
+		debug.SetPanicOnFault(panicOnFault)
+	}
+	if ioErr := ioErrorFrom(db.faultError(recover())); ioErr != nil {
+		*err = ioErr
+	}
+}
+
+// guardFaults makes the faults of the calling goroutine panic if reads are
+// guarded, and returns the previous setting.
+func (db *DB) guardFaults() bool {
+	if !db.guarded {
+		return false
+	}
+	return debug.SetPanicOnFault(true)
+}
+
+// catchesIOErrors returns true if reading a page may raise an IOError panic.
+func (db *DB) catchesIOErrors() bool {
+	return db.pcache != nil || db.guarded
+}
+
+// faultError returns the IOError of a panic caused by a fault reading the
+// mmap, and marks the DB as failed. It returns any other panic unchanged.
+func (db *DB) faultError(p interface{}) interface{} {
+	fault, ok := p.(interface {
+		error
+		Addr() uintptr
+	})
+	if !ok {
+		return p
+	}
+	id, ok := db.pgidAt(fault.Addr())
+	if !ok {
+		return p
+	}
+
+	ioErr := &berrors.IOError{Pgid: uint64(id), Err: fault}
+	if db.ioErr.CompareAndSwap(nil, ioErr) {
+		db.Logger().Errorf("reading page %d failed, failing all the following transactions: %v", ioErr.Pgid, fault)
+	}
+	return ioErr
+}
+
+// pgidAt returns the id of the page at the given address in the current
+// mapping or in a retired one, if any.
+func (db *DB) pgidAt(addr uintptr) (common.Pgid, bool) {
+	db.genlock.RLock()
+	defer db.genlock.RUnlock()
+	gens := db.retired
+	if db.gen != nil {
+		gens = append([]*mmapGen{db.gen}, gens...)
+	}
+	for _, g := range gens {
+		if g.contains(addr) {
+			return common.Pgid((addr - uintptr(unsafe.Pointer(&g.data[0]))) / uintptr(db.pageSize)), true
+		}
+	}
+	return 0, false
+}
+
+// ioErrorFrom returns the IOError of a recovered panic, if any, and raises
+// any other panic again.
+func ioErrorFrom(p interface{}) error {
+	if p == nil {
+		return nil
+	}
+	if err, ok := p.(*berrors.IOError); ok {
+		return err
+	}
+	panic(p)
+}
+
+// failed returns the error which failed the DB, if any.
+func (db *DB) failed() error {
+	if err := db.ioErr.Load(); err != nil {
+		return err
+	}
+	if err, _ := db.swapErr.Load().(error); err != nil {
+		return err
+	}
+	return nil
+}
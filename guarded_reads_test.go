@@ -0,0 +1,88 @@
+//go:build !windows
+// +build !windows
+
+package bbolt_test
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "go.etcd.io/bbolt"
+	berrors "go.etcd.io/bbolt/errors"
+	"go.etcd.io/bbolt/internal/btesting"
+)
+
+func TestOptions_GuardedReads(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	fillWidgets(t, db)
+	db.MustClose()
+
+	gdb, err := bolt.Open(db.Path(), 0600, &bolt.Options{GuardedReads: true, ReadOnly: true})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, gdb.Close()) }()
+
+	tx, err := gdb.Begin(false)
+	require.NoError(t, err)
+	c := tx.Bucket([]byte("widgets")).Cursor()
+
+	// Reading the mmap past the end of the file raises SIGBUS, as if the
+	// disk failed.
+	require.NoError(t, os.Truncate(db.Path(), 4*int64(gdb.Info().PageSize)))
+
+	// An unmanaged transaction panics with the error.
+	func() {
+		defer func() {
+			ioErr, ok := recover().(*berrors.IOError)
+			require.True(t, ok)
+			require.GreaterOrEqual(t, ioErr.Pgid, uint64(4))
+		}()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		}
+	}()
+	require.NoError(t, tx.Rollback())
+
+	// The DB is failed.
+	err = gdb.View(func(tx *bolt.Tx) error {
+		t.Fatal("transaction began on a failed DB")
+		return nil
+	})
+	require.ErrorIs(t, err, berrors.ErrIO)
+	var ioErr *berrors.IOError
+	require.True(t, errors.As(err, &ioErr))
+	require.GreaterOrEqual(t, ioErr.Pgid, uint64(4))
+}
+
+func TestOptions_GuardedReads_View(t *testing.T) {
+	for _, check := range []bool{false, true} {
+		check := check
+		t.Run(map[bool]string{false: "ForEach", true: "Check"}[check], func(t *testing.T) {
+			db := btesting.MustCreateDB(t)
+			fillWidgets(t, db)
+			db.MustClose()
+
+			gdb, err := bolt.Open(db.Path(), 0600, &bolt.Options{GuardedReads: true, ReadOnly: true})
+			require.NoError(t, err)
+			defer func() { require.NoError(t, gdb.Close()) }()
+			require.NoError(t, os.Truncate(db.Path(), 4*int64(gdb.Info().PageSize)))
+
+			err = gdb.View(func(tx *bolt.Tx) error {
+				if check {
+					var errs []error
+					for err := range tx.Check() {
+						errs = append(errs, err)
+					}
+					require.NotEmpty(t, errs)
+					return errs[len(errs)-1]
+				}
+				return tx.Bucket([]byte("widgets")).ForEach(func(k, v []byte) error {
+					return nil
+				})
+			})
+			require.ErrorIs(t, err, berrors.ErrIO)
+			require.ErrorIs(t, gdb.View(func(tx *bolt.Tx) error { return nil }), berrors.ErrIO)
+		})
+	}
+}
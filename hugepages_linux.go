@@ -0,0 +1,19 @@
+package bbolt
+
+import (
+	"errors"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// madviseHugePages asks the kernel to back the mapped memory b with
+// transparent huge pages. It's ignored if the kernel doesn't support them
+// for the file.
+func madviseHugePages(b []byte) error {
+	err := unix.Madvise(b, unix.MADV_HUGEPAGE)
+	if errors.Is(err, syscall.EINVAL) || errors.Is(err, syscall.ENOSYS) {
+		return nil
+	}
+	return err
+}
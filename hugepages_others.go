@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package bbolt
+
+// Transparent huge pages are only supported on Linux.
+func madviseHugePages(_ []byte) error {
+	return nil
+}
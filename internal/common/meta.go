@@ -19,6 +19,15 @@ type Meta struct {
 	pgid     Pgid
 	txid     Txid
 	checksum uint64
+
+	// commitRecord and commitSum are only set by the transactions committed
+	// with a single fdatasync(). They are placed after checksum so that they
+	// don't change the checksum of the meta pages written by older versions,
+	// and have their own checksum, commitRecordSum, which is zero in the
+	// meta pages written by older versions.
+	commitRecord    Pgid
+	commitSum       uint64
+	commitRecordSum uint64
 }
 
 // Validate checks the marker bytes and version of the meta page to ensure it matches this binary.
@@ -33,6 +42,17 @@ func (m *Meta) Validate() error {
 	return nil
 }
 
+// ValidateCommitRecord checks the checksum of the commit record fields.
+func (m *Meta) ValidateCommitRecord() error {
+	if m.commitRecord == 0 && m.commitSum == 0 && m.commitRecordSum == 0 {
+		// Written by an older version.
+		return nil
+	} else if m.commitRecordSum != m.CommitRecordSum64() {
+		return errors.ErrChecksum
+	}
+	return nil
+}
+
 // Copy copies one meta object to another.
 func (m *Meta) Copy(dest *Meta) {
 	*dest = *m
@@ -51,8 +71,9 @@ func (m *Meta) Write(p *Page) {
 	p.id = Pgid(m.txid % 2)
 	p.SetFlags(MetaPageFlag)
 
-	// Calculate the checksum.
+	// Calculate the checksums.
 	m.checksum = m.Sum64()
+	m.commitRecordSum = m.CommitRecordSum64()
 
 	m.Copy(p.Meta())
 }
@@ -64,6 +85,14 @@ func (m *Meta) Sum64() uint64 {
 	return h.Sum64()
 }
 
+// CommitRecordSum64 generates the checksum for the commit record fields.
+func (m *Meta) CommitRecordSum64() uint64 {
+	var h = fnv.New64a()
+	const size = unsafe.Offsetof(Meta{}.commitRecordSum) - unsafe.Offsetof(Meta{}.commitRecord)
+	_, _ = h.Write((*[size]byte)(unsafe.Pointer(&m.commitRecord))[:])
+	return h.Sum64()
+}
+
 func (m *Meta) Magic() uint32 {
 	return m.magic
 }
@@ -148,6 +177,33 @@ func (m *Meta) SetChecksum(v uint64) {
 	m.checksum = v
 }
 
+// CommitRecord returns the page listing the pages written by the
+// transaction, or 0 if the transaction was committed with two fdatasync().
+func (m *Meta) CommitRecord() Pgid {
+	return m.commitRecord
+}
+
+func (m *Meta) SetCommitRecord(id Pgid) {
+	m.commitRecord = id
+}
+
+// CommitSum returns the checksum of the pages written by the transaction.
+func (m *Meta) CommitSum() uint64 {
+	return m.commitSum
+}
+
+func (m *Meta) SetCommitSum(v uint64) {
+	m.commitSum = v
+}
+
+// ClearCommitRecord removes the commit record, once it may no longer match
+// the pages of the transaction.
+func (m *Meta) ClearCommitRecord() {
+	m.commitRecord = 0
+	m.commitSum = 0
+	m.commitRecordSum = 0
+}
+
 func (m *Meta) Print(w io.Writer) {
 	fmt.Fprintf(w, "Version:    %d\n", m.version)
 	fmt.Fprintf(w, "Page Size:  %d bytes\n", m.pageSize)
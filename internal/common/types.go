@@ -31,6 +31,14 @@ const (
 	DefaultMaxBatchSize  int = 1000
 	DefaultMaxBatchDelay     = 10 * time.Millisecond
 	DefaultAllocSize         = 16 * 1024 * 1024
+	DefaultFlushInterval     = 100 * time.Millisecond
+	DefaultPageCacheSize     = 64 * 1024 * 1024
+)
+
+// Default values of the scrubber options.
+const (
+	DefaultScrubInterval   = time.Hour
+	DefaultScrubPagesPerTx = 1024
 )
 
 // DefaultPageSize is the default page size for db which is set to the OS page size.
@@ -4,6 +4,12 @@ import (
 	"go.etcd.io/bbolt/internal/common"
 )
 
+// Span is a contiguous run of free pages.
+type Span struct {
+	Start common.Pgid // first page id of the span
+	Size  int         // number of pages in the span
+}
+
 type ReadWriter interface {
 	// Read calls Init with the page ids stored in the given page.
 	Read(page *common.Page)
@@ -36,6 +42,14 @@ type Interface interface {
 	// PendingCount returns the number of pending pages.
 	PendingCount() int
 
+	// FreeSpans returns all contiguous runs of free pages (pending pages
+	// excluded), ordered by their starting page id.
+	FreeSpans() []Span
+
+	// PendingCountByTxid returns the number of pending pages keyed by the
+	// id of the transaction which freed them.
+	PendingCountByTxid() map[common.Txid]int
+
 	// AddReadonlyTXID adds a given read-only transaction id for pending page tracking.
 	AddReadonlyTXID(txid common.Txid)
 
@@ -45,6 +59,17 @@ type Interface interface {
 	// ReleasePendingPages releases any pages associated with closed read-only transactions.
 	ReleasePendingPages()
 
+	// SetTrackReleased enables or disables recording the ids of the pages
+	// which are moved from pending to free.
+	SetTrackReleased(enabled bool)
+
+	// ReleasedPageIds returns the ids of the pages moved from pending to free
+	// since the last ClearReleased call.
+	ReleasedPageIds() common.Pgids
+
+	// ClearReleased forgets all the recorded released page ids.
+	ClearReleased()
+
 	// Free releases a page and its overflow for a given transaction id.
 	// If the page is already free then a panic will occur.
 	Free(txId common.Txid, p *common.Page)
@@ -50,6 +50,49 @@ func TestFreelist_release(t *testing.T) {
 	}
 }
 
+// Ensure that free spans and pending counts are reported correctly.
+func TestFreelist_FreeSpans(t *testing.T) {
+	f := newTestFreelist()
+	f.Init([]common.Pgid{3, 4, 5, 9, 12, 13})
+	f.Free(100, common.NewPage(20, 0, 0, 1))
+	f.Free(101, common.NewPage(30, 0, 0, 0))
+
+	exp := []Span{{Start: 3, Size: 3}, {Start: 9, Size: 1}, {Start: 12, Size: 2}}
+	if got := f.FreeSpans(); !reflect.DeepEqual(exp, got) {
+		t.Fatalf("exp=%v; got=%v", exp, got)
+	}
+	if exp, got := map[common.Txid]int{100: 2, 101: 1}, f.PendingCountByTxid(); !reflect.DeepEqual(exp, got) {
+		t.Fatalf("exp=%v; got=%v", exp, got)
+	}
+}
+
+// Ensure that the pages moved from pending to free are recorded when tracking is enabled.
+func TestFreelist_trackReleased(t *testing.T) {
+	f := newTestFreelist()
+	f.Free(100, common.NewPage(12, 0, 0, 1))
+	f.release(100)
+	if got := f.ReleasedPageIds(); len(got) != 0 {
+		t.Fatalf("exp=[]; got=%v", got)
+	}
+
+	f.SetTrackReleased(true)
+	f.Free(101, common.NewPage(20, 0, 0, 0))
+	f.Free(102, common.NewPage(30, 0, 0, 0))
+	f.release(101)
+	if exp := common.Pgids([]common.Pgid{20}); !reflect.DeepEqual(exp, f.ReleasedPageIds()) {
+		t.Fatalf("exp=%v; got=%v", exp, f.ReleasedPageIds())
+	}
+	f.ReleasePendingPages()
+	if exp := common.Pgids([]common.Pgid{20, 30}); !reflect.DeepEqual(exp, f.ReleasedPageIds()) {
+		t.Fatalf("exp=%v; got=%v", exp, f.ReleasedPageIds())
+	}
+
+	f.ClearReleased()
+	if got := f.ReleasedPageIds(); len(got) != 0 {
+		t.Fatalf("exp=[]; got=%v", got)
+	}
+}
+
 // Ensure that releaseRange handles boundary conditions correctly
 func TestFreelist_releaseRange(t *testing.T) {
 	type testRange struct {
@@ -22,6 +22,9 @@ type shared struct {
 	allocs        map[common.Pgid]common.Txid // mapping of Txid that allocated a pgid.
 	cache         map[common.Pgid]struct{}    // fast lookup of all free and pending page ids.
 	pending       map[common.Txid]*txPending  // mapping of soon-to-be free page ids by tx.
+
+	trackReleased bool         // whether to record the page ids moved from pending to free.
+	released      common.Pgids // page ids moved from pending to free since the last ClearReleased.
 }
 
 func newShared() *shared {
@@ -44,6 +47,26 @@ func (t *shared) PendingCount() int {
 	return count
 }
 
+func (t *shared) PendingCountByTxid() map[common.Txid]int {
+	m := make(map[common.Txid]int, len(t.pending))
+	for tid, txp := range t.pending {
+		m[tid] = len(txp.ids)
+	}
+	return m
+}
+
+func (t *shared) FreeSpans() []Span {
+	var spans []Span
+	for _, id := range t.freePageIds() {
+		if n := len(spans); n > 0 && spans[n-1].Start+common.Pgid(spans[n-1].Size) == id {
+			spans[n-1].Size++
+			continue
+		}
+		spans = append(spans, Span{Start: id, Size: 1})
+	}
+	return spans
+}
+
 func (t *shared) Count() int {
 	return t.FreeCount() + t.PendingCount()
 }
@@ -147,6 +170,27 @@ func (t *shared) ReleasePendingPages() {
 	// Any page both allocated and freed in an extent is safe to release.
 }
 
+func (t *shared) SetTrackReleased(enabled bool) {
+	t.trackReleased = enabled
+	if !enabled {
+		t.released = nil
+	}
+}
+
+func (t *shared) ReleasedPageIds() common.Pgids {
+	return t.released
+}
+
+func (t *shared) ClearReleased() {
+	t.released = nil
+}
+
+func (t *shared) recordReleased(ids common.Pgids) {
+	if t.trackReleased {
+		t.released = append(t.released, ids...)
+	}
+}
+
 func (t *shared) release(txid common.Txid) {
 	m := make(common.Pgids, 0)
 	for tid, txp := range t.pending {
@@ -157,6 +201,7 @@ func (t *shared) release(txid common.Txid) {
 			delete(t.pending, tid)
 		}
 	}
+	t.recordReleased(m)
 	t.mergeSpans(m)
 }
 
@@ -189,6 +234,7 @@ func (t *shared) releaseRange(begin, end common.Txid) {
 			delete(t.pending, tid)
 		}
 	}
+	t.recordReleased(m)
 	t.mergeSpans(m)
 }
 
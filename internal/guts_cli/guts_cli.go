@@ -32,9 +32,17 @@ func ReadPage(path string, pageID uint64) (*common.Page, []byte, error) {
 	}
 	defer f.Close()
 
+	return ReadPageFrom(f, pageID, pageSize, hwm)
+}
+
+// ReadPageFrom reads Page info & full Page data from r, given the Page size
+// and the HWM. It lets the callers which can't trust the meta pages, or read
+// many pages, provide them.
+// This is not transactionally safe.
+func ReadPageFrom(r io.ReaderAt, pageID uint64, pageSize uint64, hwm common.Pgid) (*common.Page, []byte, error) {
 	// Read one block into buffer.
 	buf := make([]byte, pageSize)
-	if n, err := f.ReadAt(buf, int64(pageID*pageSize)); err != nil {
+	if n, err := r.ReadAt(buf, int64(pageID*pageSize)); err != nil {
 		return nil, nil, err
 	} else if n != len(buf) {
 		return nil, nil, io.ErrUnexpectedEOF
@@ -56,7 +64,7 @@ func ReadPage(path string, pageID uint64) (*common.Page, []byte, error) {
 
 	// Re-read entire Page (with overflow) into buffer.
 	buf = make([]byte, (uint64(overflowN)+1)*pageSize)
-	if n, err := f.ReadAt(buf, int64(pageID*pageSize)); err != nil {
+	if n, err := r.ReadAt(buf, int64(pageID*pageSize)); err != nil {
 		return nil, nil, err
 	} else if n != len(buf) {
 		return nil, nil, io.ErrUnexpectedEOF
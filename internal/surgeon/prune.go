@@ -0,0 +1,187 @@
+package surgeon
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"go.etcd.io/bbolt/internal/common"
+	"go.etcd.io/bbolt/internal/guts_cli"
+)
+
+// ErrNotFound is returned when the page or bucket to prune isn't found.
+var ErrNotFound = errors.New("not found")
+
+// PruneBranchElement removes the element referencing the given child page
+// from a branch page, which drops the subtree rooted at the child page. The
+// pages of the subtree are neither freed nor reused, and the freelist is left
+// untouched.
+func PruneBranchElement(path string, parent, child common.Pgid) error {
+	p, _, err := guts_cli.ReadPage(path, uint64(parent))
+	if err != nil {
+		return err
+	}
+	if !p.IsBranchPage() {
+		return fmt.Errorf("page %d isn't a branch page: %q", parent, p.Typ())
+	}
+	for i := uint16(0); i < p.Count(); i++ {
+		if p.BranchPageElement(i).Pgid() == child {
+			_, err := ClearPageElements(path, parent, int(i), int(i)+1, false)
+			return err
+		}
+	}
+	return fmt.Errorf("page %d on branch page %d: %w", child, parent, ErrNotFound)
+}
+
+// FindBucketEntry returns the leaf page and the index of the element of the
+// bucket at the given path, in the tree of its parent bucket. It descends the
+// trees of the buckets by their keys, without visiting the other pages.
+func FindBucketEntry(path string, bucket [][]byte) (common.Pgid, int, error) {
+	if len(bucket) == 0 {
+		return 0, 0, fmt.Errorf("the root bucket has no entry")
+	}
+	root, _, err := guts_cli.GetRootPage(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	for depth, name := range bucket {
+		leaf, index, err := findKey(path, root, name)
+		if err != nil {
+			return 0, 0, fmt.Errorf("bucket %q: %w", name, err)
+		}
+		if depth == len(bucket)-1 {
+			return leaf, index, nil
+		}
+
+		p, _, err := guts_cli.ReadPage(path, uint64(leaf))
+		if err != nil {
+			return 0, 0, err
+		}
+		elem := p.LeafPageElement(uint16(index))
+		if !elem.IsBucketEntry() {
+			return 0, 0, fmt.Errorf("key %q isn't a bucket", name)
+		}
+		if root = elem.Bucket().RootPage(); root == 0 {
+			return 0, 0, fmt.Errorf("bucket %q is inline: %w", name, ErrNotFound)
+		}
+	}
+	return 0, 0, ErrNotFound
+}
+
+// findKey returns the leaf page and the index of the element with the given
+// key in the tree rooted at the given page.
+func findKey(path string, root common.Pgid, key []byte) (common.Pgid, int, error) {
+	id := root
+	for {
+		p, _, err := guts_cli.ReadPage(path, uint64(id))
+		if err != nil {
+			return 0, 0, err
+		}
+		switch {
+		case p.IsBranchPage():
+			if p.Count() == 0 {
+				return 0, 0, fmt.Errorf("empty branch page %d: %w", id, ErrNotFound)
+			}
+			// Descend into the last child whose first key isn't after the key.
+			next := p.BranchPageElement(0).Pgid()
+			for i := uint16(1); i < p.Count(); i++ {
+				elem := p.BranchPageElement(i)
+				if bytes.Compare(elem.Key(), key) > 0 {
+					break
+				}
+				next = elem.Pgid()
+			}
+			id = next
+		case p.IsLeafPage():
+			for i := uint16(0); i < p.Count(); i++ {
+				if bytes.Equal(p.LeafPageElement(i).Key(), key) {
+					return id, int(i), nil
+				}
+			}
+			return 0, 0, ErrNotFound
+		default:
+			return 0, 0, fmt.Errorf("unexpected page type %q of page %d", p.Typ(), id)
+		}
+	}
+}
+
+// CountKeys returns the number of keys in the subtree rooted at the given
+// page, including the nested buckets and their keys. The pages which can't
+// be read are skipped, so it's a lower bound for a corrupted subtree.
+func CountKeys(path string, root common.Pgid) int {
+	var count int
+	visited := make(map[common.Pgid]bool)
+	var visit func(id common.Pgid)
+	visit = func(id common.Pgid) {
+		// A corrupted tree may have cycles.
+		if visited[id] {
+			return
+		}
+		visited[id] = true
+		p, _, err := guts_cli.ReadPage(path, uint64(id))
+		if err != nil {
+			return
+		}
+		switch {
+		case p.IsBranchPage():
+			for i := uint16(0); i < p.Count(); i++ {
+				visit(p.BranchPageElement(i).Pgid())
+			}
+		case p.IsLeafPage():
+			for i := uint16(0); i < p.Count(); i++ {
+				count++
+				elem := p.LeafPageElement(i)
+				if !elem.IsBucketEntry() {
+					continue
+				}
+				if child := elem.Bucket().RootPage(); child != 0 {
+					visit(child)
+				} else {
+					count += int(elem.Bucket().InlinePage(elem.Value()).Count())
+				}
+			}
+		}
+	}
+	visit(root)
+	return count
+}
+
+// ReachablePages returns the pages of the tree rooted at the given page,
+// including the overflow pages and the pages of the nested buckets. The
+// pages which can't be read are skipped.
+func ReachablePages(path string, root common.Pgid) map[common.Pgid]bool {
+	reachable := make(map[common.Pgid]bool)
+	var visit func(id common.Pgid)
+	visit = func(id common.Pgid) {
+		// A corrupted tree may have cycles.
+		if reachable[id] {
+			return
+		}
+		reachable[id] = true
+		p, _, err := guts_cli.ReadPage(path, uint64(id))
+		if err != nil {
+			return
+		}
+		for i := uint32(1); i <= p.Overflow(); i++ {
+			reachable[id+common.Pgid(i)] = true
+		}
+		switch {
+		case p.IsBranchPage():
+			for i := uint16(0); i < p.Count(); i++ {
+				visit(p.BranchPageElement(i).Pgid())
+			}
+		case p.IsLeafPage():
+			for i := uint16(0); i < p.Count(); i++ {
+				elem := p.LeafPageElement(i)
+				if !elem.IsBucketEntry() {
+					continue
+				}
+				if child := elem.Bucket().RootPage(); child != 0 {
+					visit(child)
+				}
+			}
+		}
+	}
+	visit(root)
+	return reachable
+}
@@ -0,0 +1,519 @@
+package surgeon
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+
+	"go.etcd.io/bbolt/internal/common"
+	"go.etcd.io/bbolt/internal/guts_cli"
+)
+
+// SalvageOptions represents the options of Salvage.
+type SalvageOptions struct {
+	// PageSize is the page size of the file. If it's zero, it's read from
+	// the first valid meta page.
+	PageSize int
+
+	// OrphansBucket is the name of the bucket receiving the key/values of the
+	// pages whose bucket is unknown, in a nested bucket named after the id of
+	// the page heading them. If it's empty, they're dropped.
+	OrphansBucket []byte
+}
+
+// SalvageStats represents the pages scanned and the items recovered by
+// Salvage.
+type SalvageStats struct {
+	// PageSize is the page size used to scan the file.
+	PageSize int
+	// Pages is the number of pages of the file.
+	Pages int
+	// LeafPages and BranchPages are the number of plausible leaf and branch
+	// pages found.
+	LeafPages   int
+	BranchPages int
+	// FreePages is the number of plausible pages skipped because they're
+	// free and not reachable from any meta page.
+	FreePages int
+	// OrphanLeafPages is the number of leaf pages whose bucket is unknown.
+	OrphanLeafPages int
+	// Buckets and Keys are the number of bucket entries and key/values
+	// recovered, including the ones of the inline buckets.
+	Buckets int
+	Keys    int
+}
+
+// SalvagedItem represents a bucket entry or a key/value recovered by Salvage.
+type SalvagedItem struct {
+	// Bucket is the path of the bucket holding the item, empty for the
+	// buckets at the top level.
+	Bucket [][]byte
+	Key    []byte
+	Value  []byte
+	// IsBucket is true for a bucket entry, which carries the sequence of the
+	// bucket instead of a value.
+	IsBucket bool
+	Sequence uint64
+	// Pgid is the leaf page holding the item.
+	Pgid common.Pgid
+	// Txid is the transaction of the newest meta page reaching the leaf
+	// page, or 0 if no meta page reaches it.
+	Txid common.Txid
+}
+
+// salvagePageSizes are the page sizes tried when the first meta page is
+// corrupted.
+var salvagePageSizes = []int{1024, 2048, 4096, 8192, 16384, 32768, 65536}
+
+// salvagePage is what Salvage keeps of a plausible branch or leaf page.
+type salvagePage struct {
+	branch bool
+	// children are the child pages of a branch page.
+	children []common.Pgid
+	// buckets are the bucket entries with a root page of a leaf page.
+	buckets []salvageBucketRef
+	// onlyBuckets is true if a leaf page has only bucket entries, as the
+	// pages of the root bucket do.
+	onlyBuckets bool
+
+	assigned bool
+	path     [][]byte
+	txid     common.Txid
+	// orphan is true if the bucket of the page is unknown.
+	orphan bool
+}
+
+type salvageBucketRef struct {
+	name []byte
+	root common.Pgid
+}
+
+// Salvage recovers the key/values of a corrupted file without trusting its
+// trees. It scans every page, keeps the ones which are plausible branch or
+// leaf pages, and rebuilds the bucket of each page from the bucket entries
+// and branch pages referencing it, starting from the root of each valid meta
+// page, the newest first. The subtrees referenced by no page are then either
+// part of the root bucket, if their leaf pages only have bucket entries, or
+// put under opts.OrphansBucket.
+//
+// The items are passed to fn ordered by the transaction of their leaf page,
+// oldest first, so that the newest version of a key wins if fn overwrites
+// the previous ones. The bucket entry of a bucket is passed before the
+// items of its leaf page, but the items of a bucket rooted at another page
+// may come before its entry.
+//
+// The file is only read.
+func Salvage(path string, opts SalvageOptions, fn func(SalvagedItem) error) (SalvageStats, error) {
+	var stats SalvageStats
+
+	f, err := os.Open(path)
+	if err != nil {
+		return stats, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return stats, err
+	}
+
+	pageSize := opts.PageSize
+	if pageSize == 0 {
+		if pageSize, err = salvagePageSize(f); err != nil {
+			return stats, err
+		}
+	}
+	if pageSize < salvagePageSizes[0] {
+		return stats, fmt.Errorf("invalid page size %d", pageSize)
+	}
+	stats.PageSize = pageSize
+	n := common.Pgid(fi.Size() / int64(pageSize))
+	stats.Pages = int(n)
+
+	metas := salvageMetas(f, pageSize, n)
+
+	// Scan every page.
+	pages := make(map[common.Pgid]*salvagePage)
+	for id := common.Pgid(2); id < n; id++ {
+		sp, err := readSalvagePage(f, id, pageSize, n)
+		if err != nil {
+			continue
+		}
+		if sp.branch {
+			stats.BranchPages++
+		} else {
+			stats.LeafPages++
+		}
+		pages[id] = sp
+	}
+
+	// Assign the pages reachable from the meta pages to their bucket.
+	for _, m := range metas {
+		assignSalvagePages(pages, m.RootBucket().RootPage(), nil, m.Txid(), false)
+	}
+
+	// The free pages aren't reachable, but hold stale copies of the pages.
+	if len(metas) > 0 {
+		for _, id := range salvageFreelist(f, metas[0], pageSize, n) {
+			if sp, ok := pages[id]; ok && !sp.assigned {
+				delete(pages, id)
+				stats.FreePages++
+			}
+		}
+	}
+
+	assignSalvageOrphans(pages, opts.OrphansBucket)
+
+	// Pass the items of the leaf pages, oldest transaction first.
+	var leaves []common.Pgid
+	for id, sp := range pages {
+		if sp.branch {
+			continue
+		}
+		if sp.orphan {
+			stats.OrphanLeafPages++
+			if len(opts.OrphansBucket) == 0 {
+				continue
+			}
+		}
+		leaves = append(leaves, id)
+	}
+	sort.Slice(leaves, func(i, j int) bool {
+		a, b := pages[leaves[i]], pages[leaves[j]]
+		if a.txid != b.txid {
+			return a.txid < b.txid
+		}
+		return leaves[i] < leaves[j]
+	})
+	for _, id := range leaves {
+		sp := pages[id]
+		p, _, err := guts_cli.ReadPageFrom(f, uint64(id), uint64(pageSize), n)
+		if err != nil {
+			return stats, fmt.Errorf("read page %d: %w", id, err)
+		}
+		for i := uint16(0); i < p.Count(); i++ {
+			elem := p.LeafPageElement(i)
+			item := SalvagedItem{Bucket: sp.path, Key: elem.Key(), Pgid: id, Txid: sp.txid}
+			if !elem.IsBucketEntry() {
+				item.Value = elem.Value()
+				stats.Keys++
+				if err := fn(item); err != nil {
+					return stats, err
+				}
+				continue
+			}
+
+			b := elem.Bucket()
+			item.IsBucket = true
+			item.Sequence = b.InSequence()
+			stats.Buckets++
+			if err := fn(item); err != nil {
+				return stats, err
+			}
+			if b.RootPage() != 0 {
+				continue
+			}
+			inline := b.InlinePage(elem.Value())
+			bucket := appendPath(sp.path, elem.Key())
+			for j := uint16(0); j < inline.Count(); j++ {
+				e := inline.LeafPageElement(j)
+				stats.Keys++
+				if err := fn(SalvagedItem{Bucket: bucket, Key: e.Key(), Value: e.Value(), Pgid: id, Txid: sp.txid}); err != nil {
+					return stats, err
+				}
+			}
+		}
+	}
+	return stats, nil
+}
+
+// salvagePageSize returns the page size of the first valid meta page.
+func salvagePageSize(r io.ReaderAt) (int, error) {
+	// The meta pages fit in the smallest page size.
+	buf := make([]byte, salvagePageSizes[0])
+	if _, err := r.ReadAt(buf, 0); err == nil {
+		if m := common.LoadPageMeta(buf); m.Validate() == nil {
+			return int(m.PageSize()), nil
+		}
+	}
+	// The second meta page is at the page size offset.
+	for _, size := range salvagePageSizes {
+		if _, err := r.ReadAt(buf, int64(size)); err != nil {
+			break
+		}
+		if m := common.LoadPageMeta(buf); m.Validate() == nil && int(m.PageSize()) == size {
+			return size, nil
+		}
+	}
+	return 0, errors.New("no valid meta page found, the page size must be given")
+}
+
+// salvageMetas returns the valid meta pages, the newest first.
+func salvageMetas(r io.ReaderAt, pageSize int, n common.Pgid) []*common.Meta {
+	var metas []*common.Meta
+	for id := uint64(0); id < 2; id++ {
+		buf := make([]byte, pageSize)
+		if _, err := r.ReadAt(buf, int64(id)*int64(pageSize)); err != nil {
+			continue
+		}
+		m := common.LoadPageMeta(buf)
+		if m.Validate() != nil || int(m.PageSize()) != pageSize {
+			continue
+		}
+		if root := m.RootBucket().RootPage(); root < 2 || root >= n {
+			continue
+		}
+		metas = append(metas, m)
+	}
+	sort.Slice(metas, func(i, j int) bool { return metas[i].Txid() > metas[j].Txid() })
+	return metas
+}
+
+// salvageFreelist returns the free pages of the meta page, or nothing if
+// its freelist isn't persisted or is corrupted.
+func salvageFreelist(r io.ReaderAt, m *common.Meta, pageSize int, n common.Pgid) []common.Pgid {
+	if !m.IsFreelistPersisted() || m.Freelist() < 2 || m.Freelist() >= n {
+		return nil
+	}
+	p, buf, err := guts_cli.ReadPageFrom(r, uint64(m.Freelist()), uint64(pageSize), n)
+	if err != nil || !p.IsFreelistPage() {
+		return nil
+	}
+	idx, count := p.FreelistPageCount()
+	if uint64(common.PageHeaderSize)+uint64(idx+count)*8 > uint64(len(buf)) {
+		return nil
+	}
+	return p.FreelistPageIds()
+}
+
+// readSalvagePage reads a page, and returns what Salvage needs if it's a
+// plausible branch or leaf page.
+func readSalvagePage(r io.ReaderAt, id common.Pgid, pageSize int, n common.Pgid) (*salvagePage, error) {
+	p, buf, err := guts_cli.ReadPageFrom(r, uint64(id), uint64(pageSize), n)
+	if err != nil {
+		return nil, err
+	}
+	switch p.Flags() {
+	case common.BranchPageFlag:
+		if err := validateBranchPage(buf, n); err != nil {
+			return nil, err
+		}
+		sp := &salvagePage{branch: true}
+		for i := uint16(0); i < p.Count(); i++ {
+			sp.children = append(sp.children, p.BranchPageElement(i).Pgid())
+		}
+		return sp, nil
+	case common.LeafPageFlag:
+		if err := validateLeafPage(buf, n, false); err != nil {
+			return nil, err
+		}
+		sp := &salvagePage{onlyBuckets: p.Count() > 0}
+		for i := uint16(0); i < p.Count(); i++ {
+			elem := p.LeafPageElement(i)
+			if !elem.IsBucketEntry() {
+				sp.onlyBuckets = false
+				continue
+			}
+			if root := elem.Bucket().RootPage(); root != 0 {
+				sp.buckets = append(sp.buckets, salvageBucketRef{name: elem.Key(), root: root})
+			}
+		}
+		return sp, nil
+	default:
+		return nil, fmt.Errorf("page %d isn't a branch or leaf page: %q", id, p.Typ())
+	}
+}
+
+// validateBranchPage checks that the elements of the branch page are within
+// its buffer, with sorted keys and valid child pages.
+func validateBranchPage(buf []byte, n common.Pgid) error {
+	p := common.LoadPage(buf)
+	if p.Count() == 0 {
+		return errors.New("empty branch page")
+	}
+	end := uint64(len(buf))
+	if uint64(common.PageHeaderSize)+uint64(p.Count())*uint64(common.BranchPageElementSize) > end {
+		return errors.New("elements out of the page")
+	}
+	var prev []byte
+	for i := uint16(0); i < p.Count(); i++ {
+		elem := p.BranchPageElement(i)
+		off := uint64(common.PageHeaderSize) + uint64(i)*uint64(common.BranchPageElementSize)
+		start := off + uint64(elem.Pos())
+		if elem.Ksize() == 0 || start+uint64(elem.Ksize()) > end {
+			return fmt.Errorf("key %d out of the page", i)
+		}
+		key := buf[start : start+uint64(elem.Ksize())]
+		if i > 0 && bytes.Compare(prev, key) >= 0 {
+			return fmt.Errorf("key %d out of order", i)
+		}
+		prev = key
+		if child := elem.Pgid(); child < 2 || child >= n {
+			return fmt.Errorf("child page %d out of bounds", child)
+		}
+	}
+	return nil
+}
+
+// validateLeafPage checks that the elements of the leaf page are within its
+// buffer, with sorted keys, and that its bucket entries are valid. The
+// inline pages can't have bucket entries.
+func validateLeafPage(buf []byte, n common.Pgid, inline bool) error {
+	if uint64(len(buf)) < uint64(common.PageHeaderSize) {
+		return errors.New("truncated page")
+	}
+	p := common.LoadPage(buf)
+	if p.Flags() != common.LeafPageFlag {
+		return fmt.Errorf("unexpected page type %q", p.Typ())
+	}
+	end := uint64(len(buf))
+	if uint64(common.PageHeaderSize)+uint64(p.Count())*uint64(common.LeafPageElementSize) > end {
+		return errors.New("elements out of the page")
+	}
+	var prev []byte
+	for i := uint16(0); i < p.Count(); i++ {
+		elem := p.LeafPageElement(i)
+		if elem.Flags()&^common.BucketLeafFlag != 0 {
+			return fmt.Errorf("unexpected flags %#x of element %d", elem.Flags(), i)
+		}
+		off := uint64(common.PageHeaderSize) + uint64(i)*uint64(common.LeafPageElementSize)
+		start := off + uint64(elem.Pos())
+		if elem.Ksize() == 0 || start+uint64(elem.Ksize())+uint64(elem.Vsize()) > end {
+			return fmt.Errorf("key/value %d out of the page", i)
+		}
+		key := buf[start : start+uint64(elem.Ksize())]
+		if i > 0 && bytes.Compare(prev, key) >= 0 {
+			return fmt.Errorf("key %d out of order", i)
+		}
+		prev = key
+		if !elem.IsBucketEntry() {
+			continue
+		}
+
+		if inline {
+			return errors.New("bucket entry in an inline bucket")
+		}
+		if elem.Vsize() < uint32(common.BucketHeaderSize) {
+			return fmt.Errorf("truncated bucket entry %d", i)
+		}
+		value := buf[start+uint64(elem.Ksize()) : start+uint64(elem.Ksize())+uint64(elem.Vsize())]
+		if root := elem.Bucket().RootPage(); root == 0 {
+			if err := validateLeafPage(value[common.BucketHeaderSize:], n, true); err != nil {
+				return fmt.Errorf("inline bucket %d: %w", i, err)
+			}
+		} else if root < 2 || root >= n {
+			return fmt.Errorf("root page %d of bucket entry %d out of bounds", root, i)
+		}
+	}
+	return nil
+}
+
+// assignSalvagePages assigns the unassigned pages of the tree rooted at the
+// given page, and of its nested buckets, to their bucket.
+func assignSalvagePages(pages map[common.Pgid]*salvagePage, root common.Pgid, path [][]byte, txid common.Txid, orphan bool) {
+	type entry struct {
+		id   common.Pgid
+		path [][]byte
+	}
+	stack := []entry{{root, path}}
+	for len(stack) > 0 {
+		e := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		sp, ok := pages[e.id]
+		if !ok || sp.assigned {
+			continue
+		}
+		sp.assigned, sp.path, sp.txid, sp.orphan = true, e.path, txid, orphan
+		for _, child := range sp.children {
+			stack = append(stack, entry{child, e.path})
+		}
+		for _, b := range sp.buckets {
+			stack = append(stack, entry{b.root, appendPath(e.path, b.name)})
+		}
+	}
+}
+
+// assignSalvageOrphans assigns the pages not reachable from any meta page.
+// The subtrees referenced by no other page whose leaf pages only have
+// bucket entries are assigned to the root bucket, as its pages would be
+// after the loss of a branch page, and the other ones to a bucket under
+// the orphans bucket. The pages left, in cycles, are orphans too.
+func assignSalvageOrphans(pages map[common.Pgid]*salvagePage, orphans []byte) {
+	referenced := make(map[common.Pgid]bool)
+	for _, sp := range pages {
+		if sp.assigned {
+			continue
+		}
+		for _, child := range sp.children {
+			referenced[child] = true
+		}
+		for _, b := range sp.buckets {
+			referenced[b.root] = true
+		}
+	}
+	var tops []common.Pgid
+	for id, sp := range pages {
+		if !sp.assigned && !referenced[id] {
+			tops = append(tops, id)
+		}
+	}
+	sort.Slice(tops, func(i, j int) bool { return tops[i] < tops[j] })
+
+	orphan := func(id common.Pgid) {
+		assignSalvagePages(pages, id, [][]byte{orphans, []byte(strconv.FormatUint(uint64(id), 10))}, 0, true)
+	}
+	// The root bucket first, as it claims the trees of its buckets.
+	for _, id := range tops {
+		if salvageOnlyBuckets(pages, id) {
+			assignSalvagePages(pages, id, nil, 0, false)
+		}
+	}
+	for _, id := range tops {
+		orphan(id)
+	}
+
+	var left []common.Pgid
+	for id, sp := range pages {
+		if !sp.assigned {
+			left = append(left, id)
+		}
+	}
+	sort.Slice(left, func(i, j int) bool { return left[i] < left[j] })
+	for _, id := range left {
+		orphan(id)
+	}
+}
+
+// salvageOnlyBuckets returns true if the unassigned leaf pages of the tree
+// rooted at the given page only have bucket entries.
+func salvageOnlyBuckets(pages map[common.Pgid]*salvagePage, root common.Pgid) bool {
+	visited := make(map[common.Pgid]bool)
+	leaves := 0
+	stack := []common.Pgid{root}
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		sp, ok := pages[id]
+		if !ok || sp.assigned || visited[id] {
+			continue
+		}
+		visited[id] = true
+		if sp.branch {
+			stack = append(stack, sp.children...)
+			continue
+		}
+		if !sp.onlyBuckets {
+			return false
+		}
+		leaves++
+	}
+	return leaves > 0
+}
+
+// appendPath returns a new bucket path with the name appended.
+func appendPath(path [][]byte, name []byte) [][]byte {
+	return append(append([][]byte(nil), path...), name)
+}
@@ -0,0 +1,115 @@
+package surgeon_test
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	bolt "go.etcd.io/bbolt"
+	"go.etcd.io/bbolt/internal/btesting"
+	"go.etcd.io/bbolt/internal/common"
+	"go.etcd.io/bbolt/internal/guts_cli"
+	"go.etcd.io/bbolt/internal/surgeon"
+)
+
+func TestSalvage_DestroyedBranchPage(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{PageSize: 4096})
+	require.NoError(t,
+		db.Fill([]byte("data"), 1, 1000,
+			func(tx int, k int) []byte { return []byte(fmt.Sprintf("%04d", k)) },
+			func(tx int, k int) []byte { return make([]byte, 100) },
+		))
+	require.NoError(t,
+		db.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucket([]byte("small"))
+			if err != nil {
+				return err
+			}
+			require.NoError(t, b.SetSequence(7))
+			require.NoError(t, b.Put([]byte("a"), []byte("1")))
+			return b.Put([]byte("b"), []byte("2"))
+		}))
+	db.Close()
+
+	// Destroy the root page of the bucket, both meta pages reference it.
+	leaf, index, err := surgeon.FindBucketEntry(db.Path(), [][]byte{[]byte("data")})
+	require.NoError(t, err)
+	p, _, err := guts_cli.ReadPage(db.Path(), uint64(leaf))
+	require.NoError(t, err)
+	root := p.LeafPageElement(uint16(index)).Bucket().RootPage()
+	zeroPage(t, db.Path(), root, 4096)
+
+	items := make(map[string][]byte)
+	stats, err := surgeon.Salvage(db.Path(), surgeon.SalvageOptions{OrphansBucket: []byte("lost+found")},
+		func(item surgeon.SalvagedItem) error {
+			if item.IsBucket {
+				assert.Nil(t, item.Bucket)
+				if string(item.Key) == "small" {
+					assert.Equal(t, uint64(7), item.Sequence)
+				}
+				return nil
+			}
+			items[string(bytes.Join(append(item.Bucket, item.Key), []byte("/")))] = item.Value
+			return nil
+		})
+	require.NoError(t, err)
+
+	assert.Equal(t, 4096, stats.PageSize)
+	// The older meta page reaches the previous version of the root bucket.
+	assert.Equal(t, 3, stats.Buckets)
+	assert.Equal(t, 1002, stats.Keys)
+	assert.NotZero(t, stats.OrphanLeafPages)
+	assert.Equal(t, []byte("1"), items["small/a"])
+	assert.Equal(t, []byte("2"), items["small/b"])
+	// The bucket of the leaf pages is lost, they're recovered as orphans.
+	var orphans int
+	for k := range items {
+		if bytes.HasPrefix([]byte(k), []byte("lost+found/")) {
+			orphans++
+		}
+	}
+	assert.Equal(t, 1000, orphans)
+}
+
+func TestSalvage_NewestTxidWins(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{PageSize: 4096})
+	require.NoError(t,
+		db.Fill([]byte("data"), 1, 1000,
+			func(tx int, k int) []byte { return []byte(fmt.Sprintf("%04d", k)) },
+			func(tx int, k int) []byte { return make([]byte, 100) },
+		))
+	require.NoError(t,
+		db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket([]byte("data")).Put([]byte("0001"), []byte("new value"))
+		}))
+	db.Close()
+
+	// The previous version of the updated page is reachable from the older
+	// meta page.
+	values := make(map[string][]byte)
+	var versions int
+	_, err := surgeon.Salvage(db.Path(), surgeon.SalvageOptions{}, func(item surgeon.SalvagedItem) error {
+		if !item.IsBucket && string(item.Key) == "0001" {
+			versions++
+		}
+		values[string(item.Key)] = item.Value
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, versions)
+	assert.Equal(t, []byte("new value"), values["0001"])
+	assert.Len(t, values, 1001)
+}
+
+// zeroPage overwrites the page with zeros.
+func zeroPage(t *testing.T, path string, id common.Pgid, pageSize int) {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	require.NoError(t, err)
+	defer f.Close()
+	_, err = f.WriteAt(make([]byte, pageSize), int64(id)*int64(pageSize))
+	require.NoError(t, err)
+}
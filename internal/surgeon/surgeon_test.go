@@ -8,6 +8,7 @@ import (
 
 	bolt "go.etcd.io/bbolt"
 	"go.etcd.io/bbolt/internal/btesting"
+	"go.etcd.io/bbolt/internal/guts_cli"
 	"go.etcd.io/bbolt/internal/surgeon"
 )
 
@@ -55,3 +56,38 @@ func TestRevertMetaPage(t *testing.T) {
 				return nil
 			}))
 }
+
+func TestPruneBranchElement(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{PageSize: 4096})
+	assert.NoError(t,
+		db.Fill([]byte("data"), 1, 1000,
+			func(tx int, k int) []byte { return []byte(fmt.Sprintf("%04d", k)) },
+			func(tx int, k int) []byte { return make([]byte, 100) },
+		))
+	db.Close()
+
+	leaf, index, err := surgeon.FindBucketEntry(db.Path(), [][]byte{[]byte("data")})
+	assert.NoError(t, err)
+	p, _, err := guts_cli.ReadPage(db.Path(), uint64(leaf))
+	assert.NoError(t, err)
+	root := p.LeafPageElement(uint16(index)).Bucket().RootPage()
+	assert.Equal(t, 1000, surgeon.CountKeys(db.Path(), root))
+
+	p, _, err = guts_cli.ReadPage(db.Path(), uint64(root))
+	assert.NoError(t, err)
+	child := p.BranchPageElement(1).Pgid()
+	dropped := surgeon.CountKeys(db.Path(), child)
+	assert.NoError(t, surgeon.PruneBranchElement(db.Path(), root, child))
+	assert.ErrorIs(t, surgeon.PruneBranchElement(db.Path(), root, child), surgeon.ErrNotFound)
+
+	db.MustReopen()
+	assert.NoError(t,
+		db.View(
+			func(tx *bolt.Tx) error {
+				assert.Equal(t, 1000-dropped, tx.Bucket([]byte("data")).Stats().KeyN)
+				return nil
+			}))
+
+	// The pages of the pruned subtree are unreachable, skip the check.
+	db.MustClose()
+}
@@ -0,0 +1,181 @@
+package bbolt
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	berrors "go.etcd.io/bbolt/errors"
+	"go.etcd.io/bbolt/internal/common"
+)
+
+// MmapAdvice tells the kernel how the memory-mapped database file is going
+// to be accessed, via madvise(). It's ignored on platforms which don't
+// support madvise().
+type MmapAdvice string
+
+const (
+	// MmapAdviceRandom expects page references in random order, so the
+	// kernel doesn't read ahead. This is the default.
+	MmapAdviceRandom = MmapAdvice("random")
+	// MmapAdviceSequential expects page references in sequential order, so
+	// the kernel reads ahead aggressively.
+	MmapAdviceSequential = MmapAdvice("sequential")
+	// MmapAdviceWillNeed expects access in the near future, so the kernel
+	// starts reading the pages ahead.
+	MmapAdviceWillNeed = MmapAdvice("willneed")
+	// MmapAdviceDontNeed doesn't expect access in the near future, so the
+	// kernel may drop the pages from the page cache.
+	MmapAdviceDontNeed = MmapAdvice("dontneed")
+)
+
+func (a MmapAdvice) validate() error {
+	switch a {
+	case MmapAdviceRandom, MmapAdviceSequential, MmapAdviceWillNeed, MmapAdviceDontNeed:
+		return nil
+	}
+	return fmt.Errorf("unknown mmap advice %q", string(a))
+}
+
+// Advise gives the kernel the given advice for the byte range of the
+// database file starting at offset. The range is clamped to the mmap, and
+// extended to whole OS pages.
+func (db *DB) Advise(offset, length int64, advice MmapAdvice) error {
+	if err := advice.validate(); err != nil {
+		return err
+	}
+
+	db.genlock.RLock()
+	defer db.genlock.RUnlock()
+	if !db.mapped() {
+		return berrors.ErrDatabaseNotOpen
+	}
+	return db.advise(offset, length, advice)
+}
+
+// AdviseBucket gives the kernel the given advice for all the pages of the
+// bucket at the given path, including its nested buckets. An empty path
+// advises the pages of all the buckets.
+func (db *DB) AdviseBucket(advice MmapAdvice, path ...[]byte) error {
+	if err := advice.validate(); err != nil {
+		return err
+	}
+	return db.View(func(tx *Tx) error {
+		return tx.adviseBucket(advice, path)
+	})
+}
+
+// Warm prefetches all the pages of the bucket at the given path, including
+// its nested buckets, into the page cache, so that the first transactions
+// after opening the database don't wait for page faults. An empty path
+// prefetches the pages of all the buckets.
+//
+// The first page of every node is read before Warm returns, the kernel reads
+// the rest ahead in the background.
+func (db *DB) Warm(path ...[]byte) error {
+	return db.AdviseBucket(MmapAdviceWillNeed, path...)
+}
+
+// adviseBucket gives the kernel the given advice for all the pages of a
+// bucket tree.
+func (tx *Tx) adviseBucket(advice MmapAdvice, path [][]byte) error {
+	ranges, err := tx.bucketRanges(path)
+	if err != nil {
+		return err
+	}
+	tx.db.genlock.RLock()
+	defer tx.db.genlock.RUnlock()
+	for _, r := range ranges {
+		if err := tx.db.advise(r.start, r.end-r.start, advice); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// byteRange is a range of the database file, in bytes.
+type byteRange struct {
+	start, end int64
+}
+
+// bucketAt returns the bucket at the given path, or the root bucket if the
+// path is empty.
+func (tx *Tx) bucketAt(path [][]byte) (*Bucket, error) {
+	b := &tx.root
+	for _, name := range path {
+		if b = b.Bucket(name); b == nil {
+			return nil, berrors.ErrBucketNotFound
+		}
+	}
+	return b, nil
+}
+
+// bucketRanges returns the sorted ranges of the file holding the pages of
+// the bucket at the given path and of its nested buckets. Adjacent pages
+// are merged into a single range.
+func (tx *Tx) bucketRanges(path [][]byte) ([]byteRange, error) {
+	b, err := tx.bucketAt(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var pages []common.Pgid
+	b.forEachTreePage(func(p *common.Page) {
+		for i := 0; i <= int(p.Overflow()); i++ {
+			pages = append(pages, p.Id()+common.Pgid(i))
+		}
+	})
+	sort.Slice(pages, func(i, j int) bool { return pages[i] < pages[j] })
+
+	var ranges []byteRange
+	pageSize := int64(tx.db.pageSize)
+	for i := 0; i < len(pages); {
+		j := i + 1
+		for j < len(pages) && pages[j] == pages[j-1]+1 {
+			j++
+		}
+		ranges = append(ranges, byteRange{start: int64(pages[i]) * pageSize, end: int64(pages[j-1]+1) * pageSize})
+		i = j
+	}
+	return ranges, nil
+}
+
+// forEachTreePage iterates over every page of the bucket and of its nested
+// buckets, excluding inline pages.
+func (b *Bucket) forEachTreePage(fn func(*common.Page)) {
+	if b.page != nil {
+		// Inline buckets have no pages of their own nor nested buckets.
+		return
+	}
+	b.tx.forEachPage(b.RootPage(), func(p *common.Page, _ int, _ []common.Pgid) {
+		fn(p)
+	})
+	_ = b.ForEachBucket(func(k []byte) error {
+		b.Bucket(k).forEachTreePage(fn)
+		return nil
+	})
+}
+
+// advise gives the kernel the given advice for a byte range of the mmap.
+// The caller must hold the gen lock.
+func (db *DB) advise(offset, length int64, advice MmapAdvice) error {
+	if db.dataref == nil {
+		// The file isn't mapped via a byte slice, e.g. on Windows.
+		return nil
+	}
+
+	// madvise() requires the range to start at an OS page boundary.
+	osPageSize := int64(os.Getpagesize())
+	start := offset / osPageSize * osPageSize
+	end := offset + length
+	if end > int64(db.datasz) {
+		end = int64(db.datasz)
+	}
+	if start < 0 || start >= end {
+		return nil
+	}
+	if err := madvise(db.dataref[start:end], advice); err != nil {
+		return fmt.Errorf("madvise: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package bbolt_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "go.etcd.io/bbolt"
+	berrors "go.etcd.io/bbolt/errors"
+	"go.etcd.io/bbolt/internal/btesting"
+)
+
+func TestOptions_MmapAdvice(t *testing.T) {
+	for _, advice := range []bolt.MmapAdvice{
+		bolt.MmapAdviceRandom,
+		bolt.MmapAdviceSequential,
+		bolt.MmapAdviceWillNeed,
+		bolt.MmapAdviceDontNeed,
+	} {
+		advice := advice
+		t.Run(string(advice), func(t *testing.T) {
+			db := btesting.MustCreateDBWithOption(t, &bolt.Options{MmapAdvice: advice})
+			fillWidgets(t, db)
+
+			db.MustClose()
+			db.MustReopen()
+			db.MustCheck()
+		})
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := bolt.Open(t.TempDir()+"/db", 0600, &bolt.Options{MmapAdvice: "often"})
+		require.ErrorContains(t, err, "unknown mmap advice")
+	})
+}
+
+func TestDB_Advise(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	fillWidgets(t, db)
+
+	info := db.Info()
+	require.NoError(t, db.Advise(0, 1<<40, bolt.MmapAdviceDontNeed))
+	require.NoError(t, db.Advise(int64(info.PageSize)+1, int64(info.PageSize), bolt.MmapAdviceWillNeed))
+	require.NoError(t, db.Advise(1<<40, 1, bolt.MmapAdviceWillNeed))
+	require.ErrorContains(t, db.Advise(0, 1, "often"), "unknown mmap advice")
+	db.MustCheck()
+
+	closed := db.DB
+	db.MustClose()
+	require.ErrorIs(t, closed.Advise(0, 1, bolt.MmapAdviceRandom), berrors.ErrDatabaseNotOpen)
+}
+
+func TestDB_Warm(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	fillWidgets(t, db)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.Bucket([]byte("widgets")).CreateBucket([]byte("inline"))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("foo"), []byte("bar"))
+	}))
+
+	require.NoError(t, db.Warm())
+	require.NoError(t, db.Warm([]byte("widgets")))
+	require.NoError(t, db.Warm([]byte("widgets"), []byte("inline")))
+	require.NoError(t, db.AdviseBucket(bolt.MmapAdviceDontNeed, []byte("widgets")))
+	require.ErrorIs(t, db.Warm([]byte("gadgets")), berrors.ErrBucketNotFound)
+	require.ErrorContains(t, db.AdviseBucket("often"), "unknown mmap advice")
+	db.MustCheck()
+}
+
+// fillWidgets fills the "widgets" bucket with enough data to span several
+// branch and overflow pages.
+func fillWidgets(t testing.TB, db *btesting.DB) {
+	err := db.Fill([]byte("widgets"), 4, 500,
+		func(tx int, k int) []byte { return []byte(fmt.Sprintf("%04d%04d", tx, k)) },
+		func(tx int, k int) []byte {
+			if k%100 == 0 {
+				return make([]byte, 10000)
+			}
+			return make([]byte, 100)
+		},
+	)
+	require.NoError(t, err)
+}
+
+func TestOptions_HugePages(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{HugePages: true, InitialMmapSize: 1 << 22})
+	fillWidgets(t, db)
+
+	db.MustClose()
+	db.MustReopen()
+	db.MustCheck()
+}
@@ -0,0 +1,32 @@
+//go:build !windows
+// +build !windows
+
+package bbolt
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// madvise gives the kernel the given advice for the mapped memory b.
+func madvise(b []byte, advice MmapAdvice) error {
+	var flag int
+	switch advice {
+	case MmapAdviceRandom:
+		flag = unix.MADV_RANDOM
+	case MmapAdviceSequential:
+		flag = unix.MADV_SEQUENTIAL
+	case MmapAdviceWillNeed:
+		flag = unix.MADV_WILLNEED
+	case MmapAdviceDontNeed:
+		flag = unix.MADV_DONTNEED
+	default:
+		return advice.validate()
+	}
+	if err := unix.Madvise(b, flag); err != nil && err != syscall.ENOSYS {
+		// Ignore not implemented error in kernel because it still works.
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,6 @@
+package bbolt
+
+// madvise isn't supported on Windows.
+func madvise(_ []byte, _ MmapAdvice) error {
+	return nil
+}
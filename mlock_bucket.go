@@ -0,0 +1,194 @@
+package bbolt
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"sort"
+)
+
+// The pages of the buckets locked with DB.MlockBucket are located when the
+// bucket is locked and again after every commit, since a commit writes the
+// modified pages of a bucket to new locations. They are locked again after
+// the file is remapped, since unmapping the file unlocks them.
+
+// MlockBucket locks all the pages of the bucket at the given path, including
+// its nested buckets, in memory, so that reading them never causes a major
+// page fault. An empty path locks the pages of all the buckets. Unlike
+// Options.Mlock, it doesn't lock the whole file, which may not fit in memory.
+//
+// The pages stay locked until DB.MunlockBucket or DB.Close. Every commit
+// locates the pages of the locked buckets again, which reads all of them.
+//
+// Supported only on Unix via mlock/munlock syscalls.
+func (db *DB) MlockBucket(path ...[]byte) error {
+	if db.Mlock {
+		return errors.New("mlock error: the whole file is already locked by Options.Mlock")
+	}
+	if db.pcache != nil {
+		return errors.New("mlock error: the file isn't memory mapped with Options.NoMmap")
+	}
+	if err := db.View(func(tx *Tx) error {
+		_, err := tx.bucketAt(path)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	db.pinlock.Lock()
+	if indexOfPath(db.pinned, path) < 0 {
+		db.pinned = append(db.pinned, clonePath(path))
+	}
+	db.pinlock.Unlock()
+	return db.repin()
+}
+
+// MunlockBucket unlocks the pages of a bucket locked with DB.MlockBucket.
+func (db *DB) MunlockBucket(path ...[]byte) error {
+	db.pinlock.Lock()
+	if i := indexOfPath(db.pinned, path); i >= 0 {
+		db.pinned = append(db.pinned[:i], db.pinned[i+1:]...)
+	}
+	db.pinlock.Unlock()
+	return db.repin()
+}
+
+// repin locates the pages of the locked buckets as of the latest
+// transaction, unlocks the pages which were locked before and locks them.
+func (db *DB) repin() error {
+	db.pinlock.Lock()
+	idle := len(db.pinned) == 0 && len(db.pinnedRanges) == 0
+	db.pinlock.Unlock()
+	if idle {
+		return nil
+	}
+
+	return db.View(func(tx *Tx) error {
+		db.pinlock.Lock()
+		defer db.pinlock.Unlock()
+		if tx.meta.Txid() < db.pinnedTxid {
+			// A later transaction was already located.
+			return nil
+		}
+
+		var ranges []byteRange
+		for _, path := range db.pinned {
+			r, err := tx.bucketRanges(path)
+			if err != nil {
+				// The bucket was deleted, it has no pages to lock.
+				continue
+			}
+			ranges = append(ranges, r...)
+		}
+		ranges = mergeRanges(ranges)
+
+		if err := db.mlockRanges(ranges, db.pinnedRanges); err != nil {
+			return err
+		}
+		db.pinnedRanges = ranges
+		db.pinnedTxid = tx.meta.Txid()
+		return nil
+	})
+}
+
+// relockPinned locks the pages of the locked buckets in a new mmap.
+func (db *DB) relockPinned() error {
+	db.pinlock.Lock()
+	defer db.pinlock.Unlock()
+	if len(db.pinnedRanges) == 0 {
+		return nil
+	}
+	return db.mlockRanges(db.pinnedRanges, nil)
+}
+
+// mlockRanges unlocks the ranges of the mmap which were locked before and
+// locks the new ones, aligned to the OS pages.
+func (db *DB) mlockRanges(ranges, locked []byteRange) error {
+	db.genlock.RLock()
+	defer db.genlock.RUnlock()
+
+	osPageSize := int64(os.Getpagesize())
+	clamp := func(r byteRange) (int64, int64) {
+		start, end := r.start/osPageSize*osPageSize, r.end
+		if end > int64(db.datasz) {
+			end = int64(db.datasz)
+		}
+		return start, end
+	}
+
+	for _, r := range locked {
+		if start, end := clamp(r); start < end {
+			if err := munlockRange(db, start, end); err != nil {
+				return err
+			}
+		}
+	}
+
+	var n int64
+	for _, r := range ranges {
+		start, end := clamp(r)
+		if start >= end {
+			continue
+		}
+		if err := mlockRange(db, start, end); err != nil {
+			db.setMlockBytes(int(n))
+			return err
+		}
+		n += end - start
+	}
+	db.setMlockBytes(int(n))
+	return nil
+}
+
+// setMlockBytes records the number of bytes of the mmap locked in memory.
+func (db *DB) setMlockBytes(n int) {
+	db.statlock.Lock()
+	db.stats.MlockBytes = n
+	db.statlock.Unlock()
+}
+
+// mergeRanges sorts the ranges and merges the overlapping and adjacent ones.
+func mergeRanges(ranges []byteRange) []byteRange {
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].start < ranges[j].start })
+	var merged []byteRange
+	for _, r := range ranges {
+		if last := len(merged) - 1; last >= 0 && r.start <= merged[last].end {
+			if r.end > merged[last].end {
+				merged[last].end = r.end
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// indexOfPath returns the index of the given bucket path, or -1.
+func indexOfPath(paths [][][]byte, path [][]byte) int {
+	for i, p := range paths {
+		if len(p) != len(path) {
+			continue
+		}
+		equal := true
+		for j := range p {
+			if !bytes.Equal(p[j], path[j]) {
+				equal = false
+				break
+			}
+		}
+		if equal {
+			return i
+		}
+	}
+	return -1
+}
+
+// clonePath returns a copy of a bucket path which doesn't share memory with
+// the caller.
+func clonePath(path [][]byte) [][]byte {
+	c := make([][]byte, len(path))
+	for i, name := range path {
+		c[i] = cloneBytes(name)
+	}
+	return c
+}
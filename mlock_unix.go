@@ -35,3 +35,13 @@ func munlock(db *DB, fileSize int) error {
 	}
 	return nil
 }
+
+// mlockRange locks a range of the mmap in memory
+func mlockRange(db *DB, start, end int64) error {
+	return unix.Mlock(db.dataref[start:end])
+}
+
+// munlockRange unlocks a range of the mmap
+func munlockRange(db *DB, start, end int64) error {
+	return unix.Munlock(db.dataref[start:end])
+}
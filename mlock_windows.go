@@ -1,5 +1,7 @@
 package bbolt
 
+import "errors"
+
 // mlock locks memory of db file
 func mlock(_ *DB, _ int) error {
 	panic("mlock is supported only on UNIX systems")
@@ -9,3 +11,13 @@ func mlock(_ *DB, _ int) error {
 func munlock(_ *DB, _ int) error {
 	panic("munlock is supported only on UNIX systems")
 }
+
+// mlockRange locks a range of the mmap in memory
+func mlockRange(_ *DB, _, _ int64) error {
+	return errors.New("mlock is supported only on UNIX systems")
+}
+
+// munlockRange unlocks a range of the mmap
+func munlockRange(_ *DB, _, _ int64) error {
+	return errors.New("munlock is supported only on UNIX systems")
+}
@@ -0,0 +1,125 @@
+package bbolt
+
+import (
+	"fmt"
+	"runtime"
+	"unsafe"
+
+	"go.etcd.io/bbolt/internal/common"
+)
+
+// When a writable transaction grows the file past the end of the mmap, the
+// file is mapped again without unmapping it first, so the writer never
+// waits for the open transactions. Every transaction holds a reference to
+// the mapping it began on, and reads its pages from it, since all of them
+// are below the high water mark of its meta page. A mapping replaced by a
+// newer one is unmapped once the last transaction referencing it closes,
+// as the pages, keys and values returned by the transaction point into it.
+
+// mmapGen is a generation of the mmap of the data file.
+type mmapGen struct {
+	// `dataref` isn't used at all on Windows, and the golangci-lint
+	// always fails on Windows platform.
+	//nolint
+	dataref []byte // mmap'ed readonly, write throws SEGV
+	data    *[maxMapSize]byte
+	datasz  int
+	txN     int // number of open transactions which began on the mapping
+}
+
+// page retrieves a page reference from the mapping.
+func (g *mmapGen) page(id common.Pgid, pageSize int) *common.Page {
+	pos := id * common.Pgid(pageSize)
+	return (*common.Page)(unsafe.Pointer(&g.data[pos]))
+}
+
+// contains returns true if the given address is within the mapping.
+func (g *mmapGen) contains(addr uintptr) bool {
+	if g.data == nil {
+		return false
+	}
+	base := uintptr(unsafe.Pointer(&g.data[0]))
+	return addr >= base && addr < base+uintptr(g.datasz)
+}
+
+// setGen makes g the current mapping, and retires the previous one, which
+// is unmapped once no transaction references it. The caller must hold the
+// meta lock.
+func (db *DB) setGen(g *mmapGen) {
+	db.genlock.Lock()
+	defer db.genlock.Unlock()
+
+	if old := db.gen; old != nil {
+		if old.txN > 0 {
+			db.retired = append(db.retired, old)
+		} else {
+			db.unmapGen(old)
+		}
+	}
+
+	db.gen = g
+	db.dataref = g.dataref
+	db.data = g.data
+	db.datasz = g.datasz
+
+	// Save references to the meta pages.
+	db.meta0 = db.page(0).Meta()
+	db.meta1 = db.page(1).Meta()
+}
+
+// acquireGen returns the current mapping, referenced by a new transaction.
+// The caller must hold the meta lock.
+func (db *DB) acquireGen() *mmapGen {
+	g := db.gen
+	if g != nil {
+		g.txN++
+	}
+	return g
+}
+
+// releaseGen drops the reference of a closed transaction to its mapping,
+// and unmaps it if it was replaced by a newer one and isn't referenced
+// anymore. The caller must hold the meta lock.
+func (db *DB) releaseGen(g *mmapGen) {
+	if g == nil {
+		return
+	}
+	g.txN--
+	if g.txN > 0 || g == db.gen {
+		return
+	}
+
+	db.genlock.Lock()
+	defer db.genlock.Unlock()
+	for i, r := range db.retired {
+		if r == g {
+			db.retired = append(db.retired[:i], db.retired[i+1:]...)
+			db.unmapGen(g)
+			break
+		}
+	}
+}
+
+// unmapGen unmaps a mapping which isn't used anymore. The caller must hold
+// the gen lock.
+func (db *DB) unmapGen(g *mmapGen) {
+	if err := munmap(g); err != nil {
+		db.Logger().Errorf("[GOOS: %s, GOARCH: %s] munmap of a previous mapping failed, error: %v", runtime.GOOS, runtime.GOARCH, err)
+	}
+}
+
+// unmapRetired unmaps the retired mappings when the database is closed.
+// The caller must hold the meta lock.
+func (db *DB) unmapRetired() error {
+	db.genlock.Lock()
+	defer db.genlock.Unlock()
+
+	var err error
+	for _, g := range db.retired {
+		if unmapErr := munmap(g); unmapErr != nil && err == nil {
+			err = fmt.Errorf("unmap error: %w", unmapErr)
+		}
+	}
+	db.retired = nil
+	return err
+}
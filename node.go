@@ -458,38 +458,6 @@ func (n *node) removeChild(target *node) {
 	}
 }
 
-// dereference causes the node to copy all its inode key/value references to heap memory.
-// This is required when the mmap is reallocated so inodes are not pointing to stale data.
-func (n *node) dereference() {
-	if n.key != nil {
-		key := make([]byte, len(n.key))
-		copy(key, n.key)
-		n.key = key
-		common.Assert(n.pgid == 0 || len(n.key) > 0, "dereference: zero-length node key on existing node")
-	}
-
-	for i := range n.inodes {
-		inode := &n.inodes[i]
-
-		key := make([]byte, len(inode.Key()))
-		copy(key, inode.Key())
-		inode.SetKey(key)
-		common.Assert(len(inode.Key()) > 0, "dereference: zero-length inode key")
-
-		value := make([]byte, len(inode.Value()))
-		copy(value, inode.Value())
-		inode.SetValue(value)
-	}
-
-	// Recursively dereference children.
-	for _, child := range n.children {
-		child.dereference()
-	}
-
-	// Update statistics.
-	n.bucket.tx.stats.IncNodeDeref(1)
-}
-
 // free adds the node's underlying page to the freelist.
 func (n *node) free() {
 	if n.pgid != 0 {
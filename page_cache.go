@@ -0,0 +1,163 @@
+package bbolt
+
+import (
+	"container/list"
+	"sync"
+	"unsafe"
+
+	berrors "go.etcd.io/bbolt/errors"
+	"go.etcd.io/bbolt/internal/common"
+)
+
+// pageCache reads the pages of the file with pread() instead of memory
+// mapping it, when Options.NoMmap is set. The pages read last are kept in
+// a bounded LRU cache.
+//
+// A page in the file only changes when it's reallocated by a writable
+// transaction, which always writes it, so the cache only drops the pages
+// written by a commit. The pages handed out are never modified, and they
+// stay valid after they are evicted, as long as they are referenced.
+type pageCache struct {
+	db      *DB
+	maxSize int
+
+	mu    sync.Mutex
+	size  int                           // total size of the cached pages
+	lru   *list.List                    // cached pages, most recently used first
+	pages map[common.Pgid]*list.Element // cached pages by id
+	hitN  int                           // number of page reads served from the cache
+	missN int                           // number of page reads from the file
+}
+
+// cachedPage is a page held by the page cache.
+type cachedPage struct {
+	id  common.Pgid
+	buf []byte
+}
+
+func newPageCache(db *DB, maxSize int) *pageCache {
+	return &pageCache{
+		db:      db,
+		maxSize: maxSize,
+		lru:     list.New(),
+		pages:   make(map[common.Pgid]*list.Element),
+	}
+}
+
+// page returns the page with the given id, including its overflow pages,
+// reading it from the file if it isn't cached.
+func (c *pageCache) page(id common.Pgid) *common.Page {
+	c.mu.Lock()
+	if e, ok := c.pages[id]; ok {
+		c.lru.MoveToFront(e)
+		c.hitN++
+		c.mu.Unlock()
+		return (*common.Page)(unsafe.Pointer(&e.Value.(*cachedPage).buf[0]))
+	}
+	c.missN++
+	c.mu.Unlock()
+
+	buf := c.read(id, 1)
+	p := (*common.Page)(unsafe.Pointer(&buf[0]))
+	if p.Overflow() > 0 {
+		buf = c.read(id, int(p.Overflow())+1)
+		p = (*common.Page)(unsafe.Pointer(&buf[0]))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.pages[id]; !ok && len(buf) <= c.maxSize {
+		c.pages[id] = c.lru.PushFront(&cachedPage{id: id, buf: buf})
+		c.size += len(buf)
+		for c.size > c.maxSize {
+			c.remove(c.lru.Back())
+		}
+	}
+	return p
+}
+
+// read reads count pages starting at the given id from the file.
+func (c *pageCache) read(id common.Pgid, count int) []byte {
+	buf := make([]byte, count*c.db.pageSize)
+	if _, err := c.db.file.ReadAt(buf, int64(id)*int64(c.db.pageSize)); err != nil {
+		// The page accessors can't return errors, so the managed
+		// transactions and Tx.Check recover it, see ioErrorFrom.
+		panic(&berrors.IOError{Pgid: uint64(id), Err: err})
+	}
+	return buf
+}
+
+// invalidate drops the given pages from the cache, once they are written.
+func (c *pageCache) invalidate(ids ...common.Pgid) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, id := range ids {
+		if e, ok := c.pages[id]; ok {
+			c.remove(e)
+		}
+	}
+}
+
+func (c *pageCache) remove(e *list.Element) {
+	cp := c.lru.Remove(e).(*cachedPage)
+	delete(c.pages, cp.id)
+	c.size -= len(cp.buf)
+}
+
+// stats adds the statistics of the cache to s.
+func (c *pageCache) stats(s *Stats) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s.PageCacheHitN = c.hitN
+	s.PageCacheMissN = c.missN
+	s.PageCacheSize = c.size
+}
+
+// resize records the size of the file without mapping it, and reads the
+// meta pages when the database is opened.
+func (c *pageCache) resize(minsz int) error {
+	db := c.db
+	fileSize, err := db.fileSize()
+	if err != nil {
+		return err
+	}
+	size := fileSize
+	if size < minsz {
+		size = minsz
+	}
+	if size, err = db.mmapSize(size); err != nil {
+		return err
+	}
+	db.datasz = size
+
+	if db.meta0 != nil {
+		return nil
+	}
+	buf := make([]byte, 2*db.pageSize)
+	if _, err := db.file.ReadAt(buf, 0); err != nil {
+		return err
+	}
+	db.meta0 = db.pageInBuffer(buf, 0).Meta()
+	db.meta1 = db.pageInBuffer(buf, 1).Meta()
+
+	err0 := db.meta0.Validate()
+	err1 := db.meta1.Validate()
+	if err0 != nil && err1 != nil {
+		db.Logger().Errorf("both meta pages are invalid, meta0: %v, meta1: %v", err0, err1)
+		return err0
+	}
+	return nil
+}
+
+// metaWritten updates the meta page read by Open once it's written to the
+// file, as the mmap does.
+func (c *pageCache) metaWritten(p *common.Page) {
+	c.db.metalock.Lock()
+	defer c.db.metalock.Unlock()
+	m := c.db.meta0
+	if p.Id() == 1 {
+		m = c.db.meta1
+	}
+	p.Meta().Copy(m)
+	c.invalidate(p.Id())
+}
@@ -0,0 +1,102 @@
+package bbolt_test
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "go.etcd.io/bbolt"
+	berrors "go.etcd.io/bbolt/errors"
+	"go.etcd.io/bbolt/internal/btesting"
+)
+
+func TestOptions_NoMmap(t *testing.T) {
+	const cacheSize = 64 * 1024
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{NoMmap: true, PageCacheSize: cacheSize})
+
+	// Read concurrently while the file grows.
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				require.NoError(t, db.View(func(tx *bolt.Tx) error {
+					b := tx.Bucket([]byte("widgets"))
+					if b == nil {
+						return nil
+					}
+					return b.ForEach(func(k, v []byte) error {
+						if len(v) != 100 && len(v) != 10000 {
+							return fmt.Errorf("unexpected value size %d", len(v))
+						}
+						return nil
+					})
+				}))
+			}
+		}()
+	}
+	fillWidgets(t, db)
+	close(stop)
+	wg.Wait()
+	db.MustCheck()
+
+	stats := db.Stats()
+	require.Positive(t, stats.PageCacheHitN)
+	require.Positive(t, stats.PageCacheMissN)
+	require.Positive(t, stats.PageCacheSize)
+	require.LessOrEqual(t, stats.PageCacheSize, cacheSize)
+
+	// The file can be opened with and without the mmap.
+	for _, noMmap := range []bool{true, false} {
+		db.MustClose()
+		db.SetOptions(&bolt.Options{NoMmap: noMmap})
+		db.MustReopen()
+		db.MustCheck()
+		require.NoError(t, db.View(func(tx *bolt.Tx) error {
+			require.Equal(t, 2000, tx.Bucket([]byte("widgets")).Stats().KeyN)
+			return nil
+		}))
+	}
+}
+
+func TestOptions_NoMmap_ReadError(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	fillWidgets(t, db)
+	db.MustClose()
+
+	rdb, err := bolt.Open(db.Path(), 0600, &bolt.Options{NoMmap: true, ReadOnly: true})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, rdb.Close()) }()
+
+	// Read past the end of the file, as if reading the pages failed.
+	require.NoError(t, os.Truncate(db.Path(), 4*int64(rdb.Info().PageSize)))
+
+	err = rdb.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("widgets")).ForEach(func(k, v []byte) error {
+			return nil
+		})
+	})
+	require.ErrorIs(t, err, io.EOF)
+	require.ErrorIs(t, err, berrors.ErrIO)
+
+	require.NoError(t, rdb.View(func(tx *bolt.Tx) error {
+		var errs []error
+		for err := range tx.Check() {
+			errs = append(errs, err)
+		}
+		require.NotEmpty(t, errs)
+		require.ErrorIs(t, errs[len(errs)-1], io.EOF)
+		return nil
+	}))
+}
@@ -0,0 +1,121 @@
+package bbolt
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// ProgressUnit is the unit of the work done by a long running operation.
+type ProgressUnit int
+
+const (
+	// ProgressPages counts the pages processed.
+	ProgressPages ProgressUnit = iota
+	// ProgressBytes counts the bytes processed.
+	ProgressBytes
+)
+
+func (u ProgressUnit) String() string {
+	if u == ProgressBytes {
+		return "bytes"
+	}
+	return "pages"
+}
+
+// Progress is the progress of a long running operation, like Tx.Check,
+// Compact or Tx.WriteToContext.
+type Progress struct {
+	// Unit is the unit of Done and Total.
+	Unit ProgressUnit
+
+	// Done is the amount of work done so far.
+	Done int64
+
+	// Total is an estimate of the amount of work of the whole operation,
+	// or 0 if it's unknown. Done may end below or above it.
+	Total int64
+
+	// Bucket is the path of the bucket being processed, or nil if it's
+	// unknown. It's only valid during the call.
+	Bucket [][]byte
+}
+
+// ProgressFunc is called periodically with the progress of an operation,
+// and once more when it completes. It's called from the goroutine running
+// the operation, which it must not block for long.
+type ProgressFunc func(Progress)
+
+// progressInterval is the minimum interval between two reports of the
+// progress of an operation.
+const progressInterval = 100 * time.Millisecond
+
+// progressTracker counts the work done by an operation, reports it to a
+// ProgressFunc and tells whether the operation was canceled.
+type progressTracker struct {
+	ctx      context.Context
+	fn       ProgressFunc
+	progress Progress
+	last     time.Time
+}
+
+func newProgressTracker(ctx context.Context, fn ProgressFunc, unit ProgressUnit, total int64) *progressTracker {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return &progressTracker{
+		ctx:      ctx,
+		fn:       fn,
+		progress: Progress{Unit: unit, Total: total},
+		last:     time.Now(),
+	}
+}
+
+// add records n more units of work done in the given bucket, and returns
+// the error of the context if the operation was canceled.
+func (t *progressTracker) add(n int64, bucket [][]byte) error {
+	t.progress.Done += n
+	if err := t.ctx.Err(); err != nil {
+		return err
+	}
+	if t.fn != nil {
+		if now := time.Now(); now.Sub(t.last) >= progressInterval {
+			t.last = now
+			t.progress.Bucket = bucket
+			t.fn(t.progress)
+			t.progress.Bucket = nil
+		}
+	}
+	return nil
+}
+
+// finish reports the progress of the completed operation.
+func (t *progressTracker) finish() {
+	if t.fn != nil {
+		t.fn(t.progress)
+	}
+}
+
+// tracked returns true if the operation reports its progress, or may be
+// canceled.
+func (t *progressTracker) tracked() bool {
+	return t.fn != nil || t.ctx.Done() != nil
+}
+
+// progressWriter counts the bytes written to w, and fails the writes once
+// the operation is canceled.
+type progressWriter struct {
+	w io.Writer
+	t *progressTracker
+}
+
+func (pw *progressWriter) Write(p []byte) (int, error) {
+	if err := pw.t.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := pw.w.Write(p)
+	if tErr := pw.t.add(int64(n), nil); err == nil {
+		err = tErr
+	}
+	return n, err
+}
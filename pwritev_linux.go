@@ -0,0 +1,46 @@
+package bbolt
+
+import (
+	"io"
+
+	"golang.org/x/sys/unix"
+)
+
+// maxIovecs is the maximum number of buffers passed to a single pwritev().
+const maxIovecs = 1024
+
+// pwritev writes the buffers to the database file at the given offset with
+// as few pwritev() calls as possible. It returns the number of syscalls made.
+// The buffers are coalesced into a single DB.ops.writeAt() call instead if
+// it's hooked.
+func pwritev(db *DB, bufs [][]byte, offset int64) (int, error) {
+	if !db.writesToFile() {
+		return writeCoalesced(db, bufs, offset)
+	}
+	return pwritevFd(int(db.file.Fd()), bufs, offset)
+}
+
+// pwritevFd writes the buffers to the file descriptor fd at the given offset.
+func pwritevFd(fd int, bufs [][]byte, offset int64) (int, error) {
+	var calls int
+	for len(bufs) > 0 {
+		n, err := unix.Pwritev(fd, bufs, offset)
+		calls++
+		if err != nil {
+			return calls, err
+		} else if n == 0 {
+			return calls, io.ErrShortWrite
+		}
+		offset += int64(n)
+
+		// Skip the written buffers and continue after a short write.
+		for len(bufs) > 0 && n >= len(bufs[0]) {
+			n -= len(bufs[0])
+			bufs = bufs[1:]
+		}
+		if len(bufs) > 0 {
+			bufs[0] = bufs[0][n:]
+		}
+	}
+	return calls, nil
+}
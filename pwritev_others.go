@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package bbolt
+
+// maxIovecs is the maximum number of buffers coalesced into a single write.
+const maxIovecs = 1024
+
+// pwritev copies the buffers into a single one and writes it to the database
+// file at the given offset. It returns the number of syscalls made.
+func pwritev(db *DB, bufs [][]byte, offset int64) (int, error) {
+	return writeCoalesced(db, bufs, offset)
+}
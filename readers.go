@@ -0,0 +1,129 @@
+package bbolt
+
+import (
+	"runtime"
+	"sort"
+	"time"
+
+	"go.etcd.io/bbolt/internal/common"
+)
+
+// ReaderWarnThreshold configures when long-running read-only transactions
+// are reported. A zero value disables the corresponding check.
+//
+// The thresholds are evaluated whenever a read-write transaction begins,
+// which is also when pending pages get released. Each read-only transaction
+// is reported at most once.
+type ReaderWarnThreshold struct {
+	// Duration reports read-only transactions which have been open for
+	// longer than the given duration.
+	Duration time.Duration
+
+	// PendingPageN reports read-only transactions which prevent more than
+	// the given number of pending pages from being released.
+	PendingPageN int
+
+	// CaptureStack records the stack of the goroutine starting each
+	// read-only transaction, so that it can be reported.
+	CaptureStack bool
+
+	// Callback is called for each reported transaction, in addition to
+	// logging a warning via the Logger. It is called while the database
+	// locks are held, so it must not block or start a transaction.
+	Callback func(ReadTxInfo)
+}
+
+// ReadTxInfo describes an open read-only transaction.
+type ReadTxInfo struct {
+	Txid      int       // id of the transaction
+	StartTime time.Time // time the transaction began
+
+	// Stack of the goroutine which began the transaction. It is only
+	// recorded when ReaderWarnThreshold.CaptureStack is set.
+	Stack string
+
+	// PendingPageN is the number of pending pages which can't be released
+	// while the transaction is open, as of the last time a read-write
+	// transaction began.
+	PendingPageN int
+}
+
+// OpenReadTxs returns information about all currently open read-only
+// transactions, ordered by transaction id.
+//
+// Calling it from within a read-only transaction may deadlock with a writer
+// which needs to remap the database, see DB.Begin.
+func (db *DB) OpenReadTxs() []ReadTxInfo {
+	db.metalock.Lock()
+	defer db.metalock.Unlock()
+
+	infos := make([]ReadTxInfo, 0, len(db.txs))
+	for _, t := range db.txs {
+		infos = append(infos, t.readTxInfo())
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Txid < infos[j].Txid })
+	return infos
+}
+
+func (tx *Tx) readTxInfo() ReadTxInfo {
+	return ReadTxInfo{
+		Txid:         int(tx.meta.Txid()),
+		StartTime:    tx.startTime,
+		Stack:        tx.stack,
+		PendingPageN: tx.pinnedPageN,
+	}
+}
+
+// trackReader records the details of a new read-only transaction.
+func (db *DB) trackReader(tx *Tx) {
+	tx.startTime = time.Now()
+	if db.readerWarn.CaptureStack {
+		buf := make([]byte, 4096)
+		tx.stack = string(buf[:runtime.Stack(buf, false)])
+	}
+}
+
+// checkReaders updates the number of pending pages pinned by each open
+// read-only transaction and reports the ones exceeding the configured
+// thresholds. The caller must hold both the writer and the meta lock.
+func (db *DB) checkReaders() {
+	if len(db.txs) == 0 {
+		return
+	}
+
+	pending := db.freelist.PendingCountByTxid()
+	now := time.Now()
+	for _, t := range db.txs {
+		t.pinnedPageN = pinnedPendingPageN(pending, t.meta.Txid())
+		if t.readerWarned {
+			continue
+		}
+
+		tooLong := db.readerWarn.Duration > 0 && now.Sub(t.startTime) > db.readerWarn.Duration
+		tooMuch := db.readerWarn.PendingPageN > 0 && t.pinnedPageN > db.readerWarn.PendingPageN
+		if !tooLong && !tooMuch {
+			continue
+		}
+		t.readerWarned = true
+
+		info := t.readTxInfo()
+		db.Logger().Warningf("read-only transaction %d has been open for %s and pins %d pending pages", info.Txid, now.Sub(info.StartTime), info.PendingPageN)
+		if db.readerWarn.Callback != nil {
+			db.readerWarn.Callback(info)
+		}
+	}
+}
+
+// pinnedPendingPageN returns the number of pending pages which can't be
+// released while a read-only transaction with the given id is open.
+func pinnedPendingPageN(pending map[common.Txid]int, txid common.Txid) int {
+	// Pages freed by a transaction with an id not lower than the reader's
+	// can't be released until the reader closes.
+	var n int
+	for tid, cnt := range pending {
+		if tid >= txid {
+			n += cnt
+		}
+	}
+	return n
+}
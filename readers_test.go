@@ -0,0 +1,104 @@
+package bbolt_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "go.etcd.io/bbolt"
+	"go.etcd.io/bbolt/internal/btesting"
+)
+
+func TestDB_OpenReadTxs(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{
+		ReaderWarnThreshold: bolt.ReaderWarnThreshold{CaptureStack: true},
+	})
+	require.Empty(t, db.OpenReadTxs())
+
+	before := time.Now()
+	tx1, err := db.Begin(false)
+	require.NoError(t, err)
+	tx2, err := db.Begin(false)
+	require.NoError(t, err)
+
+	infos := db.OpenReadTxs()
+	require.Len(t, infos, 2)
+	for _, info := range infos {
+		require.Equal(t, tx1.ID(), info.Txid)
+		require.False(t, info.StartTime.Before(before))
+		require.Contains(t, info.Stack, "TestDB_OpenReadTxs")
+	}
+
+	require.NoError(t, tx1.Rollback())
+	require.Len(t, db.OpenReadTxs(), 1)
+	require.NoError(t, tx2.Rollback())
+	require.Empty(t, db.OpenReadTxs())
+}
+
+func TestOptions_ReaderWarnThreshold(t *testing.T) {
+	t.Run("pending pages", func(t *testing.T) {
+		var reported []bolt.ReadTxInfo
+		db := btesting.MustCreateDBWithOption(t, &bolt.Options{
+			InitialMmapSize: 1 << 24,
+			ReaderWarnThreshold: bolt.ReaderWarnThreshold{
+				PendingPageN: 2,
+				Callback:     func(info bolt.ReadTxInfo) { reported = append(reported, info) },
+			},
+		})
+		err := db.Fill([]byte("data"), 1, 200,
+			func(tx int, k int) []byte { return []byte(fmt.Sprintf("%04d", k)) },
+			func(tx int, k int) []byte { return make([]byte, 100) },
+		)
+		require.NoError(t, err)
+
+		rtx, err := db.Begin(false)
+		require.NoError(t, err)
+		defer func() { _ = rtx.Rollback() }()
+
+		// Rewriting all the keys frees every page seen by the reader.
+		err = db.Fill([]byte("data"), 1, 200,
+			func(tx int, k int) []byte { return []byte(fmt.Sprintf("%04d", k)) },
+			func(tx int, k int) []byte { return make([]byte, 50) },
+		)
+		require.NoError(t, err)
+		require.Empty(t, reported)
+
+		// The readers are checked when the next writer begins.
+		require.NoError(t, db.Update(func(tx *bolt.Tx) error { return nil }))
+		require.Len(t, reported, 1)
+		require.Equal(t, rtx.ID(), reported[0].Txid)
+		require.Greater(t, reported[0].PendingPageN, 2)
+
+		infos := db.OpenReadTxs()
+		require.Len(t, infos, 1)
+		require.Equal(t, reported[0].PendingPageN, infos[0].PendingPageN)
+
+		// A reader is reported only once.
+		require.NoError(t, db.Update(func(tx *bolt.Tx) error { return nil }))
+		require.Len(t, reported, 1)
+	})
+
+	t.Run("duration", func(t *testing.T) {
+		var reported []bolt.ReadTxInfo
+		db := btesting.MustCreateDBWithOption(t, &bolt.Options{
+			InitialMmapSize: 1 << 24,
+			ReaderWarnThreshold: bolt.ReaderWarnThreshold{
+				Duration: 10 * time.Millisecond,
+				Callback: func(info bolt.ReadTxInfo) { reported = append(reported, info) },
+			},
+		})
+
+		rtx, err := db.Begin(false)
+		require.NoError(t, err)
+		defer func() { _ = rtx.Rollback() }()
+		require.NoError(t, db.Update(func(tx *bolt.Tx) error { return nil }))
+		require.Empty(t, reported)
+
+		time.Sleep(20 * time.Millisecond)
+		require.NoError(t, db.Update(func(tx *bolt.Tx) error { return nil }))
+		require.Len(t, reported, 1)
+		require.Equal(t, rtx.ID(), reported[0].Txid)
+	})
+}
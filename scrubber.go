@@ -0,0 +1,396 @@
+package bbolt
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	berrors "go.etcd.io/bbolt/errors"
+	"go.etcd.io/bbolt/internal/common"
+	fl "go.etcd.io/bbolt/internal/freelist"
+)
+
+// ScrubOptions represents the options of DB.StartScrubber.
+type ScrubOptions struct {
+	// Interval is the pause between the end of a pass over the database and
+	// the start of the next one. DefaultScrubInterval is used if it's zero.
+	Interval time.Duration
+
+	// PagesPerTx is the number of pages checked by each read transaction,
+	// so that the pages freed by the writers meanwhile don't stay pending
+	// for long. A subtree whose children are leaf pages is checked by a
+	// single transaction, which may exceed it. DefaultScrubPagesPerTx is
+	// used if it's zero.
+	PagesPerTx int
+
+	// Rate is the maximum number of bytes of pages read per second, it
+	// isn't limited if it's zero.
+	Rate int64
+
+	// OnError is called from the scrubber goroutine with each inconsistency
+	// found, a *errors.CheckError, or an error reading a page. It must not
+	// close the DB nor call DB.StopScrubber, which waits for the scrubber
+	// goroutine.
+	OnError func(err error)
+}
+
+// StartScrubber starts a goroutine which checks the database continuously,
+// so that its inconsistencies are found before a read hits them. It checks
+// the pages of the trees as Tx.Check does, a few subtrees at a time in
+// short read transactions, throttled to ScrubOptions.Rate. The pages which
+// are neither reachable nor freed, which can only be found by checking the
+// whole database in one transaction, aren't reported.
+//
+// The inconsistencies are logged and passed to ScrubOptions.OnError, and
+// counted in Stats. The scrubber runs until StopScrubber or Close is
+// called, or a transaction can't be started.
+func (db *DB) StartScrubber(opts ScrubOptions) error {
+	if opts.Interval <= 0 {
+		opts.Interval = common.DefaultScrubInterval
+	}
+	if opts.PagesPerTx <= 0 {
+		opts.PagesPerTx = common.DefaultScrubPagesPerTx
+	}
+
+	db.scrublock.Lock()
+	defer db.scrublock.Unlock()
+	if db.scrubDone != nil {
+		select {
+		case <-db.scrubDone:
+		default:
+			return berrors.ErrScrubberRunning
+		}
+	}
+	db.metalock.Lock()
+	opened := db.opened
+	db.metalock.Unlock()
+	if !opened {
+		return berrors.ErrDatabaseNotOpen
+	}
+
+	db.scrubStop = make(chan struct{})
+	db.scrubDone = make(chan struct{})
+	go db.scrub(opts, db.scrubStop, db.scrubDone)
+	return nil
+}
+
+// StopScrubber stops the scrubber started by StartScrubber, once its current
+// transaction is closed. It's a no-op if no scrubber was started.
+func (db *DB) StopScrubber() {
+	db.scrublock.Lock()
+	defer db.scrublock.Unlock()
+	if db.scrubStop == nil {
+		return
+	}
+	close(db.scrubStop)
+	<-db.scrubDone
+	db.scrubStop, db.scrubDone = nil, nil
+}
+
+// scrub runs the passes of the scrubber until it's stopped.
+func (db *DB) scrub(opts ScrubOptions, stop, done chan struct{}) {
+	defer close(done)
+	lg := db.Logger()
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-timer.C:
+		}
+
+		pageN, errN, err := db.scrubPass(opts, stop)
+		if errors.Is(err, errScrubStopped) {
+			return
+		} else if err != nil {
+			lg.Errorf("scrubber stopped: %v", err)
+			return
+		}
+		lg.Infof("scrubbed %d pages, found %d inconsistencies", pageN, errN)
+		timer.Reset(opts.Interval)
+	}
+}
+
+// errScrubStopped is returned by scrubPass when the scrubber is stopped.
+var errScrubStopped = errors.New("scrubber stopped")
+
+// errScrubRetry is returned by scrubStep when the free pages aren't known
+// yet, see scrubFreeSpans.
+var errScrubRetry = errors.New("scrubber step retried")
+
+// scrubRetryDelay is the pause before a step is retried.
+const scrubRetryDelay = 10 * time.Millisecond
+
+// scrubPass checks all the trees of the database once, and returns the
+// number of pages checked and of inconsistencies found.
+func (db *DB) scrubPass(opts ScrubOptions, stop chan struct{}) (int, int, error) {
+	var pageN, errN int
+	queue := []scrubUnit{{}}
+	for len(queue) > 0 {
+		select {
+		case <-stop:
+			return pageN, errN, errScrubStopped
+		default:
+		}
+
+		start := time.Now()
+		n, errs, err := db.scrubStep(&queue, opts.PagesPerTx)
+		if errors.Is(err, errScrubRetry) {
+			select {
+			case <-stop:
+				return pageN, errN, errScrubStopped
+			case <-time.After(scrubRetryDelay):
+			}
+			continue
+		} else if err != nil {
+			return pageN, errN, err
+		}
+		for _, err := range errs {
+			db.reportScrubError(err, opts.OnError)
+		}
+		pageN += n
+		errN += len(errs)
+		db.statlock.Lock()
+		db.stats.ScrubPageN += n
+		db.stats.ScrubErrorN += len(errs)
+		db.statlock.Unlock()
+
+		// Pause until the pages read fit in the rate.
+		if opts.Rate > 0 {
+			d := time.Duration(float64(n) * float64(db.pageSize) / float64(opts.Rate) * float64(time.Second))
+			if wait := d - time.Since(start); wait > 0 {
+				select {
+				case <-stop:
+					return pageN, errN, errScrubStopped
+				case <-time.After(wait):
+				}
+			}
+		}
+	}
+
+	db.statlock.Lock()
+	db.stats.ScrubPassN++
+	db.stats.LastScrubTime = time.Now()
+	db.stats.LastScrubErrorN = errN
+	db.statlock.Unlock()
+	return pageN, errN, nil
+}
+
+// scrubStep checks the units at the head of the queue in a read transaction,
+// until about budget pages are checked, and queues the units they lead to.
+// It returns the number of pages checked and the inconsistencies found.
+func (db *DB) scrubStep(queue *[]scrubUnit, budget int) (int, []error, error) {
+	tx, err := db.Begin(false)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+	db.loadFreelist()
+	free, ok := db.scrubFreeSpans(tx)
+	if !ok {
+		return 0, nil, errScrubRetry
+	}
+
+	var pageN int
+	errs := collectErrors(func(ch chan error) {
+		// Report a page which can't be read as an inconsistency.
+		var err error
+		defer func() {
+			if err != nil {
+				ch <- err
+			}
+		}()
+		if db.catchesIOErrors() {
+			defer db.recoverIOError(&err, db.guardFaults())
+		}
+		for len(*queue) > 0 && pageN < budget {
+			u := (*queue)[0]
+			*queue = (*queue)[1:]
+			n, units := tx.scrub(u, free, ch)
+			pageN += n
+			*queue = append(*queue, units...)
+		}
+	})
+	return pageN, errs, nil
+}
+
+// scrubFree are the free spans saved for the scrubber by the last writable
+// transaction, once the scrubber asked for them.
+type scrubFree struct {
+	wanted bool
+	saved  bool
+	txid   common.Txid // latest committed transaction when they were saved
+	spans  []fl.Span
+}
+
+// scrubFreeSpans returns the free spans while the transaction is open. The
+// pages freed after it began stay pending, so the free pages can't be
+// reachable from it, unless a writer allocated them before it began. The
+// freelist is read if no writer is modifying it, or else the spans saved by
+// the last writer are used if it committed the transaction. Otherwise, the
+// next writer is asked to save them, and it returns false.
+func (db *DB) scrubFreeSpans(tx *Tx) ([]fl.Span, bool) {
+	db.metalock.Lock()
+	defer db.metalock.Unlock()
+	if db.rwtx == nil {
+		return db.freelist.FreeSpans(), true
+	}
+	if f := db.scrubFree; f.saved && f.txid >= tx.meta.Txid() {
+		return f.spans, true
+	}
+	db.scrubFree.wanted = true
+	return nil, false
+}
+
+// saveScrubFree saves the free spans for the scrubber if it asked for them.
+// It's called at the end of a writable transaction, with the meta lock.
+func (db *DB) saveScrubFree() {
+	if !db.scrubFree.wanted {
+		return
+	}
+	db.scrubFree = scrubFree{saved: true, txid: db.writerMeta().Txid(), spans: db.freelist.FreeSpans()}
+}
+
+// reportScrubError logs an inconsistency found by the scrubber and passes it
+// to the callback.
+func (db *DB) reportScrubError(err error, fn func(error)) {
+	var cerr *berrors.CheckError
+	if errors.As(err, &cerr) && cerr.Severity == berrors.SeverityWarning {
+		db.Logger().Warningf("scrubber found an inconsistency: %v", err)
+	} else {
+		db.Logger().Errorf("scrubber found an inconsistency: %v", err)
+	}
+	if fn != nil {
+		fn(err)
+	}
+}
+
+// scrubUnit is a subtree checked by the scrubber: the page at the given depth
+// of the tree of the bucket at the given path, on the way to the given key.
+// The writers move the pages, so the page is looked up again by each
+// transaction.
+type scrubUnit struct {
+	path  [][]byte
+	key   []byte
+	depth int
+}
+
+// scrub checks the subtree of the unit, and returns the number of pages
+// checked and the units left to check: the children of a page too high in
+// the tree to be checked at once, and the nested buckets.
+func (tx *Tx) scrub(u scrubUnit, free []fl.Span, ch chan error) (int, []scrubUnit) {
+	// The bucket may have been deleted meanwhile.
+	b := &tx.root
+	for _, name := range u.path {
+		if b = b.Bucket(name); b == nil {
+			return 0, nil
+		}
+	}
+	// Ignore inline buckets.
+	if b.RootPage() == 0 {
+		return 0, nil
+	}
+
+	// Look up the root page of the subtree, and the range of its keys.
+	hwm := tx.meta.Pgid()
+	keyToString := HexKVStringer().KeyToString
+	stack := []common.Pgid{b.RootPage()}
+	var minKey, maxKey []byte
+	depth := 0
+	for ; depth < u.depth; depth++ {
+		p := tx.page(stack[len(stack)-1])
+		if !p.IsBranchPage() || p.Count() == 0 {
+			break
+		}
+		i := scrubChild(p, u.key)
+		elem := p.BranchPageElement(uint16(i))
+		minKey = elem.Key()
+		if i+1 < int(p.Count()) {
+			maxKey = p.BranchPageElement(uint16(i + 1)).Key()
+		}
+		if elem.Pgid() >= hwm {
+			ch <- newCheckError(berrors.ErrOutOfBounds, elem.Pgid(), stack, u.path, "page %d: out of bounds: %d (stack: %v)", int(elem.Pgid()), int(hwm), stack)
+			return 1, nil
+		}
+		stack = append(stack, elem.Pgid())
+	}
+
+	reachable := make(map[common.Pgid]bool)
+	freed := make(map[common.Pgid]bool)
+	markFreed := func(p *common.Page) {
+		if scrubIsFree(free, p.Id()) {
+			freed[p.Id()] = true
+		}
+	}
+
+	// Check a page whose children are branch pages alone, and queue its
+	// children.
+	pgId := stack[len(stack)-1]
+	p := tx.page(pgId)
+	if p.IsBranchPage() && p.Count() > 0 {
+		if first := p.BranchPageElement(0).Pgid(); first < hwm && tx.page(first).IsBranchPage() {
+			markFreed(p)
+			verifyPageReachable(p, hwm, stack, u.path, reachable, freed, ch)
+			var units []scrubUnit
+			prev := minKey
+			for i := range p.BranchPageElements() {
+				elem := p.BranchPageElement(uint16(i))
+				verifyKeyOrder(pgId, "branch", i, elem.Key(), prev, maxKey, ch, keyToString, stack, u.path)
+				prev = elem.Key()
+				if elem.Pgid() >= hwm {
+					ch <- newCheckError(berrors.ErrOutOfBounds, elem.Pgid(), stack, u.path, "page %d: out of bounds: %d (stack: %v)", int(elem.Pgid()), int(hwm), stack)
+					continue
+				}
+				units = append(units, scrubUnit{path: u.path, key: cloneBytes(elem.Key()), depth: depth + 1})
+			}
+			return int(p.Overflow()) + 1, units
+		}
+	}
+
+	// Check the whole subtree, and queue its nested buckets.
+	var pageN int
+	var units []scrubUnit
+	parents := stack[:len(stack)-1]
+	tx.forEachPage(pgId, func(p *common.Page, _ int, s []common.Pgid) {
+		markFreed(p)
+		verifyPageReachable(p, hwm, append(parents[:len(parents):len(parents)], s...), u.path, reachable, freed, ch)
+		pageN += int(p.Overflow()) + 1
+		if !p.IsLeafPage() {
+			return
+		}
+		for i := range p.LeafPageElements() {
+			elem := p.LeafPageElement(uint16(i))
+			if elem.IsBucketEntry() && elem.Bucket().RootPage() != 0 {
+				units = append(units, scrubUnit{path: appendPath(clonePath(u.path), cloneBytes(elem.Key()))})
+			}
+		}
+	})
+	tx.recursivelyCheckPageKeyOrderInternal(pgId, minKey, maxKey, parents, u.path, keyToString, ch)
+	return pageN, units
+}
+
+// scrubChild returns the index of the child of the branch page on the way to
+// the key, the first one if the key is nil.
+func scrubChild(p *common.Page, key []byte) int {
+	i := 0
+	if key == nil {
+		return i
+	}
+	for j := 1; j < int(p.Count()); j++ {
+		if compareKeys(p.BranchPageElement(uint16(j)).Key(), key) > 0 {
+			break
+		}
+		i = j
+	}
+	return i
+}
+
+// scrubIsFree returns true if the page is in one of the free spans, which are
+// ordered by their first page.
+func scrubIsFree(free []fl.Span, id common.Pgid) bool {
+	i := sort.Search(len(free), func(i int) bool {
+		return free[i].Start+common.Pgid(free[i].Size) > id
+	})
+	return i < len(free) && free[i].Start <= id
+}
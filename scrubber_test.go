@@ -0,0 +1,150 @@
+package bbolt_test
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "go.etcd.io/bbolt"
+	berrors "go.etcd.io/bbolt/errors"
+	"go.etcd.io/bbolt/internal/btesting"
+)
+
+func TestDB_StartScrubber(t *testing.T) {
+	// Small pages make a deep tree, with nested buckets.
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{PageSize: 1024})
+	require.NoError(t, db.Fill([]byte("data"), 1, 5000,
+		func(tx int, k int) []byte { return []byte(fmt.Sprintf("%05d", k)) },
+		func(tx int, k int) []byte { return make([]byte, 100) },
+	))
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.Bucket([]byte("data")).CreateBucket([]byte("nested"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 100; i++ {
+			if err := b.Put([]byte(fmt.Sprintf("%03d", i)), make([]byte, 100)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+
+	var mu sync.Mutex
+	var errs []error
+	require.NoError(t, db.StartScrubber(bolt.ScrubOptions{
+		Interval:   time.Millisecond,
+		PagesPerTx: 16,
+		OnError: func(err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			errs = append(errs, err)
+		},
+	}))
+	require.ErrorIs(t, db.StartScrubber(bolt.ScrubOptions{}), berrors.ErrScrubberRunning)
+
+	// The writers move the pages while they're scrubbed.
+	for i := 0; db.Stats().ScrubPassN < 3; i++ {
+		require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+			b := tx.Bucket([]byte("data"))
+			if err := b.Put([]byte(fmt.Sprintf("%05d", i%5000)), make([]byte, 200)); err != nil {
+				return err
+			}
+			return b.Delete([]byte(fmt.Sprintf("%05d", (i+2500)%5000)))
+		}))
+	}
+	db.StopScrubber()
+
+	stats := db.Stats()
+	mu.Lock()
+	require.Empty(t, errs)
+	mu.Unlock()
+	require.Zero(t, stats.ScrubErrorN)
+	require.Zero(t, stats.LastScrubErrorN)
+	// A pass checks every page of the trees.
+	require.Greater(t, stats.ScrubPageN, 3*5000*100/1024)
+	require.False(t, stats.LastScrubTime.IsZero())
+
+	// The scrubber can be started again once stopped.
+	require.NoError(t, db.StartScrubber(bolt.ScrubOptions{}))
+}
+
+// Ensure that the scrubber doesn't wait for a long writable transaction.
+func TestDB_StartScrubber_LongUpdate(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.Fill([]byte("data"), 1, 1000,
+		func(tx int, k int) []byte { return []byte(fmt.Sprintf("%04d", k)) },
+		func(tx int, k int) []byte { return make([]byte, 100) },
+	))
+
+	tx, err := db.Begin(true)
+	require.NoError(t, err)
+	require.NoError(t, db.StartScrubber(bolt.ScrubOptions{Interval: time.Millisecond, PagesPerTx: 4}))
+	time.Sleep(50 * time.Millisecond)
+
+	// The scrubber stops while the writer is still running.
+	stopped := make(chan struct{})
+	go func() {
+		db.StopScrubber()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the scrubber waited for the writable transaction")
+	}
+	require.NoError(t, tx.Bucket([]byte("data")).Put([]byte("foo"), []byte("bar")))
+	require.NoError(t, tx.Commit())
+
+	// The free pages saved by the writer let it go on.
+	require.NoError(t, db.StartScrubber(bolt.ScrubOptions{Interval: time.Millisecond, PagesPerTx: 4}))
+	require.Eventually(t, func() bool {
+		return db.Stats().ScrubPassN > 0
+	}, 10*time.Second, time.Millisecond)
+	db.StopScrubber()
+	require.Zero(t, db.Stats().ScrubErrorN)
+}
+
+func TestDB_StartScrubber_Corruption(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{PageSize: 4096})
+	require.NoError(t, db.Fill([]byte("data"), 1, 100,
+		func(tx int, k int) []byte { return []byte(fmt.Sprintf("%04d", k)) },
+		func(tx int, k int) []byte { return make([]byte, 100) },
+	))
+	victimPageId, _ := corruptRandomLeafPageInBucket(t, db.DB, []byte("data"))
+
+	found := make(chan error, 100)
+	require.NoError(t, db.StartScrubber(bolt.ScrubOptions{
+		Interval: time.Hour,
+		Rate:     1024 * 1024,
+		OnError:  func(err error) { found <- err },
+	}))
+	select {
+	case err := <-found:
+		var cerr *berrors.CheckError
+		require.True(t, errors.As(err, &cerr))
+		require.ErrorIs(t, err, berrors.ErrKeyOrder)
+		require.Equal(t, uint64(victimPageId), cerr.Pgid)
+		require.Equal(t, [][]byte{[]byte("data")}, cerr.Bucket)
+	case <-time.After(10 * time.Second):
+		t.Fatal("the corrupted page wasn't found")
+	}
+
+	require.Eventually(t, func() bool { return db.Stats().ScrubPassN == 1 }, 10*time.Second, 10*time.Millisecond)
+
+	// Close stops the scrubber.
+	raw := db.DB
+	require.NoError(t, db.Close())
+	stats := raw.Stats()
+	require.Equal(t, 1, stats.ScrubPassN)
+	require.NotZero(t, stats.ScrubErrorN)
+	require.Equal(t, stats.ScrubErrorN, stats.LastScrubErrorN)
+
+	// Manually close the db, otherwise the PostTestCleanup will
+	// check the db again and accordingly fail the test.
+	db.MustClose()
+}
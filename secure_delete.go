@@ -0,0 +1,85 @@
+package bbolt
+
+import (
+	"runtime"
+	"sort"
+
+	"go.etcd.io/bbolt/internal/common"
+)
+
+// zeroRunPages is the maximum number of pages zeroed with a single write.
+const zeroRunPages = 256
+
+// ScrubFreePages overwrites all the free pages of the database with zeros
+// and syncs them to disk. Pages which are still pending, because an open
+// read-only transaction may read them, are left untouched.
+//
+// It is useful to wipe the remnants of deleted data from a file which was
+// written without Options.SecureDelete. It returns the number of zeroed pages.
+func (db *DB) ScrubFreePages() (int, error) {
+	tx, err := db.beginRWTx()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	var ids common.Pgids
+	for _, s := range db.freelist.FreeSpans() {
+		for i := 0; i < s.Size; i++ {
+			ids = append(ids, s.Start+common.Pgid(i))
+		}
+	}
+	if _, err := db.zeroPages(ids); err != nil {
+		return 0, err
+	}
+	if err := fdatasync(db); err != nil {
+		db.Logger().Errorf("[GOOS: %s, GOARCH: %s] fdatasync failed: %v", runtime.GOOS, runtime.GOARCH, err)
+		return 0, err
+	}
+	db.freelist.ClearReleased()
+
+	return len(ids), nil
+}
+
+// zeroReleasedPages overwrites the pages released since the last commit with
+// zeros. Released pages which have been allocated again are skipped.
+func (tx *Tx) zeroReleasedPages() error {
+	var ids common.Pgids
+	for _, id := range tx.db.freelist.ReleasedPageIds() {
+		if tx.db.freelist.Freed(id) {
+			ids = append(ids, id)
+		}
+	}
+	n, err := tx.db.zeroPages(ids)
+	tx.stats.IncWrite(int64(n))
+	return err
+}
+
+// zeroPages writes zeros over the given pages, coalescing adjacent page ids
+// into a single write. It returns the number of writes performed.
+func (db *DB) zeroPages(ids common.Pgids) (int, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	sort.Sort(ids)
+
+	buf := make([]byte, zeroRunPages*db.pageSize)
+	var writes int
+	for i := 0; i < len(ids); {
+		// Find the run of contiguous page ids starting at i.
+		n := 1
+		for i+n < len(ids) && n < zeroRunPages && ids[i+n] == ids[i]+common.Pgid(n) {
+			n++
+		}
+		offset := int64(ids[i]) * int64(db.pageSize)
+		if _, err := db.ops.writeAt(buf[:n*db.pageSize], offset); err != nil {
+			db.Logger().Errorf("writeAt failed, offset: %d: %v", offset, err)
+			return writes, err
+		}
+		writes++
+		i += n
+	}
+	return writes, nil
+}
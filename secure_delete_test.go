@@ -0,0 +1,77 @@
+package bbolt_test
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "go.etcd.io/bbolt"
+	"go.etcd.io/bbolt/internal/btesting"
+)
+
+var secret = []byte("very-secret-value-which-must-not-linger")
+
+func TestOptions_SecureDelete(t *testing.T) {
+	for _, secureDelete := range []bool{false, true} {
+		db := btesting.MustCreateDBWithOption(t, &bolt.Options{SecureDelete: secureDelete})
+		writeAndDeleteSecret(t, db)
+
+		// Another commit releases the pending pages and zeroes them.
+		require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket([]byte("widgets")).Put([]byte("other"), []byte("value"))
+		}))
+		db.MustClose()
+
+		data, err := os.ReadFile(db.Path())
+		require.NoError(t, err)
+		require.Equal(t, !secureDelete, bytes.Contains(data, secret), "secureDelete: %t", secureDelete)
+	}
+}
+
+func TestDB_ScrubFreePages(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	writeAndDeleteSecret(t, db)
+
+	// Reopening the db turns the pending pages into free pages.
+	db.MustClose()
+	db.MustReopen()
+
+	n, err := db.ScrubFreePages()
+	require.NoError(t, err)
+	require.Greater(t, n, 0)
+	db.MustClose()
+
+	data, err := os.ReadFile(db.Path())
+	require.NoError(t, err)
+	require.False(t, bytes.Contains(data, secret))
+}
+
+func writeAndDeleteSecret(t *testing.T, db *btesting.DB) {
+	// Spread the secret over enough pages that the following commits
+	// can't reuse all of them.
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 100; i++ {
+			v := append(append([]byte{}, secret...), make([]byte, 1000)...)
+			if err := b.Put([]byte(fmt.Sprintf("secret%03d", i)), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		for i := 0; i < 100; i++ {
+			if err := b.Delete([]byte(fmt.Sprintf("secret%03d", i))); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+}
@@ -1,6 +1,7 @@
 package bbolt
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -12,6 +13,8 @@ import (
 	"time"
 	"unsafe"
 
+	"golang.org/x/sync/errgroup"
+
 	berrors "go.etcd.io/bbolt/errors"
 	"go.etcd.io/bbolt/internal/common"
 )
@@ -31,9 +34,16 @@ type Tx struct {
 	meta           *common.Meta
 	root           Bucket
 	pages          map[common.Pgid]*common.Page
+	gen            *mmapGen // mapping the transaction began on, see mmapGen
 	stats          TxStats
 	commitHandlers []func()
 
+	// Read-only transaction tracking, see DB.OpenReadTxs.
+	startTime    time.Time
+	stack        string
+	pinnedPageN  int
+	readerWarned bool
+
 	// WriteFlag specifies the flag for write-related methods like WriteTo().
 	// Tx opens the database file with the specified flag to copy the data.
 	//
@@ -50,7 +60,11 @@ func (tx *Tx) init(db *DB) {
 
 	// Copy the meta page since it can be changed by the writer.
 	tx.meta = &common.Meta{}
-	db.meta().Copy(tx.meta)
+	if tx.writable || db.durability != DurabilitySync {
+		db.writerMeta().Copy(tx.meta)
+	} else {
+		db.meta().Copy(tx.meta)
+	}
 
 	// Copy over the root bucket.
 	tx.root = newBucket(tx)
@@ -61,6 +75,8 @@ func (tx *Tx) init(db *DB) {
 	if tx.writable {
 		tx.pages = make(map[common.Pgid]*common.Page)
 		tx.meta.IncTxid()
+		tx.meta.SetCommitRecord(0)
+		tx.meta.SetCommitSum(0)
 	}
 }
 
@@ -188,7 +204,13 @@ func (tx *Tx) Commit() (err error) {
 		return berrors.ErrTxNotWritable
 	}
 
-	// TODO(benbjohnson): Use vectorized I/O to write out dirty pages.
+	db := tx.db
+	if db.group != nil {
+		if err = db.group.failed(); err != nil {
+			tx.rollback()
+			return err
+		}
+	}
 
 	// Rebalance nodes which have had deletions.
 	var startTime = time.Now()
@@ -216,6 +238,16 @@ func (tx *Tx) Commit() (err error) {
 		tx.db.freelist.Free(tx.meta.Txid(), tx.db.page(tx.meta.Freelist()))
 	}
 
+	// The commit record must be freed before the freelist is written.
+	var rec *common.Page
+	if tx.db.singleSyncCommit() {
+		if rec, err = tx.allocateCommitRecord(); err != nil {
+			lg.Errorf("allocating commit record failed: %v", err)
+			tx.rollback()
+			return err
+		}
+	}
+
 	if !tx.db.NoFreelistSync {
 		err = tx.commitFreelist()
 		if err != nil {
@@ -239,6 +271,10 @@ func (tx *Tx) Commit() (err error) {
 		}
 	}
 
+	if rec != nil {
+		tx.writeCommitRecord(rec)
+	}
+
 	// Write dirty pages to disk.
 	startTime = time.Now()
 	if err = tx.write(); err != nil {
@@ -263,16 +299,37 @@ func (tx *Tx) Commit() (err error) {
 		}
 	}
 
-	// Write meta to disk.
-	if err = tx.writeMeta(); err != nil {
-		lg.Errorf("writeMeta failed: %v", err)
-		tx.rollback()
-		return err
+	if db.group != nil {
+		// Let the next writer start on top of this transaction and wait
+		// for the leader of the group to write and sync the meta page.
+		// With deferred durability the meta page is written by the next
+		// flush instead.
+		db.group.publish(tx.meta)
+		tx.stats.IncWriteTime(time.Since(startTime))
+		tx.close()
+		if db.durability == DurabilitySync {
+			if err = db.group.wait(db, common.Txid(txId)); err != nil {
+				lg.Errorf("group commit failed: %v", err)
+				return err
+			}
+		}
+	} else {
+		// Write meta to disk.
+		if err = tx.writeMeta(); err != nil {
+			lg.Errorf("writeMeta failed: %v", err)
+			tx.rollback()
+			return err
+		}
+		tx.stats.IncWriteTime(time.Since(startTime))
+
+		// Finalize the transaction.
+		tx.close()
 	}
-	tx.stats.IncWriteTime(time.Since(startTime))
 
-	// Finalize the transaction.
-	tx.close()
+	// Lock the pages of the locked buckets at their new locations.
+	if err := db.repin(); err != nil {
+		lg.Warningf("locking the pages of the locked buckets failed: %v", err)
+	}
 
 	// Execute commit handlers now that the locks have been removed.
 	for _, fn := range tx.commitHandlers {
@@ -328,14 +385,16 @@ func (tx *Tx) rollback() {
 		tx.db.freelist.Rollback(tx.meta.Txid())
 		// When mmap fails, the `data`, `dataref` and `datasz` may be reset to
 		// zero values, and there is no way to reload free page IDs in this case.
-		if tx.db.data != nil {
+		if tx.db.mapped() {
 			if !tx.db.hasSyncedFreelist() {
 				// Reconstruct free page list by scanning the DB to get the whole free page list.
 				// Note: scanning the whole db is heavy if your db size is large in NoSyncFreeList mode.
 				tx.db.freelist.NoSyncReload(tx.db.freepages())
 			} else {
-				// Read free page list from freelist page.
-				tx.db.freelist.Reload(tx.db.page(tx.db.meta().Freelist()))
+				// Read free page list from freelist page of the latest
+				// committed transaction, which may not be durable yet in
+				// group commit mode.
+				tx.db.freelist.Reload(tx.db.page(tx.db.writerMeta().Freelist()))
 			}
 		}
 	}
@@ -352,8 +411,15 @@ func (tx *Tx) close() {
 		var freelistPendingN = tx.db.freelist.PendingCount()
 		var freelistAlloc = tx.db.freelist.EstimatedWritePageSize()
 
-		// Remove transaction ref & writer lock.
+		// Release the mapping the transaction began on, and remove the
+		// transaction ref once the freelist is no longer modified.
+		tx.db.metalock.Lock()
+		tx.db.releaseGen(tx.gen)
+		tx.db.saveScrubFree()
 		tx.db.rwtx = nil
+		tx.db.metalock.Unlock()
+
+		// Release the writer lock.
 		tx.db.rwlock.Unlock()
 
 		// Merge statistics.
@@ -373,6 +439,7 @@ func (tx *Tx) close() {
 	tx.meta = nil
 	tx.root = Bucket{tx: tx}
 	tx.pages = nil
+	tx.gen = nil
 }
 
 // Copy writes the entire database to a writer.
@@ -387,6 +454,18 @@ func (tx *Tx) Copy(w io.Writer) error {
 // WriteTo writes the entire database to a writer.
 // If err == nil then exactly tx.Size() bytes will be written into the writer.
 func (tx *Tx) WriteTo(w io.Writer) (n int64, err error) {
+	return tx.WriteToContext(context.Background(), w, nil)
+}
+
+// WriteToContext writes the entire database to a writer, like WriteTo. The
+// progress is reported in bytes to the given function, which may be nil,
+// and the copy stops with the error of the context once it's canceled.
+func (tx *Tx) WriteToContext(ctx context.Context, w io.Writer, progress ProgressFunc) (n int64, err error) {
+	t := newProgressTracker(ctx, progress, ProgressBytes, tx.Size())
+	if t.tracked() {
+		w = &progressWriter{w: w, t: t}
+	}
+
 	// Attempt to open reader with WriteFlag
 	f, err := tx.db.openFile(tx.db.path, os.O_RDONLY|tx.WriteFlag, 0)
 	if err != nil {
@@ -410,7 +489,7 @@ func (tx *Tx) WriteTo(w io.Writer) (n int64, err error) {
 	nn, err := w.Write(buf)
 	n += int64(nn)
 	if err != nil {
-		return n, fmt.Errorf("meta 0 copy: %s", err)
+		return n, fmt.Errorf("meta 0 copy: %w", err)
 	}
 
 	// Write meta 1 with a lower transaction id.
@@ -420,7 +499,7 @@ func (tx *Tx) WriteTo(w io.Writer) (n int64, err error) {
 	nn, err = w.Write(buf)
 	n += int64(nn)
 	if err != nil {
-		return n, fmt.Errorf("meta 1 copy: %s", err)
+		return n, fmt.Errorf("meta 1 copy: %w", err)
 	}
 
 	// Move past the meta pages in the file.
@@ -435,6 +514,7 @@ func (tx *Tx) WriteTo(w io.Writer) (n int64, err error) {
 		return n, err
 	}
 
+	t.finish()
 	return n, nil
 }
 
@@ -442,12 +522,19 @@ func (tx *Tx) WriteTo(w io.Writer) (n int64, err error) {
 // A reader transaction is maintained during the copy so it is safe to continue
 // using the database while a copy is in progress.
 func (tx *Tx) CopyFile(path string, mode os.FileMode) error {
+	return tx.CopyFileContext(context.Background(), path, mode, nil)
+}
+
+// CopyFileContext copies the entire database to file at the given path, like
+// CopyFile, reporting the progress and stopping once the context is canceled
+// as WriteToContext does.
+func (tx *Tx) CopyFileContext(ctx context.Context, path string, mode os.FileMode, progress ProgressFunc) error {
 	f, err := tx.db.openFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, mode)
 	if err != nil {
 		return err
 	}
 
-	_, err = tx.WriteTo(f)
+	_, err = tx.WriteToContext(ctx, f, progress)
 	if err != nil {
 		_ = f.Close()
 		return err
@@ -486,47 +573,41 @@ func (tx *Tx) write() error {
 	tx.pages = make(map[common.Pgid]*common.Page)
 	sort.Sort(pages)
 
-	// Write pages to disk in order.
-	for _, p := range pages {
-		rem := (uint64(p.Overflow()) + 1) * uint64(tx.db.pageSize)
-		offset := int64(p.Id()) * int64(tx.db.pageSize)
-		var written uintptr
-
-		// Write out page in "max allocation" sized chunks.
-		for {
-			sz := rem
-			if sz > maxAllocSize-1 {
-				sz = maxAllocSize - 1
-			}
-			buf := common.UnsafeByteSlice(unsafe.Pointer(p), written, 0, int(sz))
-
-			if _, err := tx.db.ops.writeAt(buf, offset); err != nil {
-				lg.Errorf("writeAt failed, offset: %d: %w", offset, err)
-				return err
-			}
-
-			// Update statistics.
-			tx.stats.IncWrite(1)
-
-			// Exit inner for loop if we've written all the chunks.
-			rem -= sz
-			if rem == 0 {
-				break
-			}
+	// Zero out the released pages before writing the dirty pages, so that
+	// a released page which got reallocated ends up with its new content.
+	if tx.db.secureDelete {
+		if err := tx.zeroReleasedPages(); err != nil {
+			lg.Errorf("zeroing released pages failed: %v", err)
+			return err
+		}
+	}
 
-			// Otherwise move offset forward and move pointer to next chunk.
-			offset += int64(sz)
-			written += uintptr(sz)
+	// Write pages to disk in order, coalescing adjacent pages.
+	if err := tx.writeExtents(tx.extents(pages)); err != nil {
+		return err
+	}
+	if tx.db.pcache != nil {
+		ids := make([]common.Pgid, len(pages))
+		for i, p := range pages {
+			ids[i] = p.Id()
 		}
+		tx.db.pcache.invalidate(ids...)
 	}
 
-	// Ignore file sync if flag is set on DB.
-	if !tx.db.NoSync || common.IgnoreNoSync {
+	// Ignore file sync if flag is set on DB. In group commit mode the
+	// leader of the group syncs the data pages, and with a commit record
+	// they are synced along with the meta page.
+	if (!tx.db.NoSync || common.IgnoreNoSync) && tx.db.group == nil && tx.meta.CommitRecord() == 0 {
 		// gofail: var beforeSyncDataPages struct{}
 		if err := fdatasync(tx.db); err != nil {
 			lg.Errorf("[GOOS: %s, GOARCH: %s] fdatasync failed: %w", runtime.GOOS, runtime.GOARCH, err)
 			return err
 		}
+		tx.stats.IncSync(1)
+	}
+
+	if tx.db.secureDelete {
+		tx.db.freelist.ClearReleased()
 	}
 
 	// Put small pages back to page pool.
@@ -549,6 +630,117 @@ func (tx *Tx) write() error {
 	return nil
 }
 
+// extent is a run of dirty pages which are contiguous in the data file.
+type extent struct {
+	offset int64
+	size   int64
+	bufs   [][]byte
+}
+
+// extents groups the sorted dirty pages into extents of adjacent pages.
+// Each extent is written with a single syscall where the platform allows it.
+func (tx *Tx) extents(pages common.Pages) []extent {
+	var extents []extent
+	pageSize := int64(tx.db.pageSize)
+	for _, p := range pages {
+		offset := int64(p.Id()) * pageSize
+		rem := (int64(p.Overflow()) + 1) * pageSize
+		var written uintptr
+
+		// Split the page into "max allocation" sized chunks.
+		for rem > 0 {
+			sz := rem
+			if sz > maxAllocSize-1 {
+				sz = maxAllocSize - 1
+			}
+			buf := common.UnsafeByteSlice(unsafe.Pointer(p), written, 0, int(sz))
+
+			// Append the chunk to the previous extent if it directly follows it.
+			if n := len(extents); n > 0 && extents[n-1].follows(offset, sz) {
+				extents[n-1].bufs = append(extents[n-1].bufs, buf)
+				extents[n-1].size += sz
+			} else {
+				extents = append(extents, extent{offset: offset, size: sz, bufs: [][]byte{buf}})
+			}
+
+			rem -= sz
+			offset += sz
+			written += uintptr(sz)
+		}
+	}
+	return extents
+}
+
+// follows returns true if a chunk of sz bytes at offset can be appended to e.
+func (e *extent) follows(offset, sz int64) bool {
+	return e.offset+e.size == offset && len(e.bufs) < maxIovecs && e.size+sz <= maxAllocSize-1
+}
+
+// writeExtents writes the extents to disk. Non-adjacent extents are written
+// concurrently when DB.WriteConcurrency is larger than 1.
+func (tx *Tx) writeExtents(extents []extent) error {
+	if tx.db.WriteConcurrency <= 1 || len(extents) <= 1 {
+		for _, e := range extents {
+			if err := tx.writeExtent(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var g errgroup.Group
+	g.SetLimit(tx.db.WriteConcurrency)
+	for _, e := range extents {
+		e := e
+		g.Go(func() error {
+			return tx.writeExtent(e)
+		})
+	}
+	return g.Wait()
+}
+
+// writeExtent writes a single extent to disk.
+func (tx *Tx) writeExtent(e extent) error {
+	calls, direct, err := tx.db.writeDirect(e)
+	if !direct {
+		var n int
+		if len(e.bufs) == 1 {
+			_, err = tx.db.ops.writeAt(e.bufs[0], e.offset)
+			n = 1
+		} else {
+			n, err = pwritev(tx.db, e.bufs, e.offset)
+		}
+		calls += n
+	}
+
+	// Update statistics.
+	tx.stats.IncWrite(int64(calls))
+
+	if err != nil {
+		tx.db.Logger().Errorf("writeAt failed, offset: %d: %w", e.offset, err)
+		return err
+	}
+	return nil
+}
+
+// writeCoalesced copies the buffers into a single one and writes it to the
+// database file at the given offset with DB.ops.writeAt(). It returns the
+// number of syscalls made.
+func writeCoalesced(db *DB, bufs [][]byte, offset int64) (int, error) {
+	var sz int
+	for _, b := range bufs {
+		sz += len(b)
+	}
+	buf := make([]byte, 0, sz)
+	for _, b := range bufs {
+		buf = append(buf, b...)
+	}
+	if _, err := db.ops.writeAt(buf, offset); err != nil {
+		return 1, err
+	}
+	return 1, nil
+}
+
 // writeMeta writes the meta to the disk.
 func (tx *Tx) writeMeta() error {
 	// gofail: var beforeWriteMetaError string
@@ -565,12 +757,16 @@ func (tx *Tx) writeMeta() error {
 		lg.Errorf("writeAt failed, pgid: %d, pageSize: %d, error: %v", p.Id(), tx.db.pageSize, err)
 		return err
 	}
+	if tx.db.pcache != nil {
+		tx.db.pcache.metaWritten(p)
+	}
 	if !tx.db.NoSync || common.IgnoreNoSync {
 		// gofail: var beforeSyncMetaPage struct{}
 		if err := fdatasync(tx.db); err != nil {
 			lg.Errorf("[GOOS: %s, GOARCH: %s] fdatasync failed: %w", runtime.GOOS, runtime.GOARCH, err)
 			return err
 		}
+		tx.stats.IncSync(1)
 	}
 
 	// Update statistics.
@@ -590,32 +786,51 @@ func (tx *Tx) page(id common.Pgid) *common.Page {
 		}
 	}
 
-	// Otherwise return directly from the mmap.
-	p := tx.db.page(id)
+	// Otherwise return directly from the mmap the transaction began on.
+	var p *common.Page
+	if tx.gen != nil {
+		p = tx.gen.page(id, tx.db.pageSize)
+	} else {
+		p = tx.db.page(id)
+	}
 	p.FastCheck(id)
 	return p
 }
 
 // forEachPage iterates over every page within a given page and executes a function.
 func (tx *Tx) forEachPage(pgidnum common.Pgid, fn func(*common.Page, int, []common.Pgid)) {
+	_ = tx.walkPages(pgidnum, func(p *common.Page, depth int, stack []common.Pgid) error {
+		fn(p, depth, stack)
+		return nil
+	})
+}
+
+// walkPages is like forEachPage, but stops at the first error returned by
+// the function, and returns it.
+func (tx *Tx) walkPages(pgidnum common.Pgid, fn func(*common.Page, int, []common.Pgid) error) error {
 	stack := make([]common.Pgid, 10)
 	stack[0] = pgidnum
-	tx.forEachPageInternal(stack[:1], fn)
+	return tx.forEachPageInternal(stack[:1], fn)
 }
 
-func (tx *Tx) forEachPageInternal(pgidstack []common.Pgid, fn func(*common.Page, int, []common.Pgid)) {
+func (tx *Tx) forEachPageInternal(pgidstack []common.Pgid, fn func(*common.Page, int, []common.Pgid) error) error {
 	p := tx.page(pgidstack[len(pgidstack)-1])
 
 	// Execute function.
-	fn(p, len(pgidstack)-1, pgidstack)
+	if err := fn(p, len(pgidstack)-1, pgidstack); err != nil {
+		return err
+	}
 
 	// Recursively loop over children.
 	if p.IsBranchPage() {
 		for i := 0; i < int(p.Count()); i++ {
 			elem := p.BranchPageElement(uint16(i))
-			tx.forEachPageInternal(append(pgidstack, elem.Pgid()), fn)
+			if err := tx.forEachPageInternal(append(pgidstack, elem.Pgid()), fn); err != nil {
+				return err
+			}
 		}
 	}
+	return nil
 }
 
 // Page returns page information for a given page number.
@@ -689,9 +904,11 @@ type TxStats struct {
 	// Write statistics.
 	//
 	// DEPRECATED: Use GetWrite() or IncWrite()
-	Write int64 // number of writes performed
+	Write int64 // number of write syscalls performed
 	// DEPRECATED: Use GetWriteTime() or IncWriteTime()
 	WriteTime time.Duration // total time spent writing to disk
+	// DEPRECATED: Use GetSync() or IncSync()
+	Sync int64 // number of fdatasync syscalls performed
 }
 
 func (s *TxStats) add(other *TxStats) {
@@ -707,6 +924,7 @@ func (s *TxStats) add(other *TxStats) {
 	s.IncSpillTime(other.GetSpillTime())
 	s.IncWrite(other.GetWrite())
 	s.IncWriteTime(other.GetWriteTime())
+	s.IncSync(other.GetSync())
 }
 
 // Sub calculates and returns the difference between two sets of transaction stats.
@@ -726,6 +944,7 @@ func (s *TxStats) Sub(other *TxStats) TxStats {
 	diff.SpillTime = s.GetSpillTime() - other.GetSpillTime()
 	diff.Write = s.GetWrite() - other.GetWrite()
 	diff.WriteTime = s.GetWriteTime() - other.GetWriteTime()
+	diff.Sync = s.GetSync() - other.GetSync()
 	return diff
 }
 
@@ -849,6 +1068,16 @@ func (s *TxStats) IncWriteTime(delta time.Duration) time.Duration {
 	return atomicAddDuration(&s.WriteTime, delta)
 }
 
+// GetSync returns Sync atomically.
+func (s *TxStats) GetSync() int64 {
+	return atomic.LoadInt64(&s.Sync)
+}
+
+// IncSync increases Sync atomically and returns the new value.
+func (s *TxStats) IncSync(delta int64) int64 {
+	return atomic.AddInt64(&s.Sync, delta)
+}
+
 func atomicAddDuration(ptr *time.Duration, du time.Duration) time.Duration {
 	return time.Duration(atomic.AddInt64((*int64)(unsafe.Pointer(ptr)), int64(du)))
 }
@@ -1,14 +1,18 @@
 package bbolt
 
 import (
+	"context"
 	"encoding/hex"
 	"fmt"
 
+	berrors "go.etcd.io/bbolt/errors"
 	"go.etcd.io/bbolt/internal/common"
 )
 
 // Check performs several consistency checks on the database for this transaction.
-// An error is returned if any inconsistency is found.
+// An error is returned if any inconsistency is found. The inconsistencies are
+// reported as *errors.CheckError, which tell the kind of inconsistency, its
+// severity and the page, page stack and bucket path involved.
 //
 // It can be safely run concurrently on a writable transaction. However, this
 // incurs a high cost for large databases and databases with a lot of subbuckets
@@ -30,12 +34,26 @@ func (tx *Tx) Check(options ...CheckOption) <-chan error {
 	go func() {
 		// Close the channel to signal completion.
 		defer close(ch)
-		tx.check(chkConfig, ch)
+
+		// Report a page which can't be read, or the cancellation of the
+		// check, as an error.
+		var err error
+		defer func() {
+			if err != nil {
+				ch <- err
+			}
+		}()
+		if tx.db.catchesIOErrors() {
+			defer tx.db.recoverIOError(&err, tx.db.guardFaults())
+		}
+		err = tx.check(chkConfig, ch)
 	}()
 	return ch
 }
 
-func (tx *Tx) check(cfg checkConfig, ch chan error) {
+// check sends the inconsistencies found to the channel, and returns the
+// error of the context once the check is canceled.
+func (tx *Tx) check(cfg checkConfig, ch chan error) error {
 	// Force loading free list if opened in ReadOnly mode.
 	tx.db.loadFreelist()
 
@@ -45,59 +63,94 @@ func (tx *Tx) check(cfg checkConfig, ch chan error) {
 	tx.db.freelist.Copyall(all)
 	for _, id := range all {
 		if freed[id] {
-			ch <- fmt.Errorf("page %d: already freed", id)
+			ch <- newCheckError(berrors.ErrDoubleFree, id, nil, nil, "page %d: already freed", id)
 		}
 		freed[id] = true
 	}
 
 	// Track every reachable page.
-	reachable := make(map[common.Pgid]*common.Page)
-	reachable[0] = tx.page(0) // meta0
-	reachable[1] = tx.page(1) // meta1
+	reachable := make(map[common.Pgid]bool)
+	reachable[0] = true // meta0
+	reachable[1] = true // meta1
 	if tx.meta.Freelist() != common.PgidNoFreelist {
 		for i := uint32(0); i <= tx.page(tx.meta.Freelist()).Overflow(); i++ {
-			reachable[tx.meta.Freelist()+common.Pgid(i)] = tx.page(tx.meta.Freelist())
+			reachable[tx.meta.Freelist()+common.Pgid(i)] = true
 		}
 	}
 
 	if cfg.pageId == 0 {
+		// Count the pages checked, the meta, freelist and free pages first.
+		progress := newProgressTracker(cfg.ctx, cfg.progress, ProgressPages, int64(tx.meta.Pgid()))
+		if err := progress.add(int64(len(reachable)+len(freed)), nil); err != nil {
+			return err
+		}
+
 		// Check the whole db file, starting from the root bucket and
 		// recursively check all child buckets.
-		tx.recursivelyCheckBucket(&tx.root, reachable, freed, cfg.kvStringer, ch)
+		var err error
+		reached := func(id common.Pgid) bool { return reachable[id] }
+		if cfg.parallelism > 1 {
+			c := newParallelCheck(tx, cfg.parallelism, reachable, freed, cfg.kvStringer, progress, ch)
+			err = c.run(tx.root.RootPage(), false)
+			reached = c.reached
+		} else {
+			err = tx.recursivelyCheckBucket(&tx.root, nil, reachable, freed, cfg.kvStringer, progress, ch)
+		}
+		if err != nil {
+			return err
+		}
 
 		// Ensure all pages below high water mark are either reachable or freed.
 		for i := common.Pgid(0); i < tx.meta.Pgid(); i++ {
-			_, isReachable := reachable[i]
-			if !isReachable && !freed[i] {
-				ch <- fmt.Errorf("page %d: unreachable unfreed", int(i))
+			if !reached(i) && !freed[i] {
+				ch <- newCheckError(berrors.ErrUnreachableUnfreed, i, nil, nil, "page %d: unreachable unfreed", int(i))
 			}
 		}
+		progress.finish()
 	} else {
 		// Check the db file starting from a specified pageId.
 		if cfg.pageId < 2 || cfg.pageId >= uint64(tx.meta.Pgid()) {
 			ch <- fmt.Errorf("page ID (%d) out of range [%d, %d)", cfg.pageId, 2, tx.meta.Pgid())
-			return
+			return nil
 		}
 
-		tx.recursivelyCheckPage(common.Pgid(cfg.pageId), reachable, freed, cfg.kvStringer, ch)
+		// The number of pages of the subtree isn't known.
+		progress := newProgressTracker(cfg.ctx, cfg.progress, ProgressPages, 0)
+		var err error
+		if cfg.parallelism > 1 {
+			err = newParallelCheck(tx, cfg.parallelism, reachable, freed, cfg.kvStringer, progress, ch).run(common.Pgid(cfg.pageId), true)
+		} else {
+			err = tx.recursivelyCheckPage(common.Pgid(cfg.pageId), reachable, freed, cfg.kvStringer, progress, ch)
+		}
+		if err != nil {
+			return err
+		}
+		progress.finish()
 	}
+	return nil
 }
 
-func (tx *Tx) recursivelyCheckPage(pageId common.Pgid, reachable map[common.Pgid]*common.Page, freed map[common.Pgid]bool,
-	kvStringer KVStringer, ch chan error) {
-	tx.checkInvariantProperties(pageId, reachable, freed, kvStringer, ch)
-	tx.recursivelyCheckBucketInPage(pageId, reachable, freed, kvStringer, ch)
+func (tx *Tx) recursivelyCheckPage(pageId common.Pgid, reachable map[common.Pgid]bool, freed map[common.Pgid]bool,
+	kvStringer KVStringer, progress *progressTracker, ch chan error) error {
+	if err := tx.checkInvariantProperties(pageId, nil, reachable, freed, kvStringer, progress, ch); err != nil {
+		return err
+	}
+	return tx.recursivelyCheckBucketInPage(pageId, nil, reachable, freed, kvStringer, progress, ch)
 }
 
-func (tx *Tx) recursivelyCheckBucketInPage(pageId common.Pgid, reachable map[common.Pgid]*common.Page, freed map[common.Pgid]bool,
-	kvStringer KVStringer, ch chan error) {
+// recursivelyCheckBucketInPage checks the buckets of the subtree rooted at
+// the given page. The bucket paths are relative to the page.
+func (tx *Tx) recursivelyCheckBucketInPage(pageId common.Pgid, path [][]byte, reachable map[common.Pgid]bool, freed map[common.Pgid]bool,
+	kvStringer KVStringer, progress *progressTracker, ch chan error) error {
 	p := tx.page(pageId)
 
 	switch {
 	case p.IsBranchPage():
 		for i := range p.BranchPageElements() {
 			elem := p.BranchPageElement(uint16(i))
-			tx.recursivelyCheckBucketInPage(elem.Pgid(), reachable, freed, kvStringer, ch)
+			if err := tx.recursivelyCheckBucketInPage(elem.Pgid(), path, reachable, freed, kvStringer, progress, ch); err != nil {
+				return err
+			}
 		}
 	case p.IsLeafPage():
 		for i := range p.LeafPageElements() {
@@ -111,61 +164,73 @@ func (tx *Tx) recursivelyCheckBucketInPage(pageId common.Pgid, reachable map[com
 					tx:          tx,
 				}
 				if child := tmpBucket.Bucket(elem.Key()); child != nil {
-					tx.recursivelyCheckBucket(child, reachable, freed, kvStringer, ch)
+					if err := tx.recursivelyCheckBucket(child, appendPath(path, elem.Key()), reachable, freed, kvStringer, progress, ch); err != nil {
+						return err
+					}
 				}
 			}
 		}
 	default:
-		ch <- fmt.Errorf("unexpected page type (flags: %x) for pgId:%d", p.Flags(), pageId)
+		ch <- newCheckError(berrors.ErrPageTypeMismatch, pageId, nil, path, "unexpected page type (flags: %x) for pgId:%d", p.Flags(), pageId)
 	}
+	return nil
 }
 
-func (tx *Tx) recursivelyCheckBucket(b *Bucket, reachable map[common.Pgid]*common.Page, freed map[common.Pgid]bool,
-	kvStringer KVStringer, ch chan error) {
+// recursivelyCheckBucket checks the bucket at the given path and its nested
+// buckets.
+func (tx *Tx) recursivelyCheckBucket(b *Bucket, path [][]byte, reachable map[common.Pgid]bool, freed map[common.Pgid]bool,
+	kvStringer KVStringer, progress *progressTracker, ch chan error) error {
 	// Ignore inline buckets.
 	if b.RootPage() == 0 {
-		return
+		return nil
 	}
 
-	tx.checkInvariantProperties(b.RootPage(), reachable, freed, kvStringer, ch)
+	if err := tx.checkInvariantProperties(b.RootPage(), path, reachable, freed, kvStringer, progress, ch); err != nil {
+		return err
+	}
 
 	// Check each bucket within this bucket.
-	_ = b.ForEachBucket(func(k []byte) error {
+	return b.ForEachBucket(func(k []byte) error {
 		if child := b.Bucket(k); child != nil {
-			tx.recursivelyCheckBucket(child, reachable, freed, kvStringer, ch)
+			return tx.recursivelyCheckBucket(child, appendPath(path, k), reachable, freed, kvStringer, progress, ch)
 		}
 		return nil
 	})
 }
 
-func (tx *Tx) checkInvariantProperties(pageId common.Pgid, reachable map[common.Pgid]*common.Page, freed map[common.Pgid]bool,
-	kvStringer KVStringer, ch chan error) {
-	tx.forEachPage(pageId, func(p *common.Page, _ int, stack []common.Pgid) {
-		verifyPageReachable(p, tx.meta.Pgid(), stack, reachable, freed, ch)
-	})
+func (tx *Tx) checkInvariantProperties(pageId common.Pgid, path [][]byte, reachable map[common.Pgid]bool, freed map[common.Pgid]bool,
+	kvStringer KVStringer, progress *progressTracker, ch chan error) error {
+	// Stop once the check is canceled.
+	if err := tx.walkPages(pageId, func(p *common.Page, _ int, stack []common.Pgid) error {
+		verifyPageReachable(p, tx.meta.Pgid(), stack, path, reachable, freed, ch)
+		return progress.add(int64(p.Overflow())+1, path)
+	}); err != nil {
+		return err
+	}
 
-	tx.recursivelyCheckPageKeyOrder(pageId, kvStringer.KeyToString, ch)
+	tx.recursivelyCheckPageKeyOrder(pageId, path, kvStringer.KeyToString, ch)
+	return nil
 }
 
-func verifyPageReachable(p *common.Page, hwm common.Pgid, stack []common.Pgid, reachable map[common.Pgid]*common.Page, freed map[common.Pgid]bool, ch chan error) {
+func verifyPageReachable(p *common.Page, hwm common.Pgid, stack []common.Pgid, path [][]byte, reachable map[common.Pgid]bool, freed map[common.Pgid]bool, ch chan error) {
 	if p.Id() > hwm {
-		ch <- fmt.Errorf("page %d: out of bounds: %d (stack: %v)", int(p.Id()), int(hwm), stack)
+		ch <- newCheckError(berrors.ErrOutOfBounds, p.Id(), stack, path, "page %d: out of bounds: %d (stack: %v)", int(p.Id()), int(hwm), stack)
 	}
 
 	// Ensure each page is only referenced once.
 	for i := common.Pgid(0); i <= common.Pgid(p.Overflow()); i++ {
 		var id = p.Id() + i
-		if _, ok := reachable[id]; ok {
-			ch <- fmt.Errorf("page %d: multiple references (stack: %v)", int(id), stack)
+		if reachable[id] {
+			ch <- newCheckError(berrors.ErrMultipleReferences, id, stack, path, "page %d: multiple references (stack: %v)", int(id), stack)
 		}
-		reachable[id] = p
+		reachable[id] = true
 	}
 
 	// We should only encounter un-freed leaf and branch pages.
 	if freed[p.Id()] {
-		ch <- fmt.Errorf("page %d: reachable freed", int(p.Id()))
+		ch <- newCheckError(berrors.ErrReachableFreed, p.Id(), stack, path, "page %d: reachable freed", int(p.Id()))
 	} else if !p.IsBranchPage() && !p.IsLeafPage() {
-		ch <- fmt.Errorf("page %d: invalid type: %s (stack: %v)", int(p.Id()), p.Typ(), stack)
+		ch <- newCheckError(berrors.ErrPageTypeMismatch, p.Id(), stack, path, "page %d: invalid type: %s (stack: %v)", int(p.Id()), p.Typ(), stack)
 	}
 }
 
@@ -173,8 +238,8 @@ func verifyPageReachable(p *common.Page, hwm common.Pgid, stack []common.Pgid, r
 // key order constraints:
 //   - keys on pages must be sorted
 //   - keys on children pages are between 2 consecutive keys on the parent's branch page).
-func (tx *Tx) recursivelyCheckPageKeyOrder(pgId common.Pgid, keyToString func([]byte) string, ch chan error) {
-	tx.recursivelyCheckPageKeyOrderInternal(pgId, nil, nil, nil, keyToString, ch)
+func (tx *Tx) recursivelyCheckPageKeyOrder(pgId common.Pgid, path [][]byte, keyToString func([]byte) string, ch chan error) {
+	tx.recursivelyCheckPageKeyOrderInternal(pgId, nil, nil, nil, path, keyToString, ch)
 }
 
 // recursivelyCheckPageKeyOrderInternal verifies that all keys in the subtree rooted at `pgid` are:
@@ -183,7 +248,7 @@ func (tx *Tx) recursivelyCheckPageKeyOrder(pgId common.Pgid, keyToString func([]
 //   - Are in right ordering relationship to their parents.
 //     `pagesStack` is expected to contain IDs of pages from the tree root to `pgid` for the clean debugging message.
 func (tx *Tx) recursivelyCheckPageKeyOrderInternal(
-	pgId common.Pgid, minKeyClosed, maxKeyOpen []byte, pagesStack []common.Pgid,
+	pgId common.Pgid, minKeyClosed, maxKeyOpen []byte, pagesStack []common.Pgid, path [][]byte,
 	keyToString func([]byte) string, ch chan error) (maxKeyInSubtree []byte) {
 
 	p := tx.page(pgId)
@@ -194,13 +259,13 @@ func (tx *Tx) recursivelyCheckPageKeyOrderInternal(
 		runningMin := minKeyClosed
 		for i := range p.BranchPageElements() {
 			elem := p.BranchPageElement(uint16(i))
-			verifyKeyOrder(elem.Pgid(), "branch", i, elem.Key(), runningMin, maxKeyOpen, ch, keyToString, pagesStack)
+			verifyKeyOrder(elem.Pgid(), "branch", i, elem.Key(), runningMin, maxKeyOpen, ch, keyToString, pagesStack, path)
 
 			maxKey := maxKeyOpen
 			if i < len(p.BranchPageElements())-1 {
 				maxKey = p.BranchPageElement(uint16(i + 1)).Key()
 			}
-			maxKeyInSubtree = tx.recursivelyCheckPageKeyOrderInternal(elem.Pgid(), elem.Key(), maxKey, pagesStack, keyToString, ch)
+			maxKeyInSubtree = tx.recursivelyCheckPageKeyOrderInternal(elem.Pgid(), elem.Key(), maxKey, pagesStack, path, keyToString, ch)
 			runningMin = maxKeyInSubtree
 		}
 		return maxKeyInSubtree
@@ -208,14 +273,14 @@ func (tx *Tx) recursivelyCheckPageKeyOrderInternal(
 		runningMin := minKeyClosed
 		for i := range p.LeafPageElements() {
 			elem := p.LeafPageElement(uint16(i))
-			verifyKeyOrder(pgId, "leaf", i, elem.Key(), runningMin, maxKeyOpen, ch, keyToString, pagesStack)
+			verifyKeyOrder(pgId, "leaf", i, elem.Key(), runningMin, maxKeyOpen, ch, keyToString, pagesStack, path)
 			runningMin = elem.Key()
 		}
 		if p.Count() > 0 {
 			return p.LeafPageElement(p.Count() - 1).Key()
 		}
 	default:
-		ch <- fmt.Errorf("unexpected page type (flags: %x) for pgId:%d", p.Flags(), pgId)
+		ch <- newCheckError(berrors.ErrPageTypeMismatch, pgId, pagesStack, path, "unexpected page type (flags: %x) for pgId:%d", p.Flags(), pgId)
 	}
 	return maxKeyInSubtree
 }
@@ -224,33 +289,74 @@ func (tx *Tx) recursivelyCheckPageKeyOrderInternal(
  * verifyKeyOrder checks whether an entry with given #index on pgId (pageType: "branch|leaf") that has given "key",
  * is within range determined by (previousKey..maxKeyOpen) and reports found violations to the channel (ch).
  */
-func verifyKeyOrder(pgId common.Pgid, pageType string, index int, key []byte, previousKey []byte, maxKeyOpen []byte, ch chan error, keyToString func([]byte) string, pagesStack []common.Pgid) {
+func verifyKeyOrder(pgId common.Pgid, pageType string, index int, key []byte, previousKey []byte, maxKeyOpen []byte, ch chan error, keyToString func([]byte) string, pagesStack []common.Pgid, path [][]byte) {
 	if index == 0 && previousKey != nil && compareKeys(previousKey, key) > 0 {
-		ch <- fmt.Errorf("the first key[%d]=(hex)%s on %s page(%d) needs to be >= the key in the ancestor (%s). Stack: %v",
+		ch <- newCheckError(berrors.ErrKeyOrder, pgId, pagesStack, path, "the first key[%d]=(hex)%s on %s page(%d) needs to be >= the key in the ancestor (%s). Stack: %v",
 			index, keyToString(key), pageType, pgId, keyToString(previousKey), pagesStack)
 	}
 	if index > 0 {
 		cmpRet := compareKeys(previousKey, key)
 		if cmpRet > 0 {
-			ch <- fmt.Errorf("key[%d]=(hex)%s on %s page(%d) needs to be > (found <) than previous element (hex)%s. Stack: %v",
+			ch <- newCheckError(berrors.ErrKeyOrder, pgId, pagesStack, path, "key[%d]=(hex)%s on %s page(%d) needs to be > (found <) than previous element (hex)%s. Stack: %v",
 				index, keyToString(key), pageType, pgId, keyToString(previousKey), pagesStack)
 		}
 		if cmpRet == 0 {
-			ch <- fmt.Errorf("key[%d]=(hex)%s on %s page(%d) needs to be > (found =) than previous element (hex)%s. Stack: %v",
+			ch <- newCheckError(berrors.ErrKeyOrder, pgId, pagesStack, path, "key[%d]=(hex)%s on %s page(%d) needs to be > (found =) than previous element (hex)%s. Stack: %v",
 				index, keyToString(key), pageType, pgId, keyToString(previousKey), pagesStack)
 		}
 	}
 	if maxKeyOpen != nil && compareKeys(key, maxKeyOpen) >= 0 {
-		ch <- fmt.Errorf("key[%d]=(hex)%s on %s page(%d) needs to be < than key of the next element in ancestor (hex)%s. Pages stack: %v",
+		ch <- newCheckError(berrors.ErrKeyOrder, pgId, pagesStack, path, "key[%d]=(hex)%s on %s page(%d) needs to be < than key of the next element in ancestor (hex)%s. Pages stack: %v",
 			index, keyToString(key), pageType, pgId, keyToString(previousKey), pagesStack)
 	}
 }
 
+// newCheckError returns the CheckError of an inconsistency of the given kind.
+// The page stack and the bucket path are copied, since the caller reuses the
+// stack and the bucket names point into the mmap.
+func newCheckError(kind error, pgId common.Pgid, stack []common.Pgid, path [][]byte, format string, args ...interface{}) *berrors.CheckError {
+	e := &berrors.CheckError{
+		Kind:     kind,
+		Severity: checkSeverity(kind),
+		Pgid:     uint64(pgId),
+		Message:  fmt.Sprintf(format, args...),
+	}
+	if len(stack) > 0 {
+		e.Stack = make([]uint64, len(stack))
+		for i, id := range stack {
+			e.Stack[i] = uint64(id)
+		}
+	}
+	if len(path) > 0 {
+		e.Bucket = clonePath(path)
+	}
+	return e
+}
+
+// checkSeverity returns the severity of a kind of inconsistency.
+func checkSeverity(kind error) berrors.CheckSeverity {
+	switch kind {
+	case berrors.ErrUnreachableUnfreed:
+		return berrors.SeverityWarning
+	case berrors.ErrDoubleFree, berrors.ErrReachableFreed:
+		return berrors.SeverityError
+	}
+	return berrors.SeverityCritical
+}
+
+// appendPath returns the path of a nested bucket without modifying path.
+func appendPath(path [][]byte, name []byte) [][]byte {
+	return append(path[:len(path):len(path)], name)
+}
+
 // ===========================================================================================
 
 type checkConfig struct {
-	kvStringer KVStringer
-	pageId     uint64
+	kvStringer  KVStringer
+	pageId      uint64
+	parallelism int
+	ctx         context.Context
+	progress    ProgressFunc
 }
 
 type CheckOption func(options *checkConfig)
@@ -268,6 +374,32 @@ func WithPageId(pageId uint64) CheckOption {
 	}
 }
 
+// WithParallelism sets the number of workers checking the pages of the
+// buckets concurrently. The errors are reported in the same order as the
+// sequential check, which is used if n is 1 or less.
+func WithParallelism(n int) CheckOption {
+	return func(c *checkConfig) {
+		c.parallelism = n
+	}
+}
+
+// WithContext stops the check once the context is canceled, and reports
+// the error of the context as the last error.
+func WithContext(ctx context.Context) CheckOption {
+	return func(c *checkConfig) {
+		c.ctx = ctx
+	}
+}
+
+// WithProgress reports the progress of the check in pages to the given
+// function. The total is the number of pages of the file, or unknown with
+// WithPageId.
+func WithProgress(fn ProgressFunc) CheckOption {
+	return func(c *checkConfig) {
+		c.progress = fn
+	}
+}
+
 // KVStringer allows to prepare human-readable diagnostic messages.
 type KVStringer interface {
 	KeyToString([]byte) string
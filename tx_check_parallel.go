@@ -0,0 +1,575 @@
+package bbolt
+
+import (
+	"container/heap"
+	"sync"
+	"sync/atomic"
+
+	berrors "go.etcd.io/bbolt/errors"
+	"go.etcd.io/bbolt/internal/common"
+)
+
+// With WithParallelism, the pages of the buckets are checked by a fixed set
+// of workers. Every bucket whose root is a branch page is split into a unit
+// per child page, and the units of every nested bucket are queued as soon as
+// the bucket is found. The workers take the units in the order of the
+// sequential check, and only run a few units ahead of the goroutine emitting
+// the errors, which replays the results of each unit in that order and then
+// drops them.
+//
+// The workers mark the pages they reach with the unit which reached them
+// first. Only the pages reached more than once are replayed against each
+// other, so that the pages referenced more than once are reported as by the
+// sequential check.
+
+// checkUnitsAhead is the number of units per worker which may be checked
+// ahead of the emitted ones.
+const checkUnitsAhead = 4
+
+// presetUnit marks the pages reachable before the check, the meta and
+// freelist pages. Its id is never given to a unit.
+const presetUnit = 1
+
+// parallelCheck is a check of the pages with concurrent workers.
+type parallelCheck struct {
+	tx         *Tx
+	reachable  map[common.Pgid]bool
+	freed      map[common.Pgid]bool
+	kvStringer KVStringer
+	progress   *progressTracker
+	ch         chan error
+
+	parallelism int
+	lastUnit    int32 // id of the last unit created
+
+	// owners holds the id of the unit which reached each page below the
+	// high water mark first, and twice the pages reached more than once.
+	owners []int32
+	twice  []uint32
+
+	// Used by the emitting goroutine only.
+	replayed []bool               // units replayed, by id
+	shared   map[common.Pgid]bool // pages reached more than once which were replayed
+
+	mu     sync.Mutex
+	cond   sync.Cond
+	queue  checkQueue
+	ahead  int // units started and not replayed yet
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// checkJob is the check of a bucket, or of the page given to WithPageId.
+type checkJob struct {
+	root  common.Pgid
+	path  [][]byte
+	key   []int32 // position in the order of the sequential check
+	split bool    // the root is a branch page, checked by a unit per child
+	units []*checkUnit
+	panic interface{} // IOError reading the root page
+}
+
+// checkUnit is a subtree of a bucket checked by a worker.
+type checkUnit struct {
+	id             int32
+	key            []int32
+	path           [][]byte
+	pgId           common.Pgid
+	stack          []common.Pgid // pages from the root of the bucket to the parent of pgId
+	minKey, maxKey []byte        // range of the keys of the subtree, see recursivelyCheckPageKeyOrderInternal
+	shallow        bool          // only the page itself is visited
+	inPage         bool          // report the unexpected pages, see recursivelyCheckBucketInPage
+
+	visits          []checkVisit      // pages of the subtree, in the order of forEachPage
+	visitErrs       []checkVisitError // errors found on the visited pages
+	keyErrs         []error           // errors of the key order
+	pageN           int64             // number of pages visited, including the overflow pages
+	maxKeyInSubtree []byte
+	children        []checkChild // nested buckets, in key order
+	panic           interface{}  // IOError reading a page
+	err             error        // error of the context if the check was canceled
+
+	started bool // Protected by parallelCheck.mu.
+	done    chan struct{}
+}
+
+// checkVisit is a page reached by a unit.
+type checkVisit struct {
+	id       common.Pgid
+	overflow uint32
+	parent   int32 // index of the parent page in the visits, or -1
+}
+
+// checkVisitError is an error found on a visited page, reported before or
+// after the pages referenced more than once.
+type checkVisitError struct {
+	at  int
+	pre bool
+	err error
+}
+
+// checkChild is a nested bucket found by a unit, or an unexpected page.
+type checkChild struct {
+	job *checkJob
+	err error
+}
+
+func newParallelCheck(tx *Tx, parallelism int, reachable, freed map[common.Pgid]bool, kvStringer KVStringer,
+	progress *progressTracker, ch chan error) *parallelCheck {
+	hwm := tx.meta.Pgid()
+	c := &parallelCheck{
+		tx:          tx,
+		reachable:   reachable,
+		freed:       freed,
+		kvStringer:  kvStringer,
+		progress:    progress,
+		ch:          ch,
+		parallelism: parallelism,
+		lastUnit:    presetUnit,
+		owners:      make([]int32, hwm),
+		twice:       make([]uint32, (hwm+31)/32),
+		replayed:    make([]bool, presetUnit+1),
+		shared:      make(map[common.Pgid]bool),
+	}
+	c.cond.L = &c.mu
+	c.replayed[presetUnit] = true
+	for id := range reachable {
+		if id < hwm {
+			c.owners[id] = presetUnit
+		} else {
+			c.shared[id] = true
+		}
+	}
+	return c
+}
+
+// run checks the subtree rooted at the given page and the nested buckets,
+// and reports the errors in the order of the sequential check. inPage is
+// set for the page given to WithPageId. It returns the error of the context
+// once the check is canceled.
+func (c *parallelCheck) run(root common.Pgid, inPage bool) error {
+	for i := 0; i < c.parallelism; i++ {
+		c.wg.Add(1)
+		go c.work()
+	}
+	// Don't return before the workers are done with the transaction.
+	defer func() {
+		c.mu.Lock()
+		c.closed = true
+		c.cond.Broadcast()
+		c.mu.Unlock()
+		c.wg.Wait()
+	}()
+	return c.emit(c.start(root, nil, nil, inPage))
+}
+
+// reached returns true if the page was reached by the check.
+func (c *parallelCheck) reached(id common.Pgid) bool {
+	if int(id) < len(c.owners) {
+		return atomic.LoadInt32(&c.owners[id]) != 0
+	}
+	return c.shared[id]
+}
+
+// work runs the queued units until the check is done.
+func (c *parallelCheck) work() {
+	defer c.wg.Done()
+	for {
+		c.mu.Lock()
+		for !c.closed && (c.queue.Len() == 0 || c.ahead >= c.parallelism*checkUnitsAhead) {
+			c.cond.Wait()
+		}
+		if c.closed {
+			c.mu.Unlock()
+			return
+		}
+		u := heap.Pop(&c.queue).(*checkUnit)
+		if u.started {
+			// The emitting goroutine needed it first.
+			c.mu.Unlock()
+			continue
+		}
+		u.started = true
+		c.ahead++
+		c.mu.Unlock()
+
+		c.runUnit(u)
+	}
+}
+
+// start creates the units of the check of a bucket and queues them.
+func (c *parallelCheck) start(root common.Pgid, path [][]byte, key []int32, inPage bool) *checkJob {
+	j := &checkJob{root: root, path: path, key: key}
+	func() {
+		// Raise the panics in the goroutine emitting the errors instead.
+		defer func() { j.panic = recover() }()
+		if db := c.tx.db; db.catchesIOErrors() {
+			defer db.recoverFault(db.guardFaults())
+		}
+		p := c.tx.page(root)
+		if !p.IsBranchPage() {
+			j.units = []*checkUnit{{pgId: root, inPage: inPage}}
+			return
+		}
+		j.split = true
+		j.units = []*checkUnit{{pgId: root, shallow: true}}
+		elems := p.BranchPageElements()
+		for i := range elems {
+			u := &checkUnit{
+				pgId:   elems[i].Pgid(),
+				stack:  []common.Pgid{root},
+				minKey: elems[i].Key(),
+				inPage: inPage,
+			}
+			if i < len(elems)-1 {
+				u.maxKey = elems[i+1].Key()
+			}
+			j.units = append(j.units, u)
+		}
+	}()
+	if j.panic != nil {
+		return j
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, u := range j.units {
+		u.id = atomic.AddInt32(&c.lastUnit, 1)
+		u.key = appendKey(j.key, 0, int32(i))
+		u.path = j.path
+		u.done = make(chan struct{})
+		heap.Push(&c.queue, u)
+		c.cond.Signal()
+	}
+	return j
+}
+
+// runUnit checks the pages of a unit, then queues the check of the nested
+// buckets it found.
+func (c *parallelCheck) runUnit(u *checkUnit) {
+	defer close(u.done)
+
+	func() {
+		// Raise the panics in the goroutine emitting the errors instead.
+		defer func() { u.panic = recover() }()
+		if db := c.tx.db; db.catchesIOErrors() {
+			defer db.recoverFault(db.guardFaults())
+		}
+		u.err = c.checkUnit(u)
+	}()
+	if u.panic != nil || u.err != nil {
+		return
+	}
+
+	for i := range u.children {
+		if ch := &u.children[i]; ch.job != nil {
+			ch.job = c.start(ch.job.root, ch.job.path, ch.job.key, false)
+		}
+	}
+}
+
+// checkUnit visits the pages of a unit and checks their keys. It stops
+// once the check is canceled, and returns the error of the context.
+func (c *parallelCheck) checkUnit(u *checkUnit) error {
+	tx := c.tx
+	hwm := tx.meta.Pgid()
+	base := len(u.stack)
+	var last []int32
+	path := u.path
+	jobKey := u.key[:len(u.key)-2]
+	unitIndex := u.key[len(u.key)-1]
+
+	visit := func(p *common.Page, depth int, stack []common.Pgid) error {
+		if err := c.progress.ctx.Err(); err != nil {
+			return err
+		}
+
+		i := len(u.visits)
+		v := checkVisit{id: p.Id(), overflow: p.Overflow(), parent: -1}
+		if d := depth - base; d > 0 {
+			v.parent = last[d-1]
+		}
+		if d := depth - base; d < len(last) {
+			last = last[:d]
+		}
+		last = append(last, int32(i))
+		u.visits = append(u.visits, v)
+		u.pageN += int64(v.overflow) + 1
+		for k := common.Pgid(0); k <= common.Pgid(v.overflow); k++ {
+			c.reach(v.id+k, u.id)
+		}
+
+		if p.Id() > hwm {
+			u.visitErrs = append(u.visitErrs, checkVisitError{at: i, pre: true,
+				err: newCheckError(berrors.ErrOutOfBounds, p.Id(), stack, path, "page %d: out of bounds: %d (stack: %v)", int(p.Id()), int(hwm), stack)})
+		}
+		if c.freed[p.Id()] {
+			u.visitErrs = append(u.visitErrs, checkVisitError{at: i,
+				err: newCheckError(berrors.ErrReachableFreed, p.Id(), stack, path, "page %d: reachable freed", int(p.Id()))})
+		} else if !p.IsBranchPage() && !p.IsLeafPage() {
+			u.visitErrs = append(u.visitErrs, checkVisitError{at: i,
+				err: newCheckError(berrors.ErrPageTypeMismatch, p.Id(), stack, path, "page %d: invalid type: %s (stack: %v)", int(p.Id()), p.Typ(), stack)})
+		}
+		if u.shallow {
+			return nil
+		}
+
+		// Find the nested buckets.
+		switch {
+		case p.IsBranchPage():
+		case p.IsLeafPage():
+			for k := range p.LeafPageElements() {
+				elem := p.LeafPageElement(uint16(k))
+				if !elem.IsBucketEntry() {
+					continue
+				}
+				child := (&Bucket{tx: tx}).openBucket(elem.Value())
+				if child.RootPage() == 0 {
+					// Ignore inline buckets.
+					continue
+				}
+				u.children = append(u.children, checkChild{job: &checkJob{
+					root: child.RootPage(),
+					path: appendPath(path, elem.Key()),
+					key:  appendKey(jobKey, 1, unitIndex, int32(len(u.children))),
+				}})
+			}
+		default:
+			if u.inPage {
+				u.children = append(u.children, checkChild{
+					err: newCheckError(berrors.ErrPageTypeMismatch, p.Id(), nil, path, "unexpected page type (flags: %x) for pgId:%d", p.Flags(), p.Id())})
+			}
+		}
+		return nil
+	}
+
+	stack := append(append([]common.Pgid{}, u.stack...), u.pgId)
+	if u.shallow {
+		return visit(tx.page(u.pgId), base, stack)
+	}
+	if err := tx.forEachPageInternal(stack, visit); err != nil {
+		return err
+	}
+
+	u.keyErrs = collectErrors(func(ch chan error) {
+		u.maxKeyInSubtree = tx.recursivelyCheckPageKeyOrderInternal(u.pgId, u.minKey, u.maxKey, u.stack, path, c.kvStringer.KeyToString, ch)
+	})
+	return nil
+}
+
+// reach marks a page as reached by the given unit, or as reached more than
+// once.
+func (c *parallelCheck) reach(id common.Pgid, unit int32) {
+	if int(id) >= len(c.owners) {
+		// Beyond the high water mark, it's resolved during the replay.
+		return
+	}
+	if atomic.CompareAndSwapInt32(&c.owners[id], 0, unit) {
+		return
+	}
+	word, bit := &c.twice[id/32], uint32(1)<<(id%32)
+	for {
+		old := atomic.LoadUint32(word)
+		if old&bit != 0 || atomic.CompareAndSwapUint32(word, old, old|bit) {
+			return
+		}
+	}
+}
+
+// reachedTwice returns true if the page was reached more than once so far.
+func (c *parallelCheck) reachedTwice(id common.Pgid) bool {
+	if int(id) >= len(c.owners) {
+		return true
+	}
+	return atomic.LoadUint32(&c.twice[id/32])&(uint32(1)<<(id%32)) != 0
+}
+
+// emit reports the errors of a bucket once its units are done, then the
+// errors of the nested buckets, in the order of the sequential check.
+func (c *parallelCheck) emit(j *checkJob) error {
+	if j.panic != nil {
+		panic(j.panic)
+	}
+	for _, u := range j.units {
+		c.wait(u)
+		if u.panic != nil {
+			panic(u.panic)
+		}
+		if u.err != nil {
+			return u.err
+		}
+		if err := c.replay(j, u); err != nil {
+			return err
+		}
+	}
+
+	if !j.split {
+		c.report(j.units[0].keyErrs...)
+	} else {
+		// Check the keys of the root page against the subtrees.
+		p := c.tx.page(j.root)
+		var runningMin []byte
+		for i, u := range j.units[1:] {
+			elem := p.BranchPageElement(uint16(i))
+			c.report(collectErrors(func(ch chan error) {
+				verifyKeyOrder(elem.Pgid(), "branch", i, elem.Key(), runningMin, nil, ch, c.kvStringer.KeyToString, []common.Pgid{j.root}, j.path)
+			})...)
+			c.report(u.keyErrs...)
+			runningMin = u.maxKeyInSubtree
+		}
+	}
+	for _, u := range j.units {
+		u.keyErrs = nil
+	}
+
+	for _, u := range j.units {
+		for _, child := range u.children {
+			if child.err != nil {
+				c.report(child.err)
+			} else if err := c.emit(child.job); err != nil {
+				return err
+			}
+		}
+		u.children = nil
+	}
+	return nil
+}
+
+// wait waits for a unit to be done, and runs it if no worker started it
+// yet, since the workers may be too far ahead to start it.
+func (c *parallelCheck) wait(u *checkUnit) {
+	c.mu.Lock()
+	if u.started {
+		c.mu.Unlock()
+		<-u.done
+		return
+	}
+	u.started = true
+	c.ahead++
+	c.mu.Unlock()
+	c.runUnit(u)
+}
+
+// replay reports the errors found on the pages visited by a unit, along
+// with the pages referenced more than once, then drops the visits. It
+// returns the error of the context once the check is canceled.
+func (c *parallelCheck) replay(j *checkJob, u *checkUnit) error {
+	errs := u.visitErrs
+	for i, v := range u.visits {
+		for len(errs) > 0 && errs[0].at == i && errs[0].pre {
+			c.report(errs[0].err)
+			errs = errs[1:]
+		}
+		for k := common.Pgid(0); k <= common.Pgid(v.overflow); k++ {
+			id := v.id + k
+			if !c.reachedTwice(id) {
+				continue
+			}
+			// The page was reached before in the order of the sequential
+			// check if it was replayed, or if it was first reached by a
+			// unit which was replayed before reaching it twice.
+			if c.shared[id] || c.replayedOwner(id, u.id) {
+				stack := u.stackOf(i)
+				c.report(newCheckError(berrors.ErrMultipleReferences, id, stack, j.path, "page %d: multiple references (stack: %v)", int(id), stack))
+			}
+			c.shared[id] = true
+		}
+		for len(errs) > 0 && errs[0].at == i {
+			c.report(errs[0].err)
+			errs = errs[1:]
+		}
+	}
+
+	for int(u.id) >= len(c.replayed) {
+		c.replayed = append(c.replayed, false)
+	}
+	c.replayed[u.id] = true
+	u.visits, u.visitErrs = nil, nil
+
+	c.mu.Lock()
+	c.ahead--
+	c.cond.Signal()
+	c.mu.Unlock()
+
+	return c.progress.add(u.pageN, j.path)
+}
+
+// replayedOwner returns true if the page was first reached by another unit
+// which was replayed.
+func (c *parallelCheck) replayedOwner(id common.Pgid, unit int32) bool {
+	if int(id) >= len(c.owners) {
+		return false
+	}
+	owner := atomic.LoadInt32(&c.owners[id])
+	return owner != unit && int(owner) < len(c.replayed) && c.replayed[owner]
+}
+
+func (c *parallelCheck) report(errs ...error) {
+	for _, err := range errs {
+		c.ch <- err
+	}
+}
+
+// stackOf returns the pages from the root of the bucket to the i-th visited
+// page.
+func (u *checkUnit) stackOf(i int) []common.Pgid {
+	var rev []common.Pgid
+	for j := int32(i); j >= 0; j = u.visits[j].parent {
+		rev = append(rev, u.visits[j].id)
+	}
+	stack := append([]common.Pgid{}, u.stack...)
+	for k := len(rev) - 1; k >= 0; k-- {
+		stack = append(stack, rev[k])
+	}
+	return stack
+}
+
+// appendKey returns the key of a unit or job of the job with the given key,
+// without modifying key.
+func appendKey(key []int32, elems ...int32) []int32 {
+	return append(key[:len(key):len(key)], elems...)
+}
+
+// checkQueue is a heap of the units to check, ordered like the sequential
+// check.
+type checkQueue []*checkUnit
+
+func (q checkQueue) Len() int { return len(q) }
+
+func (q checkQueue) Less(i, j int) bool {
+	a, b := q[i].key, q[j].key
+	for k := 0; k < len(a) && k < len(b); k++ {
+		if a[k] != b[k] {
+			return a[k] < b[k]
+		}
+	}
+	return len(a) < len(b)
+}
+
+func (q checkQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *checkQueue) Push(x interface{}) { *q = append(*q, x.(*checkUnit)) }
+
+func (q *checkQueue) Pop() interface{} {
+	old := *q
+	u := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	return u
+}
+
+// collectErrors returns the errors sent by fn to the channel.
+func collectErrors(fn func(ch chan error)) []error {
+	ch := make(chan error)
+	done := make(chan []error)
+	go func() {
+		var errs []error
+		for err := range ch {
+			errs = append(errs, err)
+		}
+		done <- errs
+	}()
+	fn(ch)
+	close(ch)
+	return <-done
+}
@@ -1,16 +1,22 @@
 package bbolt_test
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"math/rand"
 	"testing"
+	"unsafe"
 
 	"github.com/stretchr/testify/require"
 
 	"go.etcd.io/bbolt"
+	berrors "go.etcd.io/bbolt/errors"
 	"go.etcd.io/bbolt/internal/btesting"
 	"go.etcd.io/bbolt/internal/common"
 	"go.etcd.io/bbolt/internal/guts_cli"
+	"go.etcd.io/bbolt/internal/surgeon"
 )
 
 func TestTx_Check_CorruptPage(t *testing.T) {
@@ -120,6 +126,225 @@ func TestTx_Check_WithNestBucket(t *testing.T) {
 	db.MustClose()
 }
 
+func TestTx_Check_CheckError(t *testing.T) {
+	bucketName := []byte("data")
+	db := btesting.MustCreateDBWithOption(t, &bbolt.Options{PageSize: 4096})
+	err := db.Fill(bucketName, 1, 100,
+		func(tx int, k int) []byte { return []byte(fmt.Sprintf("%04d", k)) },
+		func(tx int, k int) []byte { return make([]byte, 100) },
+	)
+	require.NoError(t, err)
+	rootPageId := mustGetBucketRootPage(t, db.DB, bucketName)
+
+	checkErrors := func() []*berrors.CheckError {
+		var cErrs []*berrors.CheckError
+		require.NoError(t, db.View(func(tx *bbolt.Tx) error {
+			for err := range tx.Check() {
+				var cErr *berrors.CheckError
+				require.True(t, errors.As(err, &cErr), "unexpected error: %v", err)
+				cErrs = append(cErrs, cErr)
+			}
+			return nil
+		}))
+		return cErrs
+	}
+
+	t.Log("Corrupting a random leaf page.")
+	victimPageId, _ := corruptRandomLeafPageInBucket(t, db.DB, bucketName)
+	db.MustClose()
+	db.MustReopen()
+
+	cErrs := checkErrors()
+	require.Len(t, cErrs, 1)
+	require.ErrorIs(t, cErrs[0], berrors.ErrKeyOrder)
+	require.Equal(t, berrors.SeverityCritical, cErrs[0].Severity)
+	require.Equal(t, uint64(victimPageId), cErrs[0].Pgid)
+	require.Equal(t, []uint64{uint64(rootPageId), uint64(victimPageId)}, cErrs[0].Stack)
+	require.Equal(t, [][]byte{bucketName}, cErrs[0].Bucket)
+
+	t.Log("Dropping all the leaf pages but the first one from the bucket.")
+	db.MustClose()
+	rootPage, _, err := guts_cli.ReadPage(db.Path(), uint64(rootPageId))
+	require.NoError(t, err)
+	var dropped []uint64
+	for _, elem := range rootPage.BranchPageElements()[1:] {
+		dropped = append(dropped, uint64(elem.Pgid()))
+	}
+	_, err = surgeon.ClearPageElements(db.Path(), rootPageId, 1, -1, false)
+	require.NoError(t, err)
+	db.MustReopen()
+
+	var unreachable []uint64
+	for _, cErr := range checkErrors() {
+		if cErr.Pgid == uint64(victimPageId) && errors.Is(cErr, berrors.ErrKeyOrder) {
+			// The corrupted page is the one left in the bucket.
+			continue
+		}
+		require.ErrorIs(t, cErr, berrors.ErrUnreachableUnfreed)
+		require.Equal(t, berrors.SeverityWarning, cErr.Severity)
+		require.Nil(t, cErr.Stack)
+		require.Nil(t, cErr.Bucket)
+		unreachable = append(unreachable, cErr.Pgid)
+	}
+	require.ElementsMatch(t, dropped, unreachable)
+
+	// The database is inconsistent, don't check it again on cleanup.
+	db.MustClose()
+}
+
+func TestTx_Check_WithParallelism(t *testing.T) {
+	bucketName := []byte("data")
+	db := btesting.MustCreateDBWithOption(t, &bbolt.Options{PageSize: 4096})
+
+	err := db.Update(func(tx *bbolt.Tx) error {
+		b, bErr := tx.CreateBucket(bucketName)
+		if bErr != nil {
+			return bErr
+		}
+		for i := 0; i < 1000; i++ {
+			if pErr := b.Put([]byte(fmt.Sprintf("%04d", i)), make([]byte, 100)); pErr != nil {
+				return pErr
+			}
+		}
+		for i := 0; i < 5; i++ {
+			cb, bErr := b.CreateBucket([]byte(fmt.Sprintf("nested_%d", i)))
+			if bErr != nil {
+				return bErr
+			}
+			for j := 0; j < 200*i; j++ {
+				if pErr := cb.Put([]byte(fmt.Sprintf("%04d", j)), make([]byte, 100)); pErr != nil {
+					return pErr
+				}
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	rootPageId := mustGetBucketRootPage(t, db.DB, bucketName)
+
+	// checkErrors returns the errors of the check with the given options,
+	// once sequentially and once in parallel.
+	checkErrors := func(options ...bbolt.CheckOption) (seq, par []error) {
+		require.NoError(t, db.View(func(tx *bbolt.Tx) error {
+			for err := range tx.Check(options...) {
+				seq = append(seq, err)
+			}
+			for err := range tx.Check(append(options, bbolt.WithParallelism(4))...) {
+				par = append(par, err)
+			}
+			return nil
+		}))
+		return seq, par
+	}
+
+	t.Log("Check the consistent db.")
+	seq, par := checkErrors()
+	require.Empty(t, seq)
+	require.Empty(t, par)
+
+	t.Log("Corrupt a leaf page and drop a subtree of the bucket.")
+	victimPageId, _ := corruptRandomLeafPageInBucket(t, db.DB, bucketName)
+	db.MustClose()
+	_, err = surgeon.ClearPageElements(db.Path(), rootPageId, 3, 4, false)
+	require.NoError(t, err)
+	db.MustReopen()
+
+	seq, par = checkErrors()
+	require.NotEmpty(t, seq)
+	require.Equal(t, seq, par)
+
+	for _, pgId := range []common.Pgid{rootPageId, victimPageId} {
+		seq, par = checkErrors(bbolt.WithPageId(uint64(pgId)))
+		require.Equal(t, seq, par)
+	}
+
+	db.MustClose()
+}
+
+func TestTx_Check_WithParallelism_ReadError(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bbolt.Options{PageSize: 4096})
+	require.NoError(t, db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("data"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 100; i++ {
+			if err := b.Put([]byte(fmt.Sprintf("%04d", i)), make([]byte, 100)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	path := db.Path()
+	db.MustClose()
+
+	// Make the root page of the nested bucket point past the end of the file.
+	root, _, err := guts_cli.GetRootPage(path)
+	require.NoError(t, err)
+	p, buf, err := guts_cli.ReadPage(path, uint64(root))
+	require.NoError(t, err)
+	require.True(t, p.IsLeafPage())
+	v := p.LeafPageElement(0).Value()
+	(*common.InBucket)(unsafe.Pointer(&v[0])).SetRootPage(1000)
+	require.NoError(t, guts_cli.WritePage(path, buf))
+
+	rdb, err := bbolt.Open(path, 0600, &bbolt.Options{NoMmap: true, ReadOnly: true})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, rdb.Close()) }()
+
+	// The page which can't be read is reported instead of crashing.
+	require.NoError(t, rdb.View(func(tx *bbolt.Tx) error {
+		for _, parallelism := range []int{1, 4} {
+			var errs []error
+			for err := range tx.Check(bbolt.WithParallelism(parallelism)) {
+				errs = append(errs, err)
+			}
+			require.NotEmpty(t, errs)
+			require.ErrorIs(t, errs[len(errs)-1], io.EOF)
+		}
+		return nil
+	}))
+}
+
+func TestTx_Check_WithProgress(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bbolt.Options{PageSize: 4096})
+	require.NoError(t, db.Fill([]byte("data"), 10, 100,
+		func(tx int, k int) []byte { return []byte(fmt.Sprintf("%02d%04d", tx, k)) },
+		func(tx int, k int) []byte { return make([]byte, 100) },
+	))
+
+	for _, parallelism := range []int{1, 4} {
+		t.Run(fmt.Sprintf("parallelism=%d", parallelism), func(t *testing.T) {
+			require.NoError(t, db.View(func(tx *bbolt.Tx) error {
+				var last bbolt.Progress
+				for err := range tx.Check(bbolt.WithParallelism(parallelism), bbolt.WithProgress(func(p bbolt.Progress) { last = p })) {
+					require.NoError(t, err)
+				}
+				require.Equal(t, bbolt.ProgressPages, last.Unit)
+				require.Equal(t, int64(tx.Size())/4096, last.Total)
+				require.Equal(t, last.Total, last.Done)
+
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				var errs []error
+				for err := range tx.Check(bbolt.WithParallelism(parallelism), bbolt.WithContext(ctx)) {
+					errs = append(errs, err)
+				}
+				require.Equal(t, []error{context.Canceled}, errs)
+
+				// The walk of the pages stops too.
+				errs = nil
+				root := uint64(tx.Bucket([]byte("data")).RootPage())
+				for err := range tx.Check(bbolt.WithParallelism(parallelism), bbolt.WithContext(ctx), bbolt.WithPageId(root)) {
+					errs = append(errs, err)
+				}
+				require.Equal(t, []error{context.Canceled}, errs)
+				return nil
+			}))
+		})
+	}
+}
+
 // corruptRandomLeafPage corrupts one random leaf page.
 func corruptRandomLeafPageInBucket(t testing.TB, db *bbolt.DB, bucketName []byte) (victimPageId common.Pgid, validPageIds []common.Pgid) {
 	bucketRootPageId := mustGetBucketRootPage(t, db, bucketName)
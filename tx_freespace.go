@@ -0,0 +1,168 @@
+package bbolt
+
+import (
+	"sort"
+
+	berrors "go.etcd.io/bbolt/errors"
+	"go.etcd.io/bbolt/internal/common"
+)
+
+// FreeSpaceReport describes how the free space is distributed across the
+// database file, and roughly how much space a compaction would reclaim.
+type FreeSpaceReport struct {
+	PageSize int `json:"pageSize"` // size of a page in bytes
+	PageN    int `json:"pageN"`    // number of pages below the high water mark
+
+	// Freelist statistics.
+	FreePageN     int           `json:"freePageN"`     // number of free pages
+	PendingPageN  int           `json:"pendingPageN"`  // number of pages pending release
+	TailFreePageN int           `json:"tailFreePageN"` // number of free pages adjacent to the high water mark
+	FreeSpans     []FreeSpanBin `json:"freeSpans"`     // histogram of free span sizes
+
+	// Per-bucket page locality, ordered by bucket path.
+	Buckets []BucketLocality `json:"buckets"`
+
+	// EstimatedCompactedSize is the estimated size in bytes of the database
+	// after compaction, computed from the in-use bytes of all buckets.
+	EstimatedCompactedSize int64 `json:"estimatedCompactedSize"`
+
+	// Pending pages pinned by each open read-only transaction, including
+	// this one if it's read-only.
+	Readers []ReaderPending `json:"readers,omitempty"`
+}
+
+// FreeSpanBin counts the free spans whose size is within [MinSize, MaxSize].
+type FreeSpanBin struct {
+	MinSize int `json:"minSize"` // minimum span size in pages
+	MaxSize int `json:"maxSize"` // maximum span size in pages
+	SpanN   int `json:"spanN"`   // number of spans in the bin
+	PageN   int `json:"pageN"`   // number of pages covered by the spans
+}
+
+// BucketLocality describes how scattered the leaf pages of a bucket are.
+type BucketLocality struct {
+	Path      []string `json:"path"`      // names of the buckets from the root
+	LeafPageN int      `json:"leafPageN"` // number of leaf pages
+
+	// AvgLeafDistance is the average distance in pages between logically
+	// adjacent leaf pages. A value of 1 means the leaves are laid out
+	// sequentially in the file.
+	AvgLeafDistance float64 `json:"avgLeafDistance"`
+}
+
+// ReaderPending reports the pending pages that a read-only transaction
+// prevents from being released.
+type ReaderPending struct {
+	Txid         int `json:"txid"`         // id of the read-only transaction
+	PendingPageN int `json:"pendingPageN"` // number of pending pages pinned by it
+}
+
+// FreeSpaceReport analyzes the fragmentation of the database file as seen by
+// this transaction. Like Check, it is safe to run on a writable transaction;
+// on a read-only transaction no writer transaction should run at the same time.
+func (tx *Tx) FreeSpaceReport() (FreeSpaceReport, error) {
+	if tx.db == nil {
+		return FreeSpaceReport{}, berrors.ErrTxClosed
+	}
+
+	// Force loading free list if opened in ReadOnly mode.
+	tx.db.loadFreelist()
+
+	r := FreeSpaceReport{
+		PageSize:     tx.db.pageSize,
+		PageN:        int(tx.meta.Pgid()),
+		FreePageN:    tx.db.freelist.FreeCount(),
+		PendingPageN: tx.db.freelist.PendingCount(),
+	}
+
+	spans := tx.db.freelist.FreeSpans()
+	for _, s := range spans {
+		r.addFreeSpan(s.Size)
+	}
+	if n := len(spans); n > 0 && spans[n-1].Start+common.Pgid(spans[n-1].Size) == tx.meta.Pgid() {
+		r.TailFreePageN = spans[n-1].Size
+	}
+
+	tx.collectBucketLocality(&tx.root, nil, &r)
+
+	// Two meta pages plus a freelist page, and the in-use bytes of all buckets
+	// packed into full pages.
+	s := tx.root.Stats()
+	pageN := 3 + (s.BranchInuse+tx.db.pageSize-1)/tx.db.pageSize + (s.LeafInuse+tx.db.pageSize-1)/tx.db.pageSize
+	r.EstimatedCompactedSize = int64(pageN) * int64(tx.db.pageSize)
+
+	r.Readers = tx.db.pendingByReader()
+
+	return r, nil
+}
+
+// addFreeSpan adds a span of the given size into a power of two sized bin.
+func (r *FreeSpaceReport) addFreeSpan(size int) {
+	minSize, maxSize := 1, 1
+	for maxSize < size {
+		minSize, maxSize = maxSize+1, maxSize*2
+	}
+	for i := range r.FreeSpans {
+		if r.FreeSpans[i].MinSize == minSize {
+			r.FreeSpans[i].SpanN++
+			r.FreeSpans[i].PageN += size
+			return
+		}
+	}
+	r.FreeSpans = append(r.FreeSpans, FreeSpanBin{MinSize: minSize, MaxSize: maxSize, SpanN: 1, PageN: size})
+	sort.Slice(r.FreeSpans, func(i, j int) bool { return r.FreeSpans[i].MinSize < r.FreeSpans[j].MinSize })
+}
+
+// collectBucketLocality records the leaf page locality of all the non-inline
+// buckets nested in b.
+func (tx *Tx) collectBucketLocality(b *Bucket, path []string, r *FreeSpaceReport) {
+	_ = b.ForEachBucket(func(k []byte) error {
+		child := b.Bucket(k)
+		if child == nil || child.RootPage() == 0 {
+			return nil
+		}
+		childPath := append(append([]string{}, path...), string(k))
+
+		var leaves []common.Pgid
+		child.forEachPage(func(p *common.Page, _ int, _ []common.Pgid) {
+			if p.IsLeafPage() {
+				leaves = append(leaves, p.Id())
+			}
+		})
+		bl := BucketLocality{Path: childPath, LeafPageN: len(leaves)}
+		if len(leaves) > 1 {
+			var total int64
+			for i := 1; i < len(leaves); i++ {
+				d := int64(leaves[i]) - int64(leaves[i-1])
+				if d < 0 {
+					d = -d
+				}
+				total += d
+			}
+			bl.AvgLeafDistance = float64(total) / float64(len(leaves)-1)
+		}
+		r.Buckets = append(r.Buckets, bl)
+
+		tx.collectBucketLocality(child, childPath, r)
+		return nil
+	})
+}
+
+// pendingByReader returns the number of pending pages pinned by each open
+// read-only transaction, ordered by transaction id.
+func (db *DB) pendingByReader() []ReaderPending {
+	db.metalock.Lock()
+	txids := make([]common.Txid, 0, len(db.txs))
+	for _, t := range db.txs {
+		txids = append(txids, t.meta.Txid())
+	}
+	pending := db.freelist.PendingCountByTxid()
+	db.metalock.Unlock()
+	sort.Slice(txids, func(i, j int) bool { return txids[i] < txids[j] })
+
+	var readers []ReaderPending
+	for _, txid := range txids {
+		readers = append(readers, ReaderPending{Txid: int(txid), PendingPageN: pinnedPendingPageN(pending, txid)})
+	}
+	return readers
+}
@@ -0,0 +1,81 @@
+package bbolt_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"go.etcd.io/bbolt"
+	"go.etcd.io/bbolt/internal/btesting"
+)
+
+func TestTx_FreeSpaceReport(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bbolt.Options{PageSize: 4096, InitialMmapSize: 1 << 24})
+
+	err := db.Fill([]byte("data"), 4, 250,
+		func(tx int, k int) []byte { return []byte(fmt.Sprintf("%04d%04d", tx, k)) },
+		func(tx int, k int) []byte { return make([]byte, 100) },
+	)
+	require.NoError(t, err)
+
+	// Keep a reader open so that the deleted pages stay pending.
+	rtx, err := db.Begin(false)
+	require.NoError(t, err)
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("data"))
+		for k := 0; k < 250; k++ {
+			if err := b.Delete([]byte(fmt.Sprintf("%04d%04d", 1, k))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		r, err := tx.FreeSpaceReport()
+		require.NoError(t, err)
+
+		require.Equal(t, 4096, r.PageSize)
+		require.Greater(t, r.PendingPageN, 0)
+		require.Len(t, r.Readers, 1)
+		require.Equal(t, rtx.ID(), r.Readers[0].Txid)
+		require.Greater(t, r.Readers[0].PendingPageN, 0)
+
+		var spanPageN int
+		for _, bin := range r.FreeSpans {
+			require.LessOrEqual(t, bin.MinSize, bin.MaxSize)
+			spanPageN += bin.PageN
+		}
+		require.Equal(t, r.FreePageN, spanPageN)
+
+		require.Len(t, r.Buckets, 1)
+		require.Equal(t, []string{"data"}, r.Buckets[0].Path)
+		require.Greater(t, r.Buckets[0].LeafPageN, 1)
+		require.GreaterOrEqual(t, r.Buckets[0].AvgLeafDistance, 1.0)
+
+		require.Less(t, r.EstimatedCompactedSize, tx.Size())
+		return nil
+	})
+	require.NoError(t, err)
+
+	// The reader sees the pending pages it pins too.
+	r, err := rtx.FreeSpaceReport()
+	require.NoError(t, err)
+	require.Len(t, r.Readers, 1)
+	require.Equal(t, rtx.ID(), r.Readers[0].Txid)
+	require.Greater(t, r.Readers[0].PendingPageN, 0)
+	require.NoError(t, rtx.Rollback())
+
+	// After the reader is gone the pending pages are released.
+	err = db.Update(func(tx *bbolt.Tx) error {
+		r, err := tx.FreeSpaceReport()
+		require.NoError(t, err)
+		require.Empty(t, r.Readers)
+		require.Greater(t, r.FreePageN, 0)
+		return nil
+	})
+	require.NoError(t, err)
+}
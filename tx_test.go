@@ -2,6 +2,7 @@ package bbolt_test
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"log"
@@ -641,6 +642,30 @@ func TestTx_CopyFile_Error_Normal(t *testing.T) {
 	}
 }
 
+// Ensure that WriteToContext reports its progress and stops once canceled.
+func TestTx_WriteToContext(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.Fill([]byte("data"), 1, 1000,
+		func(tx int, k int) []byte { return []byte(fmt.Sprintf("%04d", k)) },
+		func(tx int, k int) []byte { return make([]byte, 100) },
+	))
+
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		var last bolt.Progress
+		var buf bytes.Buffer
+		n, err := tx.WriteToContext(context.Background(), &buf, func(p bolt.Progress) { last = p })
+		require.NoError(t, err)
+		require.Equal(t, tx.Size(), n)
+		require.Equal(t, bolt.Progress{Unit: bolt.ProgressBytes, Done: n, Total: n}, last)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err = tx.WriteToContext(ctx, &buf, nil)
+		require.ErrorIs(t, err, context.Canceled)
+		return nil
+	}))
+}
+
 // TestTx_Rollback ensures there is no error when tx rollback whether we sync freelist or not.
 func TestTx_Rollback(t *testing.T) {
 	for _, isSyncFreelist := range []bool{false, true} {
@@ -948,6 +973,9 @@ func TestTxStats_GetAndIncAtomically(t *testing.T) {
 	stats.IncWriteTime(100001 * time.Second)
 	assert.Equal(t, 100001*time.Second, stats.GetWriteTime())
 
+	stats.IncSync(100002)
+	assert.Equal(t, int64(100002), stats.GetSync())
+
 	assert.Equal(t,
 		bolt.TxStats{
 			PageCount:     1,
@@ -962,6 +990,7 @@ func TestTxStats_GetAndIncAtomically(t *testing.T) {
 			SpillTime:     10001 * time.Second,
 			Write:         100000,
 			WriteTime:     100001 * time.Second,
+			Sync:          100002,
 		},
 		stats,
 	)
@@ -981,6 +1010,7 @@ func TestTxStats_Sub(t *testing.T) {
 		SpillTime:     10001 * time.Second,
 		Write:         100000,
 		WriteTime:     100001 * time.Second,
+		Sync:          100002,
 	}
 
 	statsB := bolt.TxStats{
@@ -996,6 +1026,7 @@ func TestTxStats_Sub(t *testing.T) {
 		SpillTime:     11002 * time.Second,
 		Write:         110001,
 		WriteTime:     110010 * time.Second,
+		Sync:          110003,
 	}
 
 	diff := statsB.Sub(&statsA)
@@ -1011,6 +1042,53 @@ func TestTxStats_Sub(t *testing.T) {
 	assert.Equal(t, 1001*time.Second, diff.GetSpillTime())
 	assert.Equal(t, int64(10001), diff.GetWrite())
 	assert.Equal(t, 10009*time.Second, diff.GetWriteTime())
+	assert.Equal(t, int64(10001), diff.GetSync())
+}
+
+// Ensure that adjacent dirty pages are written with a single syscall.
+func TestTx_Commit_CoalescedWrites(t *testing.T) {
+	for _, concurrency := range []int{0, 4} {
+		t.Run(fmt.Sprintf("concurrency=%d", concurrency), func(t *testing.T) {
+			db := btesting.MustCreateDBWithOption(t, &bolt.Options{WriteConcurrency: concurrency})
+
+			before := db.Stats()
+			err := db.Update(func(tx *bolt.Tx) error {
+				b, err := tx.CreateBucket([]byte("widgets"))
+				if err != nil {
+					return err
+				}
+				for i := 0; i < 1000; i++ {
+					if err := b.Put([]byte(fmt.Sprintf("%04d", i)), make([]byte, 500)); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			require.NoError(t, err)
+			after := db.Stats()
+			stats := after.TxStats.Sub(&before.TxStats)
+
+			var pageN int
+			require.NoError(t, db.View(func(tx *bolt.Tx) error {
+				pageN = int(tx.Size()) / db.Info().PageSize
+				return nil
+			}))
+
+			// The freshly allocated pages are contiguous, so the number of
+			// writes must be much smaller than the number of pages.
+			require.Greater(t, pageN, 100)
+			require.Less(t, stats.GetWrite(), int64(10))
+			require.Equal(t, int64(2), stats.GetSync())
+
+			db.MustCheck()
+			db.MustClose()
+			db.MustReopen()
+			require.NoError(t, db.View(func(tx *bolt.Tx) error {
+				require.Equal(t, 1000, tx.Bucket([]byte("widgets")).Stats().KeyN)
+				return nil
+			}))
+		})
+	}
 }
 
 // TestTx_TruncateBeforeWrite ensures the file is truncated ahead whether we sync freelist or not.
@@ -7,9 +7,11 @@ import (
 	"fmt"
 	"testing"
 
+	"github.com/stretchr/testify/require"
 	"golang.org/x/sys/unix"
 
 	bolt "go.etcd.io/bbolt"
+	berrors "go.etcd.io/bbolt/errors"
 	"go.etcd.io/bbolt/internal/btesting"
 )
 
@@ -75,6 +77,57 @@ func TestMlock_DbCanGrow_Big(t *testing.T) {
 	}
 }
 
+func TestDB_MlockBucket(t *testing.T) {
+	// 4MB
+	skipOnMemlockLimitBelow(t, 4*1024*1024)
+
+	db := btesting.MustCreateDB(t)
+	fillWidgets(t, db)
+	insertChunk(t, db, 0)
+
+	require.ErrorIs(t, db.MlockBucket([]byte("gadgets")), berrors.ErrBucketNotFound)
+	require.Zero(t, db.Stats().MlockBytes)
+
+	require.NoError(t, db.MlockBucket([]byte("widgets")))
+	widgets := db.Stats().MlockBytes
+	require.Positive(t, widgets)
+	require.Less(t, int64(widgets), fileSize(db.Path()))
+
+	// Locking all the buckets locks more pages.
+	require.NoError(t, db.MlockBucket())
+	require.Greater(t, db.Stats().MlockBytes, widgets)
+	require.NoError(t, db.MunlockBucket())
+	require.Equal(t, widgets, db.Stats().MlockBytes)
+
+	// The pages written by commits and the remapping of the file are
+	// followed.
+	for chunk := 0; chunk < 4; chunk++ {
+		require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+			b := tx.Bucket([]byte("widgets"))
+			for i := 0; i < 1000; i++ {
+				if err := b.Put([]byte(fmt.Sprintf("grow-%d-%d", chunk, i)), make([]byte, 200)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+	}
+	require.Greater(t, db.Stats().MlockBytes, widgets)
+	db.MustCheck()
+
+	require.NoError(t, db.MunlockBucket([]byte("widgets")))
+	require.Zero(t, db.Stats().MlockBytes)
+}
+
+func TestDB_MlockBucket_Mlock(t *testing.T) {
+	// 32KB
+	skipOnMemlockLimitBelow(t, 32*1024)
+
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{Mlock: true})
+	require.Positive(t, db.Stats().MlockBytes)
+	require.ErrorContains(t, db.MlockBucket(), "already locked")
+}
+
 func insertChunk(t *testing.T, db *btesting.DB, chunkId int) {
 	chunkSize := 1024
 